@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/auth"
+)
+
+// New returns the operator facing admin API. It is protected by the same credentials as the broker API.
+func New(router *mux.Router, credentials brokerapi.BrokerCredentials) http.Handler {
+	return auth.NewWrapper(credentials.Username, credentials.Password).Wrap(router)
+}
+
+type errorResponse struct {
+	Description string `json:"description"`
+}
+
+func respond(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func respondError(w http.ResponseWriter, status int, err error) {
+	respond(w, status, errorResponse{Description: err.Error()})
+}
@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/archive"
+	"github.com/vchrisr/concourse-broker/restore"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// AttachArchiveRoutes adds the endpoints to find the archives of destroyed teams, to download them
+// and to restore a team from one. The restore body is optional.
+//
+//	GET  /admin/archives?team=<team name>&instance=<instance id>
+//	GET  /admin/archives/<archive id>
+//	POST /admin/archives/<archive id>/restore {"instance_id": "<instance id>"}
+func AttachArchiveRoutes(router *mux.Router, s store.Store, archiver *archive.Archiver, restorer *restore.Restorer,
+	logger lager.Logger) {
+	handler := archiveHandler{store: s, archiver: archiver, restorer: restorer, logger: logger.Session("admin-archives")}
+	router.HandleFunc("/admin/archives", handler.find).Methods("GET")
+	router.HandleFunc("/admin/archives/{id}", handler.download).Methods("GET")
+	router.HandleFunc("/admin/archives/{id}/restore", handler.restore).Methods("POST")
+}
+
+type archiveHandler struct {
+	store    store.Store
+	archiver *archive.Archiver
+	restorer *restore.Restorer
+	logger   lager.Logger
+}
+
+func (h archiveHandler) find(w http.ResponseWriter, req *http.Request) {
+	records, err := store.FindArchives(h.store, req.FormValue("team"), req.FormValue("instance"))
+	if err != nil {
+		h.logger.Error("find-error", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respond(w, http.StatusOK, records)
+}
+
+func (h archiveHandler) download(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	if !h.exists(w, id) {
+		return
+	}
+	r, err := h.archiver.Open(id)
+	if err != nil {
+		h.logger.Error("download-error", err, lager.Data{"archive-id": id})
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer r.Close()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".tgz"))
+	io.Copy(w, r)
+}
+
+func (h archiveHandler) restore(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	if !h.exists(w, id) {
+		return
+	}
+	var options restore.Options
+	if req.ContentLength != 0 {
+		err := json.NewDecoder(req.Body).Decode(&options)
+		if err != nil && err != io.EOF {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("Invalid restore options: %s", err))
+			return
+		}
+	}
+	result, err := h.restorer.Restore(id, options)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respond(w, http.StatusOK, result)
+}
+
+// exists responds with a 404 when there is no archive with the given ID.
+func (h archiveHandler) exists(w http.ResponseWriter, id string) bool {
+	_, found, err := store.GetArchive(h.store, id)
+	if err != nil {
+		h.logger.Error("get-error", err, lager.Data{"archive-id": id})
+		respondError(w, http.StatusInternalServerError, err)
+		return false
+	}
+	if !found {
+		respondError(w, http.StatusNotFound, fmt.Errorf("Archive %s does not exist", id))
+		return false
+	}
+	return true
+}
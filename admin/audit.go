@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/audit"
+)
+
+// AttachAuditRoutes adds the endpoint to query the audit trail by time range and team.
+//
+//	GET /admin/audit?from=<RFC3339>&to=<RFC3339>&team=<team name>
+func AttachAuditRoutes(router *mux.Router, auditLog audit.Log, logger lager.Logger) {
+	handler := auditHandler{auditLog: auditLog, logger: logger.Session("admin-audit")}
+	router.HandleFunc("/admin/audit", handler.query).Methods("GET")
+}
+
+type auditHandler struct {
+	auditLog audit.Log
+	logger   lager.Logger
+}
+
+func (h auditHandler) query(w http.ResponseWriter, req *http.Request) {
+	query := audit.Query{TeamName: req.FormValue("team")}
+	var err error
+	query.From, err = parseTime(req.FormValue("from"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	query.To, err = parseTime(req.FormValue("to"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	entries, err := h.auditLog.Query(query)
+	if err != nil {
+		h.logger.Error("query-error", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respond(w, http.StatusOK, entries)
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time %q, expected RFC3339", value)
+	}
+	return t, nil
+}
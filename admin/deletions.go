@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/softdelete"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// AttachDeletionRoutes adds the endpoints to list soft deleted teams and to undelete one before it is destroyed.
+//
+//	GET  /admin/deletions
+//	POST /admin/deletions/<team name>/undelete
+func AttachDeletionRoutes(router *mux.Router, s store.Store, deleter *softdelete.Deleter, logger lager.Logger) {
+	handler := deletionHandler{store: s, deleter: deleter, logger: logger.Session("admin-deletions")}
+	router.HandleFunc("/admin/deletions", handler.list).Methods("GET")
+	router.HandleFunc("/admin/deletions/{team}/undelete", handler.undelete).Methods("POST")
+}
+
+type deletionHandler struct {
+	store   store.Store
+	deleter *softdelete.Deleter
+	logger  lager.Logger
+}
+
+func (h deletionHandler) list(w http.ResponseWriter, req *http.Request) {
+	deletions, err := store.ListDeletions(h.store)
+	if err != nil {
+		h.logger.Error("list-error", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respond(w, http.StatusOK, deletions)
+}
+
+func (h deletionHandler) undelete(w http.ResponseWriter, req *http.Request) {
+	team := mux.Vars(req)["team"]
+	result, err := h.deleter.Undelete(team)
+	if err == softdelete.ErrNotDeleted {
+		respondError(w, http.StatusNotFound, fmt.Errorf("Team %s is not soft deleted", team))
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respond(w, http.StatusOK, result)
+}
@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/exposure"
+)
+
+// AttachExposureRoutes adds the endpoints to read the pipelines the last exposure run hid or exposed and to run right away.
+//
+//	GET  /admin/exposure
+//	POST /admin/exposure
+func AttachExposureRoutes(router *mux.Router, enforcer *exposure.Enforcer, logger lager.Logger) {
+	handler := exposureHandler{enforcer: enforcer, logger: logger.Session("admin-exposure")}
+	router.HandleFunc("/admin/exposure", handler.last).Methods("GET")
+	router.HandleFunc("/admin/exposure", handler.run).Methods("POST")
+}
+
+type exposureHandler struct {
+	enforcer *exposure.Enforcer
+	logger   lager.Logger
+}
+
+func (h exposureHandler) last(w http.ResponseWriter, req *http.Request) {
+	respond(w, http.StatusOK, h.enforcer.LastReport())
+}
+
+func (h exposureHandler) run(w http.ResponseWriter, req *http.Request) {
+	report, err := h.enforcer.Run()
+	if err != nil {
+		h.logger.Error("run-error", err)
+		respond(w, http.StatusInternalServerError, report)
+		return
+	}
+	respond(w, http.StatusOK, report)
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/footprint"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// AttachFootprintRoutes adds the endpoints to read the containers and volumes the last footprint
+// check found, as a report or as Prometheus metrics, and to check right away.
+//
+//	GET  /admin/footprint
+//	GET  /admin/footprint/metrics
+//	POST /admin/footprint
+func AttachFootprintRoutes(router *mux.Router, s store.Store, enforcer *footprint.Enforcer, logger lager.Logger) {
+	handler := footprintHandler{store: s, enforcer: enforcer, logger: logger.Session("admin-footprint")}
+	router.HandleFunc("/admin/footprint", handler.last).Methods("GET")
+	router.HandleFunc("/admin/footprint/metrics", handler.metrics).Methods("GET")
+	router.HandleFunc("/admin/footprint", handler.run).Methods("POST")
+}
+
+type footprintHandler struct {
+	store    store.Store
+	enforcer *footprint.Enforcer
+	logger   lager.Logger
+}
+
+func (h footprintHandler) last(w http.ResponseWriter, req *http.Request) {
+	respond(w, http.StatusOK, h.enforcer.LastReport())
+}
+
+func (h footprintHandler) metrics(w http.ResponseWriter, req *http.Request) {
+	footprints, err := store.ListFootprints(h.store)
+	if err != nil {
+		h.logger.Error("list-error", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	err = footprint.WriteMetrics(w, footprints)
+	if err != nil {
+		h.logger.Error("write-metrics-error", err)
+	}
+}
+
+func (h footprintHandler) run(w http.ResponseWriter, req *http.Request) {
+	report, err := h.enforcer.Run()
+	if err != nil {
+		h.logger.Error("run-error", err)
+		respond(w, http.StatusInternalServerError, report)
+		return
+	}
+	respond(w, http.StatusOK, report)
+}
@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/gc"
+)
+
+// AttachGCRoutes adds the endpoints to read the last garbage collection report and to collect right away.
+//
+//	GET  /admin/gc
+//	POST /admin/gc
+func AttachGCRoutes(router *mux.Router, collector *gc.Collector, logger lager.Logger) {
+	handler := gcHandler{collector: collector, logger: logger.Session("admin-gc")}
+	router.HandleFunc("/admin/gc", handler.last).Methods("GET")
+	router.HandleFunc("/admin/gc", handler.run).Methods("POST")
+}
+
+type gcHandler struct {
+	collector *gc.Collector
+	logger    lager.Logger
+}
+
+func (h gcHandler) last(w http.ResponseWriter, req *http.Request) {
+	respond(w, http.StatusOK, h.collector.LastReport())
+}
+
+func (h gcHandler) run(w http.ResponseWriter, req *http.Request) {
+	report, err := h.collector.Run()
+	if err != nil {
+		h.logger.Error("run-error", err)
+		respond(w, http.StatusInternalServerError, report)
+		return
+	}
+	respond(w, http.StatusOK, report)
+}
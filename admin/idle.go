@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/idle"
+)
+
+// AttachIdleRoutes adds the endpoints to read the activity the last idle check found and to check right away.
+//
+//	GET  /admin/idle
+//	POST /admin/idle
+func AttachIdleRoutes(router *mux.Router, detector *idle.Detector, logger lager.Logger) {
+	handler := idleHandler{detector: detector, logger: logger.Session("admin-idle")}
+	router.HandleFunc("/admin/idle", handler.last).Methods("GET")
+	router.HandleFunc("/admin/idle", handler.run).Methods("POST")
+}
+
+type idleHandler struct {
+	detector *idle.Detector
+	logger   lager.Logger
+}
+
+func (h idleHandler) last(w http.ResponseWriter, req *http.Request) {
+	respond(w, http.StatusOK, h.detector.LastReport())
+}
+
+func (h idleHandler) run(w http.ResponseWriter, req *http.Request) {
+	report, err := h.detector.Run()
+	if err != nil {
+		h.logger.Error("run-error", err)
+		respond(w, http.StatusInternalServerError, report)
+		return
+	}
+	respond(w, http.StatusOK, report)
+}
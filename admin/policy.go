@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/policy"
+)
+
+// AttachPolicyRoutes adds the endpoints to read the pipeline policy violations the last scan found and to scan right away.
+//
+//	GET  /admin/policy
+//	POST /admin/policy
+func AttachPolicyRoutes(router *mux.Router, scanner *policy.Scanner, logger lager.Logger) {
+	handler := policyHandler{scanner: scanner, logger: logger.Session("admin-policy")}
+	router.HandleFunc("/admin/policy", handler.last).Methods("GET")
+	router.HandleFunc("/admin/policy", handler.run).Methods("POST")
+}
+
+type policyHandler struct {
+	scanner *policy.Scanner
+	logger  lager.Logger
+}
+
+func (h policyHandler) last(w http.ResponseWriter, req *http.Request) {
+	respond(w, http.StatusOK, h.scanner.LastReport())
+}
+
+func (h policyHandler) run(w http.ResponseWriter, req *http.Request) {
+	report, err := h.scanner.Run()
+	if err != nil {
+		h.logger.Error("run-error", err)
+		respond(w, http.StatusInternalServerError, report)
+		return
+	}
+	respond(w, http.StatusOK, report)
+}
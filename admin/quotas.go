@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/quota"
+)
+
+// AttachQuotaRoutes adds the endpoints to read the usage the last quota check found and to check right away.
+//
+//	GET  /admin/quotas
+//	POST /admin/quotas
+func AttachQuotaRoutes(router *mux.Router, enforcer *quota.Enforcer, logger lager.Logger) {
+	handler := quotaHandler{enforcer: enforcer, logger: logger.Session("admin-quotas")}
+	router.HandleFunc("/admin/quotas", handler.last).Methods("GET")
+	router.HandleFunc("/admin/quotas", handler.run).Methods("POST")
+}
+
+type quotaHandler struct {
+	enforcer *quota.Enforcer
+	logger   lager.Logger
+}
+
+func (h quotaHandler) last(w http.ResponseWriter, req *http.Request) {
+	respond(w, http.StatusOK, h.enforcer.LastReport())
+}
+
+func (h quotaHandler) run(w http.ResponseWriter, req *http.Request) {
+	report, err := h.enforcer.Run()
+	if err != nil {
+		h.logger.Error("run-error", err)
+		respond(w, http.StatusInternalServerError, report)
+		return
+	}
+	respond(w, http.StatusOK, report)
+}
@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/reconcile"
+)
+
+// AttachReconcileRoutes adds the endpoints to read the last drift report and to reconcile right away.
+//
+//	GET  /admin/reconcile
+//	POST /admin/reconcile
+func AttachReconcileRoutes(router *mux.Router, reconciler *reconcile.Reconciler, logger lager.Logger) {
+	handler := reconcileHandler{reconciler: reconciler, logger: logger.Session("admin-reconcile")}
+	router.HandleFunc("/admin/reconcile", handler.last).Methods("GET")
+	router.HandleFunc("/admin/reconcile", handler.run).Methods("POST")
+}
+
+type reconcileHandler struct {
+	reconciler *reconcile.Reconciler
+	logger     lager.Logger
+}
+
+func (h reconcileHandler) last(w http.ResponseWriter, req *http.Request) {
+	respond(w, http.StatusOK, h.reconciler.LastReport())
+}
+
+func (h reconcileHandler) run(w http.ResponseWriter, req *http.Request) {
+	report, err := h.reconciler.Run()
+	if err != nil {
+		h.logger.Error("run-error", err)
+		respond(w, http.StatusInternalServerError, report)
+		return
+	}
+	respond(w, http.StatusOK, report)
+}
@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/store"
+	"github.com/vchrisr/concourse-broker/usage"
+)
+
+// AttachUsageRoutes adds the endpoints to export the daily build usage as JSON or CSV and to
+// collect right away.
+//
+//	GET  /admin/usage?from=<YYYY-MM-DD>&to=<YYYY-MM-DD>&org=<org guid>&space=<space guid>&format=json|csv
+//	POST /admin/usage
+func AttachUsageRoutes(router *mux.Router, s store.Store, collector *usage.Collector, logger lager.Logger) {
+	handler := usageHandler{store: s, collector: collector, logger: logger.Session("admin-usage")}
+	router.HandleFunc("/admin/usage", handler.export).Methods("GET")
+	router.HandleFunc("/admin/usage", handler.run).Methods("POST")
+}
+
+type usageHandler struct {
+	store     store.Store
+	collector *usage.Collector
+	logger    lager.Logger
+}
+
+func (h usageHandler) export(w http.ResponseWriter, req *http.Request) {
+	query := usage.Query{
+		From:      req.FormValue("from"),
+		To:        req.FormValue("to"),
+		OrgGUID:   req.FormValue("org"),
+		SpaceGUID: req.FormValue("space"),
+	}
+	for _, date := range []string{query.From, query.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("Invalid date %q, expected YYYY-MM-DD", date))
+			return
+		}
+	}
+	format := req.FormValue("format")
+	if format != "" && format != "json" && format != "csv" {
+		respondError(w, http.StatusBadRequest, fmt.Errorf("Unknown format %s. Available formats are: json and csv", format))
+		return
+	}
+	rows, err := usage.Rows(h.store, query)
+	if err != nil {
+		h.logger.Error("query-error", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if format != "csv" {
+		respond(w, http.StatusOK, rows)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=usage.csv")
+	w.WriteHeader(http.StatusOK)
+	err = usage.WriteCSV(w, rows)
+	if err != nil {
+		h.logger.Error("write-csv-error", err)
+	}
+}
+
+func (h usageHandler) run(w http.ResponseWriter, req *http.Request) {
+	report, err := h.collector.Run()
+	if err != nil {
+		h.logger.Error("run-error", err)
+		respond(w, http.StatusInternalServerError, report)
+		return
+	}
+	respond(w, http.StatusOK, report)
+}
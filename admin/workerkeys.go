@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"io"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/workerkeys"
+)
+
+// AttachWorkerKeyRoutes adds the endpoints to read the authorized keys of a team's workers, to read
+// what the last prune run revoked and pruned and to prune right away.
+//
+//	GET  /admin/worker-keys/{team}
+//	GET  /admin/worker-keys
+//	POST /admin/worker-keys
+func AttachWorkerKeyRoutes(router *mux.Router, registry *workerkeys.Registry, logger lager.Logger) {
+	handler := workerKeysHandler{registry: registry, logger: logger.Session("admin-worker-keys")}
+	router.HandleFunc("/admin/worker-keys/{team}", handler.authorizedKeys).Methods("GET")
+	router.HandleFunc("/admin/worker-keys", handler.last).Methods("GET")
+	router.HandleFunc("/admin/worker-keys", handler.run).Methods("POST")
+}
+
+type workerKeysHandler struct {
+	registry *workerkeys.Registry
+	logger   lager.Logger
+}
+
+func (h workerKeysHandler) authorizedKeys(w http.ResponseWriter, req *http.Request) {
+	lines, err := h.registry.AuthorizedKeys(mux.Vars(req)["team"])
+	if err != nil {
+		h.logger.Error("authorized-keys-error", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, lines)
+}
+
+func (h workerKeysHandler) last(w http.ResponseWriter, req *http.Request) {
+	respond(w, http.StatusOK, h.registry.LastReport())
+}
+
+func (h workerKeysHandler) run(w http.ResponseWriter, req *http.Request) {
+	report, err := h.registry.Run()
+	if err != nil {
+		h.logger.Error("run-error", err)
+		respond(w, http.StatusInternalServerError, report)
+		return
+	}
+	respond(w, http.StatusOK, report)
+}
@@ -0,0 +1,39 @@
+package admin
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/workers"
+)
+
+// AttachWorkerRoutes adds the endpoints to read whether the last worker check found Concourse
+// degraded and to check right away.
+//
+//	GET  /admin/workers
+//	POST /admin/workers
+func AttachWorkerRoutes(router *mux.Router, checker *workers.Checker, logger lager.Logger) {
+	handler := workersHandler{checker: checker, logger: logger.Session("admin-workers")}
+	router.HandleFunc("/admin/workers", handler.last).Methods("GET")
+	router.HandleFunc("/admin/workers", handler.run).Methods("POST")
+}
+
+type workersHandler struct {
+	checker *workers.Checker
+	logger  lager.Logger
+}
+
+func (h workersHandler) last(w http.ResponseWriter, req *http.Request) {
+	respond(w, http.StatusOK, h.checker.LastStatus())
+}
+
+func (h workersHandler) run(w http.ResponseWriter, req *http.Request) {
+	status, err := h.checker.Run()
+	if err != nil {
+		h.logger.Error("run-error", err)
+		respond(w, http.StatusInternalServerError, status)
+		return
+	}
+	respond(w, http.StatusOK, status)
+}
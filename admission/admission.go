@@ -0,0 +1,124 @@
+package admission
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// Rules decide which orgs and spaces may provision a team and how many.
+type Rules struct {
+	AllowedOrgs      []string
+	DeniedOrgs       []string
+	AllowedSpaces    []string
+	DeniedSpaces     []string
+	OrgInstanceLimit int
+	OrgTeamLimit     int
+}
+
+// NewRules builds the rules configured in the environment. Patterns are validated up front
+// so a typo fails the broker at start instead of silently never matching.
+func NewRules(env config.Env) (Rules, error) {
+	rules := Rules{
+		AllowedOrgs:      patterns(env.AllowedOrgs),
+		DeniedOrgs:       patterns(env.DeniedOrgs),
+		AllowedSpaces:    patterns(env.AllowedSpaces),
+		DeniedSpaces:     patterns(env.DeniedSpaces),
+		OrgInstanceLimit: env.OrgInstanceLimit,
+		OrgTeamLimit:     env.OrgTeamLimit,
+	}
+	for _, list := range [][]string{rules.AllowedOrgs, rules.DeniedOrgs, rules.AllowedSpaces, rules.DeniedSpaces} {
+		for _, pattern := range list {
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return Rules{}, fmt.Errorf("Invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// RejectedError names the rule that blocked a request.
+type RejectedError struct {
+	Rule   string
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("Rejected by %s: %s", e.Rule, e.Reason)
+}
+
+// Check returns a RejectedError when the org or space may not provision the team.
+// Instances are the broker's records of the instances that already exist.
+func (r Rules) Check(details cf.Details, teamName string, instances []store.Instance) error {
+	orgNames := []string{details.OrgName, details.OrgGUID}
+	spaceNames := []string{details.SpaceName, details.SpaceGUID, details.OrgName + "/" + details.SpaceName}
+
+	if pattern, ok := match(r.DeniedOrgs, orgNames); ok {
+		return &RejectedError{Rule: "DENIED_ORGS", Reason: fmt.Sprintf("org %s matches %q", details.OrgName, pattern)}
+	}
+	if pattern, ok := match(r.DeniedSpaces, spaceNames); ok {
+		return &RejectedError{Rule: "DENIED_SPACES", Reason: fmt.Sprintf("space %s matches %q", details.SpaceName, pattern)}
+	}
+	if _, ok := match(r.AllowedOrgs, orgNames); len(r.AllowedOrgs) > 0 && !ok {
+		return &RejectedError{Rule: "ALLOWED_ORGS", Reason: fmt.Sprintf("org %s is not on the list", details.OrgName)}
+	}
+	if _, ok := match(r.AllowedSpaces, spaceNames); len(r.AllowedSpaces) > 0 && !ok {
+		return &RejectedError{Rule: "ALLOWED_SPACES", Reason: fmt.Sprintf("space %s is not on the list", details.SpaceName)}
+	}
+
+	count := 0
+	teams := map[string]bool{}
+	for _, instance := range instances {
+		if !sameOrg(instance, details) {
+			continue
+		}
+		count++
+		teams[instance.TeamName] = true
+	}
+	if r.OrgInstanceLimit > 0 && count >= r.OrgInstanceLimit {
+		return &RejectedError{Rule: "ORG_INSTANCE_LIMIT",
+			Reason: fmt.Sprintf("org %s already has %d of %d instances", details.OrgName, count, r.OrgInstanceLimit)}
+	}
+	if r.OrgTeamLimit > 0 && !teams[teamName] && len(teams) >= r.OrgTeamLimit {
+		return &RejectedError{Rule: "ORG_TEAM_LIMIT",
+			Reason: fmt.Sprintf("org %s already has %d of %d teams", details.OrgName, len(teams), r.OrgTeamLimit)}
+	}
+	return nil
+}
+
+func sameOrg(instance store.Instance, details cf.Details) bool {
+	if instance.OrgGUID != "" && details.OrgGUID != "" {
+		return instance.OrgGUID == details.OrgGUID
+	}
+	return instance.OrgName == details.OrgName
+}
+
+func match(patterns []string, names []string) (string, bool) {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if name == "" || name == "/" {
+				continue
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+func patterns(list []string) []string {
+	result := []string{}
+	for _, pattern := range list {
+		pattern = strings.TrimSpace(pattern)
+		if pattern != "" {
+			result = append(result, pattern)
+		}
+	}
+	return result
+}
@@ -0,0 +1,60 @@
+package admission
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Admission", func() {
+	var details = cf.Details{OrgGUID: "org-guid", OrgName: "venture", SpaceGUID: "space-guid", SpaceName: "dev"}
+
+	Describe("NewRules", func() {
+		It("rejects invalid patterns", func() {
+			_, err := NewRules(config.Env{DeniedOrgs: []string{"sandbox-["}})
+			Expect(err).To(MatchError(ContainSubstring(`Invalid pattern "sandbox-["`)))
+		})
+	})
+
+	Describe("Check", func() {
+		It("allows everything without rules", func() {
+			Expect(Rules{}.Check(details, "venture", nil)).To(Succeed())
+		})
+		It("rejects denied orgs by glob", func() {
+			err := Rules{DeniedOrgs: []string{"vent*"}}.Check(details, "venture", nil)
+			Expect(err).To(MatchError(`Rejected by DENIED_ORGS: org venture matches "vent*"`))
+		})
+		It("rejects denied spaces by org and space name", func() {
+			err := Rules{DeniedSpaces: []string{"venture/dev"}}.Check(details, "venture", nil)
+			Expect(err).To(MatchError(ContainSubstring("DENIED_SPACES")))
+		})
+		It("lets the deny list win over the allow list", func() {
+			err := Rules{AllowedOrgs: []string{"*"}, DeniedOrgs: []string{"org-guid"}}.Check(details, "venture", nil)
+			Expect(err).To(MatchError(ContainSubstring("DENIED_ORGS")))
+		})
+		It("rejects orgs and spaces missing from the allow lists", func() {
+			err := Rules{AllowedOrgs: []string{"monarch"}}.Check(details, "venture", nil)
+			Expect(err).To(MatchError("Rejected by ALLOWED_ORGS: org venture is not on the list"))
+			err = Rules{AllowedSpaces: []string{"prod"}}.Check(details, "venture", nil)
+			Expect(err).To(MatchError("Rejected by ALLOWED_SPACES: space dev is not on the list"))
+			Expect(Rules{AllowedSpaces: []string{"space-guid"}}.Check(details, "venture", nil)).To(Succeed())
+		})
+		It("enforces the per-org instance limit", func() {
+			instances := []store.Instance{
+				{ID: "1", OrgGUID: "org-guid", TeamName: "venture"},
+				{ID: "2", OrgGUID: "other-guid", TeamName: "monarch"},
+			}
+			Expect(Rules{OrgInstanceLimit: 2}.Check(details, "venture", instances)).To(Succeed())
+			err := Rules{OrgInstanceLimit: 1}.Check(details, "venture", instances)
+			Expect(err).To(MatchError("Rejected by ORG_INSTANCE_LIMIT: org venture already has 1 of 1 instances"))
+		})
+		It("only counts new teams against the per-org team limit", func() {
+			instances := []store.Instance{{ID: "1", OrgGUID: "org-guid", TeamName: "venture"}}
+			Expect(Rules{OrgTeamLimit: 1}.Check(details, "venture", instances)).To(Succeed())
+			err := Rules{OrgTeamLimit: 1}.Check(details, "venture-2", instances)
+			Expect(err).To(MatchError("Rejected by ORG_TEAM_LIMIT: org venture already has 1 of 1 teams"))
+		})
+	})
+})
@@ -0,0 +1,247 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/concourse"
+)
+
+// FormatVersion is the version of the archive layout. Archives of a newer version cannot be read.
+const FormatVersion = 1
+
+const (
+	manifestFile = "manifest.json"
+	pipelinesDir = "pipelines"
+)
+
+// Pipeline is an exported pipeline. Its config is kept in its own file in the tarball.
+type Pipeline struct {
+	Name          string           `json:"name"`
+	Paused        bool             `json:"paused"`
+	Public        bool             `json:"public"`
+	Groups        atc.GroupConfigs `json:"groups,omitempty"`
+	ConfigVersion string           `json:"config_version"`
+	Config        atc.RawConfig    `json:"-"`
+}
+
+// Archive holds everything exported from a team before it was destroyed.
+type Archive struct {
+	FormatVersion int       `json:"format_version"`
+	ID            string    `json:"id"`
+	TeamName      string    `json:"team"`
+	InstanceIDs   []string  `json:"instance_ids"`
+	Reason        string    `json:"reason"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Team is the auth config of the team without its secrets
+	Team      atc.Team   `json:"team_config"`
+	Pipelines []Pipeline `json:"pipelines"`
+}
+
+// Export reads the config and state of every pipeline of a team. team is the auth config the team
+// had, its secrets are left out of the archive.
+func Export(client concourse.Client, teamName string, team atc.Team, instanceIDs []string, reason string) (Archive, error) {
+	now := time.Now().UTC()
+	archive := Archive{
+		FormatVersion: FormatVersion,
+		ID:            fmt.Sprintf("%s-%s", teamName, now.Format("20060102T150405.000Z")),
+		TeamName:      teamName,
+		InstanceIDs:   instanceIDs,
+		Reason:        reason,
+		CreatedAt:     now,
+		Team:          withoutSecrets(team),
+		Pipelines:     []Pipeline{},
+	}
+	pipelines, err := client.ListPipelines(teamName)
+	if err != nil {
+		return Archive{}, err
+	}
+	for _, pipeline := range pipelines {
+		_, rawConfig, version, found, err := client.PipelineConfig(teamName, pipeline.Name)
+		if err != nil {
+			return Archive{}, err
+		}
+		if !found {
+			// deleted while exporting
+			continue
+		}
+		archive.Pipelines = append(archive.Pipelines, Pipeline{
+			Name:          pipeline.Name,
+			Paused:        pipeline.Paused,
+			Public:        pipeline.Public,
+			Groups:        pipeline.Groups,
+			ConfigVersion: version,
+			Config:        rawConfig,
+		})
+	}
+	return archive, nil
+}
+
+func withoutSecrets(team atc.Team) atc.Team {
+	team.ID = 0
+	team.Name = ""
+	if team.BasicAuth != nil {
+		basicAuth := *team.BasicAuth
+		basicAuth.BasicAuthPassword = ""
+		team.BasicAuth = &basicAuth
+	}
+	if team.GitHubAuth != nil {
+		gitHubAuth := *team.GitHubAuth
+		gitHubAuth.ClientSecret = ""
+		team.GitHubAuth = &gitHubAuth
+	}
+	if team.UAAAuth != nil {
+		uaaAuth := *team.UAAAuth
+		uaaAuth.ClientSecret = ""
+		team.UAAAuth = &uaaAuth
+	}
+	if team.GenericOAuth != nil {
+		genericOAuth := *team.GenericOAuth
+		genericOAuth.ClientSecret = ""
+		team.GenericOAuth = &genericOAuth
+	}
+	return team
+}
+
+// Storage keeps archive tarballs.
+type Storage interface {
+	// Save writes the tarball of an archive and returns where it went.
+	Save(archive Archive) (string, error)
+	Open(location string) (io.ReadCloser, error)
+}
+
+// Dir is a Storage that keeps archives as gzipped tarballs in a local directory, laid out like
+// the keys of an object store: <team>/<archive id>.tgz.
+type Dir struct {
+	path string
+}
+
+func NewDir(path string) *Dir {
+	return &Dir{path: path}
+}
+
+func (d *Dir) Save(archive Archive) (string, error) {
+	dir := filepath.Join(d.path, archive.TeamName)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	location := path.Join(archive.TeamName, archive.ID+".tgz")
+	tmp, err := ioutil.TempFile(dir, ".archive")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	err = write(tmp, archive)
+	closeErr := tmp.Close()
+	if err != nil {
+		return "", err
+	}
+	if closeErr != nil {
+		return "", closeErr
+	}
+	return location, os.Rename(tmp.Name(), filepath.Join(d.path, filepath.FromSlash(location)))
+}
+
+func (d *Dir) Open(location string) (io.ReadCloser, error) {
+	clean := path.Clean(location)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return nil, fmt.Errorf("Archive %s is not in the archive directory", location)
+	}
+	return os.Open(filepath.Join(d.path, filepath.FromSlash(clean)))
+}
+
+// Read reads an archive tarball.
+func Read(r io.Reader) (Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return Archive{}, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var archive Archive
+	configs := map[string]atc.RawConfig{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Archive{}, err
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return Archive{}, err
+		}
+		if header.Name == manifestFile {
+			err = json.Unmarshal(content, &archive)
+			if err != nil {
+				return Archive{}, err
+			}
+			continue
+		}
+		if dir, file := path.Split(header.Name); dir == pipelinesDir+"/" && strings.HasSuffix(file, ".yml") {
+			configs[strings.TrimSuffix(file, ".yml")] = atc.RawConfig(content)
+		}
+	}
+	if archive.ID == "" {
+		return Archive{}, fmt.Errorf("Archive has no %s", manifestFile)
+	}
+	if archive.FormatVersion > FormatVersion {
+		return Archive{}, fmt.Errorf("Archive %s has format version %d, this broker reads up to %d",
+			archive.ID, archive.FormatVersion, FormatVersion)
+	}
+	for i := range archive.Pipelines {
+		archive.Pipelines[i].Config = configs[archive.Pipelines[i].Name]
+	}
+	return archive, nil
+}
+
+func write(f *os.File, archive Archive) error {
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	manifest, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeFile(tw, manifestFile, manifest, archive.CreatedAt)
+	if err != nil {
+		return err
+	}
+	for _, pipeline := range archive.Pipelines {
+		name := path.Join(pipelinesDir, pipeline.Name+".yml")
+		err = writeFile(tw, name, []byte(pipeline.Config), archive.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeFile(tw *tar.Writer, name string, content []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(content)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	return err
+}
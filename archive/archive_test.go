@@ -0,0 +1,83 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Archiver", func() {
+	var (
+		archiver        *Archiver
+		brokerStore     store.Store
+		concourseClient *fakes.ConcourseClient
+		dir             string
+		team            = atc.Team{UAAAuth: &atc.UAAAuth{ClientID: "broker", ClientSecret: "secret", CFSpaces: []string{"space-a"}}}
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "archives")
+		Expect(err).NotTo(HaveOccurred())
+		brokerStore = store.NewMemoryStore()
+		archiver = NewArchiver(NewDir(dir), brokerStore)
+		concourseClient = fakes.NewConcourseClient()
+		concourseClient.Teams["venture"] = team
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "deploy", Public: true,
+			Groups: atc.GroupConfigs{{Name: "all", Jobs: []string{"deploy"}}}}, atc.Config{})
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "scan", Paused: true}, atc.Config{})
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("keeps pipeline configs, their state and the team config without secrets", func() {
+		record, err := archiver.Archive(concourseClient, "venture", team, []string{"instance-1"}, "deprovisioned")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.Pipelines).To(Equal(2))
+
+		archive, err := archiver.Load(record.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archive.FormatVersion).To(Equal(FormatVersion))
+		Expect(archive.TeamName).To(Equal("venture"))
+		Expect(archive.Reason).To(Equal("deprovisioned"))
+		Expect(archive.Team.UAAAuth.ClientSecret).To(BeEmpty())
+		Expect(archive.Team.UAAAuth.CFSpaces).To(Equal([]string{"space-a"}))
+		Expect(team.UAAAuth.ClientSecret).To(Equal("secret"))
+
+		Expect(archive.Pipelines).To(HaveLen(2))
+		deploy := archive.Pipelines[0]
+		Expect(deploy.Public).To(BeTrue())
+		Expect(deploy.Paused).To(BeFalse())
+		Expect(deploy.Groups[0].Name).To(Equal("all"))
+		Expect(deploy.ConfigVersion).To(Equal("1"))
+		Expect(deploy.Config).To(Equal(concourseClient.Pipelines["venture"][0].RawConfig))
+		Expect(archive.Pipelines[1].Paused).To(BeTrue())
+	})
+
+	It("indexes archives by team and instance", func() {
+		_, err := archiver.Archive(concourseClient, "venture", team, []string{"instance-1", "instance-2"}, "deprovisioned")
+		Expect(err).NotTo(HaveOccurred())
+
+		records, err := store.FindArchives(brokerStore, "", "instance-2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(1))
+		records, err = store.FindArchives(brokerStore, "venture", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(1))
+		records, err = store.FindArchives(brokerStore, "other", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(BeEmpty())
+	})
+
+	It("refuses locations outside the archive directory", func() {
+		_, err := NewDir(dir).Open("../../etc/passwd")
+		Expect(err).To(HaveOccurred())
+	})
+})
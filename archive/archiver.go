@@ -0,0 +1,65 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// Archiver exports teams into a Storage and indexes the archives in the broker's store.
+type Archiver struct {
+	storage Storage
+	store   store.Store
+}
+
+func NewArchiver(storage Storage, s store.Store) *Archiver {
+	return &Archiver{storage: storage, store: s}
+}
+
+// Archive exports a team, saves the archive and indexes it by team and instances.
+func (a *Archiver) Archive(client concourse.Client, teamName string, team atc.Team, instanceIDs []string,
+	reason string) (store.ArchiveRecord, error) {
+	exported, err := Export(client, teamName, team, instanceIDs, reason)
+	if err != nil {
+		return store.ArchiveRecord{}, err
+	}
+	location, err := a.storage.Save(exported)
+	if err != nil {
+		return store.ArchiveRecord{}, err
+	}
+	record := store.ArchiveRecord{
+		ID:          exported.ID,
+		TeamName:    teamName,
+		InstanceIDs: instanceIDs,
+		Reason:      reason,
+		CreatedAt:   exported.CreatedAt,
+		Location:    location,
+		Pipelines:   len(exported.Pipelines),
+	}
+	return record, store.SaveArchive(a.store, record)
+}
+
+// Open returns the tarball of an indexed archive.
+func (a *Archiver) Open(id string) (io.ReadCloser, error) {
+	record, found, err := store.GetArchive(a.store, id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("Archive %s does not exist", id)
+	}
+	return a.storage.Open(record.Location)
+}
+
+// Load reads an indexed archive.
+func (a *Archiver) Load(id string) (Archive, error) {
+	r, err := a.Open(id)
+	if err != nil {
+		return Archive{}, err
+	}
+	defer r.Close()
+	return Read(r)
+}
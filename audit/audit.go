@@ -0,0 +1,161 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// Outcomes recorded for an operation.
+const (
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+const auditLog = "audit"
+
+// Entry is a single record in the audit trail.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Operation  string    `json:"operation"`
+	InstanceID string    `json:"instance_id"`
+	PlanID     string    `json:"plan_id,omitempty"`
+	TeamName   string    `json:"team,omitempty"`
+	OrgGUID    string    `json:"org_guid,omitempty"`
+	OrgName    string    `json:"org_name,omitempty"`
+	SpaceGUID  string    `json:"space_guid,omitempty"`
+	SpaceName  string    `json:"space_name,omitempty"`
+	Caller     string    `json:"caller,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	// Detail says what an operation of the broker itself changed, e.g. which pipeline it paused
+	Detail     string   `json:"detail,omitempty"`
+	ConfigDiff []Change `json:"config_diff,omitempty"`
+}
+
+// Query selects audit entries. Zero values match everything.
+type Query struct {
+	From     time.Time
+	To       time.Time
+	TeamName string
+}
+
+// Matches reports whether the entry is selected by the query.
+func (q Query) Matches(entry Entry) bool {
+	if !q.From.IsZero() && entry.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && entry.Time.After(q.To) {
+		return false
+	}
+	if q.TeamName != "" && entry.TeamName != q.TeamName {
+		return false
+	}
+	return true
+}
+
+// Log is an append-only audit trail.
+type Log interface {
+	Record(entry Entry) error
+	Query(query Query) ([]Entry, error)
+}
+
+// NewFileLog returns a log that appends one JSON document per line to the file at path.
+func NewFileLog(path string) Log {
+	return &fileLog{path: path}
+}
+
+type fileLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (l *fileLog) Record(entry Entry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err = os.MkdirAll(filepath.Dir(l.path), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(buf, '\n'))
+	return err
+}
+
+func (l *fileLog) Query(query Query) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := []Entry{}
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading audit log %v", err)
+		}
+		if query.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// NewStoreLog returns a log that keeps its entries with the broker's records, in a log of the store
+// that is only ever appended to.
+func NewStoreLog(s store.Store) Log {
+	return &storeLog{store: s}
+}
+
+type storeLog struct {
+	store store.Store
+}
+
+func (l *storeLog) Record(entry Entry) error {
+	return l.store.Append(auditLog, entry)
+}
+
+func (l *storeLog) Query(query Query) ([]Entry, error) {
+	entries := []Entry{}
+	records, err := l.store.Records(auditLog)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		var entry Entry
+		err := json.Unmarshal(record, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading audit log %v", err)
+		}
+		if query.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Audit", func() {
+	var start = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	var entries = []Entry{
+		{Time: start, Operation: "provision", InstanceID: "1", TeamName: "venture", Outcome: Succeeded},
+		{Time: start.Add(time.Hour), Operation: "provision", InstanceID: "2", TeamName: "monarch", Outcome: Failed},
+		{Time: start.Add(2 * time.Hour), Operation: "deprovision", InstanceID: "1", TeamName: "venture", Outcome: Succeeded},
+	}
+
+	itQueriesEntries := func(newLog func() Log) {
+		var log Log
+
+		BeforeEach(func() {
+			log = newLog()
+			for _, entry := range entries {
+				Expect(log.Record(entry)).To(Succeed())
+			}
+		})
+		It("returns every entry for an empty query", func() {
+			result, err := log.Query(Query{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(3))
+			Expect(result[0].InstanceID).To(Equal("1"))
+			Expect(result[2].Operation).To(Equal("deprovision"))
+		})
+		It("filters by team", func() {
+			result, err := log.Query(Query{TeamName: "venture"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(2))
+		})
+		It("filters by time range", func() {
+			result, err := log.Query(Query{From: start.Add(30 * time.Minute), To: start.Add(90 * time.Minute)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(1))
+			Expect(result[0].TeamName).To(Equal("monarch"))
+		})
+	}
+
+	Describe("NewFileLog", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "audit")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+		itQueriesEntries(func() Log {
+			return NewFileLog(filepath.Join(dir, "audit.jsonl"))
+		})
+		It("returns no entries when nothing was recorded yet", func() {
+			result, err := NewFileLog(filepath.Join(dir, "missing.jsonl")).Query(Query{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(BeEmpty())
+		})
+	})
+
+	Describe("NewStoreLog", func() {
+		itQueriesEntries(func() Log {
+			return NewStoreLog(store.NewMemoryStore())
+		})
+
+		It("keeps every entry in order across restarts without rewriting the records", func() {
+			dir, err := ioutil.TempDir("", "audit")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "store.json")
+			brokerStore, err := store.NewFileStore(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(NewStoreLog(brokerStore).Record(entries[2])).To(Succeed())
+
+			brokerStore, err = store.NewFileStore(path)
+			Expect(err).NotTo(HaveOccurred())
+			log := NewStoreLog(brokerStore)
+			Expect(log.Record(entries[0])).To(Succeed())
+			result, err := log.Query(Query{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(2))
+			Expect(result[0].Operation).To(Equal("deprovision"))
+			Expect(result[1].Operation).To(Equal("provision"))
+			_, err = os.Stat(path)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			Expect(filepath.Join(dir, "store-audit.jsonl")).To(BeAnExistingFile())
+		})
+	})
+
+	Describe("Diff", func() {
+		It("lists every field of a created team and redacts secrets", func() {
+			team := atc.Team{UAAAuth: &atc.UAAAuth{ClientSecret: "shh", CFSpaces: []string{"space-guid"}}}
+			changes := Diff(nil, &team)
+			Expect(changes).To(Equal([]Change{
+				{Field: "uaa_auth.cf_spaces.0", After: "space-guid"},
+				{Field: "uaa_auth.client_secret", After: "<redacted>"},
+			}))
+		})
+		It("lists only the fields that changed", func() {
+			before := atc.Team{UAAAuth: &atc.UAAAuth{CFURL: "cf", CFSpaces: []string{"a"}}}
+			after := atc.Team{UAAAuth: &atc.UAAAuth{CFURL: "cf", CFSpaces: []string{"a", "b"}}}
+			Expect(Diff(&before, &after)).To(Equal([]Change{
+				{Field: "uaa_auth.cf_spaces.1", After: "b"},
+			}))
+		})
+	})
+})
@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/concourse/atc"
+)
+
+const redacted = "<redacted>"
+
+// Change describes a single field of a team config that differs between two versions.
+type Change struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Diff returns the changes needed to go from the before to the after team config.
+// Either side may be nil for a team that did not exist. Secrets are never written to the diff.
+func Diff(before, after *atc.Team) []Change {
+	beforeFields := flatten(before)
+	afterFields := flatten(after)
+	fields := map[string]bool{}
+	for field := range beforeFields {
+		fields[field] = true
+	}
+	for field := range afterFields {
+		fields[field] = true
+	}
+	changes := []Change{}
+	for field := range fields {
+		b, a := beforeFields[field], afterFields[field]
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		if isSecret(field) {
+			if b != nil {
+				b = redacted
+			}
+			if a != nil {
+				a = redacted
+			}
+		}
+		changes = append(changes, Change{Field: field, Before: b, After: a})
+	}
+	sort.Sort(byField(changes))
+	return changes
+}
+
+type byField []Change
+
+func (c byField) Len() int           { return len(c) }
+func (c byField) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byField) Less(i, j int) bool { return c[i].Field < c[j].Field }
+
+func flatten(team *atc.Team) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if team == nil {
+		return fields
+	}
+	buf, err := json.Marshal(team)
+	if err != nil {
+		return fields
+	}
+	var doc map[string]interface{}
+	if json.Unmarshal(buf, &doc) != nil {
+		return fields
+	}
+	// the ID is assigned by Concourse and says nothing about the config
+	delete(doc, "id")
+	flattenInto(fields, "", doc)
+	return fields
+}
+
+func flattenInto(fields map[string]interface{}, prefix string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			flattenInto(fields, join(prefix, key), child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenInto(fields, join(prefix, fmt.Sprint(i)), child)
+		}
+	default:
+		fields[prefix] = v
+	}
+}
+
+func join(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func isSecret(field string) bool {
+	field = strings.ToLower(field)
+	return strings.Contains(field, "secret") || strings.Contains(field, "password")
+}
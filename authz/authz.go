@@ -0,0 +1,99 @@
+package authz
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/config"
+)
+
+// Action is a broker operation that can be restricted to users holding certain CF roles.
+type Action string
+
+const (
+	Provision   Action = "provision"
+	Deprovision Action = "deprovision"
+	// AddSpace is provisioning into an org whose team already exists, which grants another space access to it.
+	AddSpace Action = "add-space"
+)
+
+// Policy maps each action to the roles that may perform it. Actions without roles are allowed for everyone.
+type Policy map[Action][]cf.Role
+
+// NewPolicy builds the policy configured in the environment.
+func NewPolicy(env config.Env) (Policy, error) {
+	policy := Policy{}
+	actions := map[Action][]string{
+		Provision:   env.ProvisionRoles,
+		Deprovision: env.DeprovisionRoles,
+		AddSpace:    env.AddSpaceRoles,
+	}
+	for action, names := range actions {
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			role, ok := parseRole(name)
+			if !ok {
+				return nil, fmt.Errorf("Unknown role %s for %s. Available roles are: %s", name, action, roleNames(cf.Roles))
+			}
+			policy[action] = append(policy[action], role)
+		}
+	}
+	return policy, nil
+}
+
+// Restricts reports whether only some users may perform the action.
+func (p Policy) Restricts(action Action) bool {
+	return len(p[action]) > 0
+}
+
+// Authorize returns a ForbiddenError unless the user holds one of the roles the action requires.
+func (p Policy) Authorize(action Action, userID string, roles []cf.Role) error {
+	if !p.Restricts(action) {
+		return nil
+	}
+	for _, required := range p[action] {
+		for _, role := range roles {
+			if role == required {
+				return nil
+			}
+		}
+	}
+	return &ForbiddenError{Action: action, UserID: userID, Required: p[action]}
+}
+
+// ForbiddenError is returned when a user may not perform an action.
+type ForbiddenError struct {
+	Action   Action
+	UserID   string
+	Required []cf.Role
+}
+
+func (e *ForbiddenError) Error() string {
+	user := e.UserID
+	if user == "" {
+		user = "an unidentified user"
+	}
+	return fmt.Sprintf("Forbidden: %s is not allowed to %s, this requires one of the roles: %s",
+		user, e.Action, roleNames(e.Required))
+}
+
+func parseRole(name string) (cf.Role, bool) {
+	for _, role := range cf.Roles {
+		if string(role) == name {
+			return role, true
+		}
+	}
+	return "", false
+}
+
+func roleNames(roles []cf.Role) string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = string(role)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,47 @@
+package authz
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/config"
+)
+
+var _ = Describe("Authz", func() {
+	Describe("NewPolicy", func() {
+		It("builds the policy from the environment", func() {
+			policy, err := NewPolicy(config.Env{
+				ProvisionRoles: []string{"space_manager", " org_manager"},
+				AddSpaceRoles:  []string{"org_manager"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(policy[Provision]).To(Equal([]cf.Role{cf.SpaceManager, cf.OrgManager}))
+			Expect(policy.Restricts(Deprovision)).To(BeFalse())
+			Expect(policy.Restricts(AddSpace)).To(BeTrue())
+		})
+		It("ignores an empty role list", func() {
+			policy, err := NewPolicy(config.Env{ProvisionRoles: []string{""}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(policy.Restricts(Provision)).To(BeFalse())
+		})
+		It("rejects unknown roles", func() {
+			_, err := NewPolicy(config.Env{DeprovisionRoles: []string{"space_janitor"}})
+			Expect(err).To(MatchError(ContainSubstring("Unknown role space_janitor for deprovision")))
+		})
+	})
+	Describe("Authorize", func() {
+		policy := Policy{Provision: []cf.Role{cf.SpaceManager}}
+
+		It("allows unrestricted actions", func() {
+			Expect(policy.Authorize(Deprovision, "user", nil)).To(Succeed())
+		})
+		It("allows users holding a required role", func() {
+			Expect(policy.Authorize(Provision, "user", []cf.Role{cf.SpaceDeveloper, cf.SpaceManager})).To(Succeed())
+		})
+		It("forbids users without a required role", func() {
+			err := policy.Authorize(Provision, "user-guid", []cf.Role{cf.SpaceDeveloper})
+			Expect(err).To(BeAssignableToTypeOf(&ForbiddenError{}))
+			Expect(err.Error()).To(Equal("Forbidden: user-guid is not allowed to provision, this requires one of the roles: space_manager"))
+		})
+	})
+})
@@ -0,0 +1,109 @@
+package broker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/buildapi"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/store"
+	"github.com/vchrisr/concourse-broker/workerkeys"
+)
+
+var _ = Describe("Bindings", func() {
+	var (
+		broker *concourseBroker
+		dir    string
+		env    config.Env
+	)
+
+	newBroker := func() {
+		brokerStore := store.NewMemoryStore()
+		auditLog := audit.NewStoreLog(brokerStore)
+		concourseClient := fakes.NewConcourseClient()
+		logger := lagertest.NewTestLogger("broker")
+		broker = &concourseBroker{
+			logger:     logger,
+			store:      brokerStore,
+			plans:      plans.Plans{"workers": {WorkerRegistration: true}},
+			workerKeys: workerkeys.New(brokerStore, auditLog, concourseClient, logger, env),
+			buildAPI:   buildapi.New(brokerStore, auditLog, concourseClient, logger, env),
+			teamsLock:  &sync.Mutex{},
+		}
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-1", PlanID: "small", TeamName: "venture"})).To(Succeed())
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-2", PlanID: "workers", TeamName: "venture"})).To(Succeed())
+	}
+
+	bind := func(instanceID, bindingID string, params map[string]interface{}) (map[string]interface{}, error) {
+		credentials, err := broker.bind(instanceID, bindingID, brokerapi.BindDetails{Parameters: params}, &audit.Entry{})
+		if err != nil {
+			return nil, err
+		}
+		raw, err := json.Marshal(credentials)
+		Expect(err).NotTo(HaveOccurred())
+		result := map[string]interface{}{}
+		Expect(json.Unmarshal(raw, &result)).To(Succeed())
+		return result, nil
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "worker-keys")
+		Expect(err).NotTo(HaveOccurred())
+		env = config.Env{BrokerURL: "https://broker.example.com/", TSAHost: "tsa.example.com", WorkerKeysDir: dir}
+		newBroker()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("hands out the credentials of the build API", func() {
+		credentials, err := bind("instance-1", "binding-1", map[string]interface{}{"pipeline": "app", "job": "deploy"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials["team"]).To(Equal("venture"))
+		Expect(credentials["api_url"]).To(Equal("https://broker.example.com/api/v1"))
+		Expect(credentials["api_username"]).To(Equal("binding-1"))
+		Expect(credentials["trigger_url"]).To(MatchRegexp(`^https://broker.example.com/api/v1/trigger/binding-1/[0-9a-f]{64}/pipelines/app/jobs/deploy$`))
+		Expect(credentials).NotTo(HaveKey("worker_private_key"))
+
+		_, err = bind("instance-1", "binding-1", nil)
+		Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+	})
+
+	It("adds the worker credentials on plans with worker registration", func() {
+		credentials, err := bind("instance-2", "binding-1", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials["team"]).To(Equal("venture"))
+		Expect(credentials["api_password"]).NotTo(BeEmpty())
+		Expect(credentials["worker_private_key"]).NotTo(BeEmpty())
+		Expect(credentials["trigger_url"]).To(ContainSubstring("/pipelines/{pipeline}/jobs/{job}"))
+
+		Expect(broker.unbind("instance-2", "binding-1", &audit.Entry{})).To(Succeed())
+		_, found, _ := store.GetBinding(broker.store, "binding-1")
+		Expect(found).To(BeFalse())
+		_, found, _ = store.GetWorkerKey(broker.store, "binding-1")
+		Expect(found).To(BeFalse())
+		Expect(broker.unbind("instance-2", "binding-1", &audit.Entry{})).To(Equal(brokerapi.ErrBindingDoesNotExist))
+	})
+
+	It("refuses bindings without BROKER_URL unless the plan registers workers", func() {
+		env.BrokerURL = ""
+		newBroker()
+		_, err := bind("instance-1", "binding-1", nil)
+		Expect(err).To(MatchError("Plan small does not support bindings, BROKER_URL is not set"))
+		credentials, err := bind("instance-2", "binding-2", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials).NotTo(HaveKey("api_url"))
+		Expect(credentials["worker_name"]).To(Equal("venture-worker-binding-2"))
+	})
+})
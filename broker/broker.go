@@ -2,24 +2,93 @@ package broker
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/pivotal-cf/brokerapi"
+	"github.com/vchrisr/concourse-broker/admission"
+	"github.com/vchrisr/concourse-broker/archive"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/authz"
+	"github.com/vchrisr/concourse-broker/buildapi"
 	"github.com/vchrisr/concourse-broker/cf"
 	"github.com/vchrisr/concourse-broker/concourse"
 	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/quota"
+	"github.com/vchrisr/concourse-broker/seed"
+	"github.com/vchrisr/concourse-broker/softdelete"
+	"github.com/vchrisr/concourse-broker/store"
+	"github.com/vchrisr/concourse-broker/workerkeys"
+	"github.com/vchrisr/concourse-broker/workers"
 )
 
+// Dependencies are what the broker keeps its records in and checks requests against.
+type Dependencies struct {
+	Store    store.Store
+	AuditLog audit.Log
+	Policy   authz.Policy
+	Rules    admission.Rules
+	Plans    plans.Plans
+	Seeder   *seed.Seeder
+	Archiver *archive.Archiver
+	// Deleter soft deletes the teams of deprovisioned instances when SOFT_DELETE_WINDOW is set
+	Deleter *softdelete.Deleter
+	// Workers refuses provisions while Concourse has fewer usable workers than WORKERS_MIN
+	Workers *workers.Checker
+	// WorkerKeys hands out the keys of team workers to bindings of plans with worker registration
+	WorkerKeys *workerkeys.Registry
+	// BuildAPI hands out the credentials of the build API to bindings when BROKER_URL is set
+	BuildAPI *buildapi.API
+	// TeamsLock serializes changes to teams and their records. The background jobs share it: they
+	// hold it while they change teams or records, and not while they only read CF or Concourse, so
+	// a long run does not hold up provisions past the timeout of the platform.
+	TeamsLock sync.Locker
+}
+
 // New returns a new concourse service broker instance.
-func New(services []brokerapi.Service, logger lager.Logger, env config.Env) brokerapi.ServiceBroker {
-	return &concourseBroker{services: services, logger: logger, env: env}
+func New(services []brokerapi.Service, logger lager.Logger, env config.Env, deps Dependencies) brokerapi.ServiceBroker {
+	if deps.TeamsLock == nil {
+		deps.TeamsLock = &sync.Mutex{}
+	}
+	return &concourseBroker{
+		services:   services,
+		logger:     logger,
+		env:        env,
+		store:      deps.Store,
+		auditLog:   deps.AuditLog,
+		policy:     deps.Policy,
+		rules:      deps.Rules,
+		plans:      deps.Plans,
+		seeder:     deps.Seeder,
+		archiver:   deps.Archiver,
+		deleter:    deps.Deleter,
+		workers:    deps.Workers,
+		workerKeys: deps.WorkerKeys,
+		buildAPI:   deps.BuildAPI,
+		teamsLock:  deps.TeamsLock,
+	}
 }
 
 type concourseBroker struct {
-	services []brokerapi.Service
-	logger   lager.Logger
-	env      config.Env
+	services   []brokerapi.Service
+	logger     lager.Logger
+	env        config.Env
+	store      store.Store
+	auditLog   audit.Log
+	policy     authz.Policy
+	rules      admission.Rules
+	plans      plans.Plans
+	seeder     *seed.Seeder
+	archiver   *archive.Archiver
+	deleter    *softdelete.Deleter
+	workers    *workers.Checker
+	workerKeys *workerkeys.Registry
+	buildAPI   *buildapi.API
+	teamsLock  sync.Locker
 }
 
 func (c *concourseBroker) Services(context context.Context) []brokerapi.Service {
@@ -28,56 +97,711 @@ func (c *concourseBroker) Services(context context.Context) []brokerapi.Service
 
 func (c *concourseBroker) Provision(context context.Context, instanceID string,
 	details brokerapi.ProvisionDetails, asyncAllowed bool) (brokerapi.ProvisionedServiceSpec, error) {
-	cfClient, err := cf.NewClient(c.env)
+	entry := newAuditEntry(context, "provision", instanceID, details.PlanID)
+	var warnings []string
+	var err error
+	if c.isPipelineService(details.ServiceID) {
+		warnings, err = c.provisionPipeline(context, instanceID, details, &entry)
+	} else {
+		warnings, err = c.provision(context, instanceID, details, &entry)
+	}
+	c.record(entry, err)
 	if err != nil {
 		return brokerapi.ProvisionedServiceSpec{}, err
 	}
+	if len(warnings) > 0 && asyncAllowed {
+		// a synchronous response cannot carry a message, the warnings are shown as the last operation
+		return brokerapi.ProvisionedServiceSpec{IsAsync: true, OperationData: "provision"}, nil
+	}
+	return brokerapi.ProvisionedServiceSpec{}, nil
+}
+
+func (c *concourseBroker) provision(ctx context.Context, instanceID string,
+	details brokerapi.ProvisionDetails, entry *audit.Entry) ([]string, error) {
+	entry.OrgGUID = details.OrganizationGUID
+	entry.SpaceGUID = details.SpaceGUID
+	_, found, err := store.GetInstance(c.store, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, brokerapi.ErrInstanceAlreadyExists
+	}
+	_, pending, err := store.GetProvision(c.store, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, fmt.Errorf("A failed provision of instance %s was not rolled back yet, deprovision it first", instanceID)
+	}
+	if c.plans.Get(details.PlanID).Suspend {
+		return nil, fmt.Errorf("Plan %s suspends teams, instances can only be updated to it", details.PlanID)
+	}
+	params, err := seed.ParseParams(details.RawParameters)
+	if err != nil {
+		return nil, brokerapi.ErrRawParamsInvalid
+	}
+	err = c.seeder.Validate(params)
+	if err != nil {
+		return nil, err
+	}
+	cfClient, err := cf.NewClient(c.env)
+	if err != nil {
+		return nil, err
+	}
 	cfDetails, err := cfClient.GetProvisionDetails(details.SpaceGUID)
 	cfDetails.SpaceGUID = details.SpaceGUID
 	if err != nil {
-		return brokerapi.ProvisionedServiceSpec{}, err
+		return nil, err
 	}
 	concourseClient := concourse.NewClient(c.env, c.logger)
-	err = concourseClient.CreateTeam(cfDetails)
+	setAuditDetails(entry, concourseClient, cfDetails)
+	entry.TeamName, err = c.orgTeamName(concourseClient, cfDetails)
 	if err != nil {
-		return brokerapi.ProvisionedServiceSpec{}, err
+		return nil, err
 	}
-	return brokerapi.ProvisionedServiceSpec{}, nil
+	err = c.checkCreatable(entry.TeamName)
+	if err != nil {
+		return nil, err
+	}
+	err = c.authorize(ctx, authz.Provision, cfClient, cfDetails)
+	if err != nil {
+		return nil, err
+	}
+	target := ""
+	if c.workers.Enabled() {
+		target, err = c.workers.Check()
+		if err != nil {
+			c.logger.Error("provision.no-workers", err)
+			return nil, err
+		}
+	}
+	c.teamsLock.Lock()
+	defer c.teamsLock.Unlock()
+	instances, err := store.ListInstances(c.store)
+	if err != nil {
+		return nil, err
+	}
+	err = c.rules.Check(cfDetails, entry.TeamName, instances)
+	if err != nil {
+		c.logger.Error("provision.rejected", err, lager.Data{
+			"org-name":   cfDetails.OrgName,
+			"space-name": cfDetails.SpaceName,
+		})
+		return nil, err
+	}
+	shared, err := store.TeamInstances(c.store, entry.TeamName)
+	if err != nil {
+		return nil, err
+	}
+	workerTag, err := c.workerTag(cfClient, cfDetails, entry.TeamName, len(shared) > 0, target)
+	if err != nil {
+		c.logger.Error("provision.worker-tag", err, lager.Data{"space-name": cfDetails.SpaceName})
+		return nil, err
+	}
+	steps := []step{}
+	if len(shared) > 0 {
+		steps, err = c.addSpaceSteps(ctx, cfClient, concourseClient, cfDetails, shared, entry)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		steps = c.createTeamSteps(concourseClient, instanceID, cfDetails, workerTag, entry)
+	}
+	provision := &store.Provision{
+		InstanceID: instanceID,
+		TeamName:   entry.TeamName,
+		SpaceGUID:  cfDetails.SpaceGUID,
+		StartedAt:  time.Now().UTC(),
+	}
+	plan := c.plans.Get(details.PlanID)
+	warnings := []string{}
+	var seeded seed.Result
+	steps = append(steps, step{name: seedPipelineStep, do: func() error {
+		var err error
+		seeded, err = c.seeder.Seed(concourseClient, entry.TeamName, workerTag, plan, params, map[string]interface{}{
+			"team_name":   entry.TeamName,
+			"org_name":    cfDetails.OrgName,
+			"space_name":  cfDetails.SpaceName,
+			"instance_id": instanceID,
+		})
+		provision.Pipelines = seeded.Created
+		warnings = seeded.Warnings
+		return err
+	}})
+	if plan.TemplateTeam != "" {
+		_, cloned, err := store.GetClone(c.store, entry.TeamName)
+		if err != nil {
+			return nil, err
+		}
+		if !cloned {
+			steps = append(steps, step{name: saveCloneStep, do: func() error {
+				return store.SaveClone(c.store, store.Clone{
+					TeamName:   entry.TeamName,
+					SourceTeam: plan.TemplateTeam,
+					InstanceID: instanceID,
+					Sync:       params.SyncTemplate,
+					Vars:       seeded.Vars,
+					Versions:   seeded.TemplateVersions,
+				})
+			}})
+		}
+	}
+	steps = append(steps, step{name: saveInstanceStep, do: func() error {
+		return store.SaveInstance(c.store, store.Instance{
+			ID:        instanceID,
+			ServiceID: details.ServiceID,
+			PlanID:    details.PlanID,
+			OrgGUID:   entry.OrgGUID,
+			OrgName:   cfDetails.OrgName,
+			SpaceGUID: cfDetails.SpaceGUID,
+			SpaceName: cfDetails.SpaceName,
+			TeamName:  entry.TeamName,
+			CreatedAt: time.Now().UTC(),
+			Warnings:  warnings,
+		})
+	}})
+	err = c.runSteps(concourseClient, provision, steps)
+	if err != nil {
+		return nil, err
+	}
+	if len(warnings) > 0 {
+		c.logger.Info("provision.seed-warnings", lager.Data{"instance-id": instanceID, "warnings": warnings})
+	}
+	return warnings, nil
+}
+
+func (c *concourseBroker) createTeamSteps(concourseClient concourse.Client, instanceID string,
+	details cf.Details, workerTag string, entry *audit.Entry) []step {
+	return []step{
+		{name: createTeamStep, do: func() error {
+			team := concourseClient.TeamConfig([]string{details.SpaceGUID})
+			err := concourseClient.CreateTeam(details)
+			if err != nil {
+				return err
+			}
+			entry.ConfigDiff = audit.Diff(nil, &team)
+			return nil
+		}},
+		{name: saveTeamStep, do: func() error {
+			return store.SaveTeam(c.store, store.Team{
+				Name:      entry.TeamName,
+				CreatedBy: instanceID,
+				CreatedAt: time.Now().UTC(),
+				WorkerTag: workerTag,
+			})
+		}},
+	}
+}
+
+// workerTag returns the worker tag of the isolation segment of the space, as ISOLATION_SEGMENT_TAGS
+// maps it. The pipelines of a team run on the workers of one segment only, so a space in another
+// segment than the team was created for cannot share it. A new team in the shared segment is placed
+// on the target the workers check picked, and keeps it for the spaces that share it later.
+func (c *concourseBroker) workerTag(cfClient cf.Client, details cf.Details, teamName string, shared bool, target string) (string, error) {
+	tag := ""
+	if len(c.env.IsolationSegmentTags) > 0 {
+		segment, err := cfClient.GetIsolationSegment(details)
+		if err != nil {
+			return "", fmt.Errorf("Could not look up the isolation segment of space %s: %v", details.SpaceName, err)
+		}
+		var found bool
+		tag, found = c.env.IsolationSegmentTags[segment]
+		if segment != "" && !found {
+			return "", &admission.RejectedError{Rule: "ISOLATION_SEGMENT_TAGS",
+				Reason: fmt.Sprintf("no Concourse workers are tagged for isolation segment %s of space %s", segment, details.SpaceName)}
+		}
+	}
+	if !shared {
+		if tag == "" {
+			return target, nil
+		}
+		return tag, nil
+	}
+	team, _, err := store.GetTeam(c.store, teamName)
+	if err != nil {
+		return "", err
+	}
+	if tag == "" && c.workers.IsTarget(team.WorkerTag) {
+		return team.WorkerTag, nil
+	}
+	if team.WorkerTag != tag {
+		return "", &admission.RejectedError{Rule: "ISOLATION_SEGMENT_TAGS",
+			Reason: fmt.Sprintf("team %s runs on workers tagged %q, space %s needs workers tagged %q", teamName, team.WorkerTag, details.SpaceName, tag)}
+	}
+	return tag, nil
+}
+
+// addSpaceSteps grant the space of a new instance access to a team that other instances already refer to.
+func (c *concourseBroker) addSpaceSteps(ctx context.Context, cfClient cf.Client, concourseClient concourse.Client,
+	details cf.Details, shared []store.Instance, entry *audit.Entry) ([]step, error) {
+	spaces := store.SpaceGUIDs(shared)
+	if containsString(spaces, details.SpaceGUID) {
+		return []step{}, nil
+	}
+	err := c.authorize(ctx, authz.AddSpace, cfClient, details)
+	if err != nil {
+		return nil, err
+	}
+	return []step{{name: addSpaceStep, do: func() error {
+		return c.updateTeamSpaces(concourseClient, entry.TeamName, spaces, append(spaces, details.SpaceGUID), entry)
+	}}}, nil
 }
 
 func (c *concourseBroker) Deprovision(context context.Context, instanceID string,
 	details brokerapi.DeprovisionDetails, asyncAllowed bool) (brokerapi.DeprovisionServiceSpec, error) {
-	cfClient, err := cf.NewClient(c.env)
+	entry := newAuditEntry(context, "deprovision", instanceID, details.PlanID)
+	err := c.deprovision(context, instanceID, details.ServiceID, &entry)
+	c.record(entry, err)
 	if err != nil {
 		return brokerapi.DeprovisionServiceSpec{}, err
 	}
-	cfDetails, err := cfClient.GetDeprovisionDetails(instanceID)
+	return brokerapi.DeprovisionServiceSpec{}, nil
+}
+
+func (c *concourseBroker) deprovision(ctx context.Context, instanceID, serviceID string, entry *audit.Entry) error {
+	pipelineInstance, found, err := store.GetPipelineInstance(c.store, instanceID)
 	if err != nil {
-		return brokerapi.DeprovisionServiceSpec{}, err
+		return err
+	}
+	if found {
+		return c.deprovisionPipeline(ctx, pipelineInstance, entry)
+	}
+	if c.isPipelineService(serviceID) {
+		// a pipeline instance whose provision failed left nothing behind, it must not reach the teams
+		return brokerapi.ErrInstanceDoesNotExist
 	}
 	concourseClient := concourse.NewClient(c.env, c.logger)
-	err = concourseClient.DeleteTeam(cfDetails)
+	mitigated, provision, err := c.mitigateOrphan(instanceID, concourseClient)
+	if mitigated {
+		entry.Operation = "orphan-mitigation"
+		entry.TeamName = provision.TeamName
+		entry.SpaceGUID = provision.SpaceGUID
+		return err
+	}
 	if err != nil {
-		return brokerapi.DeprovisionServiceSpec{}, err
+		return err
 	}
-	return brokerapi.DeprovisionServiceSpec{}, nil
+	cfClient, err := cf.NewClient(c.env)
+	if err != nil {
+		return err
+	}
+	return c.deprovisionTeam(ctx, cfClient, concourseClient, instanceID, entry)
+}
+
+// deprovisionTeam takes the space of an instance away from its team, and destroys the team when
+// no other instance refers to it.
+func (c *concourseBroker) deprovisionTeam(ctx context.Context, cfClient cf.Client, concourseClient concourse.Client,
+	instanceID string, entry *audit.Entry) error {
+	instance, stored, err := store.GetInstance(c.store, instanceID)
+	if err != nil {
+		return err
+	}
+	exists, err := cfClient.InstanceExists(instanceID)
+	if err != nil {
+		return err
+	}
+	var cfDetails cf.Details
+	switch {
+	case exists:
+		cfDetails, err = cfClient.GetDeprovisionDetails(instanceID)
+		if err != nil {
+			return err
+		}
+	case stored:
+		// the platform gave up on a provision that finished after all, so it never recorded the instance
+		c.logger.Info("deprovision.orphan-mitigation", lager.Data{"instance-id": instanceID, "team-name": instance.TeamName})
+		entry.Operation = "orphan-mitigation"
+		cfDetails = cf.Details{
+			OrgGUID:   instance.OrgGUID,
+			OrgName:   instance.OrgName,
+			SpaceGUID: instance.SpaceGUID,
+			SpaceName: instance.SpaceName,
+		}
+	default:
+		return brokerapi.ErrInstanceDoesNotExist
+	}
+	setAuditDetails(entry, concourseClient, cfDetails)
+	if stored {
+		// the team keeps its name when the org is renamed
+		entry.TeamName = instance.TeamName
+	}
+	err = c.authorize(ctx, authz.Deprovision, cfClient, cfDetails)
+	if err != nil {
+		return err
+	}
+	c.teamsLock.Lock()
+	defer c.teamsLock.Unlock()
+	shared, err := store.TeamInstances(c.store, entry.TeamName)
+	if err != nil {
+		return err
+	}
+	remaining := []store.Instance{}
+	for _, instance := range shared {
+		if instance.ID != instanceID {
+			remaining = append(remaining, instance)
+		}
+	}
+	if len(remaining) > 0 {
+		// other instances still refer to the team, only take away what this instance added
+		err = c.updateTeamSpaces(concourseClient, entry.TeamName,
+			store.SpaceGUIDs(shared), store.SpaceGUIDs(remaining), entry)
+		if err != nil {
+			return err
+		}
+		return store.DeleteInstance(c.store, instanceID)
+	}
+	err = c.checkDeletable(entry.TeamName)
+	if err != nil {
+		return err
+	}
+	err = c.checkNoPipelineInstances(entry.TeamName)
+	if err != nil {
+		return err
+	}
+	spaces := store.SpaceGUIDs(shared)
+	if len(spaces) == 0 {
+		spaces = []string{cfDetails.SpaceGUID}
+	}
+	team := concourseClient.TeamConfig(spaces)
+	// the pipelines go with the team, keep them so the team can be restored
+	instanceIDs := []string{instanceID}
+	for _, instance := range shared {
+		if instance.ID != instanceID {
+			instanceIDs = append(instanceIDs, instance.ID)
+		}
+	}
+	record, err := c.archiver.Archive(concourseClient, entry.TeamName, team, instanceIDs, "deprovisioned")
+	if err != nil {
+		c.logger.Error("deprovision.archive-error", err, lager.Data{"team-name": entry.TeamName})
+		return err
+	}
+	c.logger.Info("deprovision.archived", lager.Data{"team-name": entry.TeamName, "archive-id": record.ID})
+	if c.deleter.Enabled() {
+		_, err = c.deleter.Delete(entry.TeamName, spaces, instanceIDs, record.ID)
+		if err != nil {
+			return err
+		}
+		// the pipelines a suspension paused stay paused when the team is undeleted
+		err = store.DeleteSuspension(c.store, entry.TeamName)
+		if err != nil {
+			return err
+		}
+		entry.Operation = "soft-delete"
+		return store.DeleteInstance(c.store, instanceID)
+	}
+	err = concourseClient.DestroyTeam(entry.TeamName)
+	if err != nil {
+		return err
+	}
+	entry.ConfigDiff = audit.Diff(&team, nil)
+	err = store.DeleteClone(c.store, entry.TeamName)
+	if err != nil {
+		return err
+	}
+	err = store.DeleteSuspension(c.store, entry.TeamName)
+	if err != nil {
+		return err
+	}
+	err = store.DeleteTeam(c.store, entry.TeamName)
+	if err != nil {
+		return err
+	}
+	return store.DeleteInstance(c.store, instanceID)
 }
 
+// updateTeamSpaces changes the spaces that have access to a team, if they changed at all.
+func (c *concourseBroker) updateTeamSpaces(concourseClient concourse.Client, teamName string,
+	before, after []string, entry *audit.Entry) error {
+	beforeTeam := concourseClient.TeamConfig(before)
+	afterTeam := concourseClient.TeamConfig(after)
+	entry.ConfigDiff = audit.Diff(&beforeTeam, &afterTeam)
+	if len(entry.ConfigDiff) == 0 {
+		return nil
+	}
+	return concourseClient.UpdateTeam(teamName, afterTeam)
+}
+
+// bindingCredentials are the credentials of the build API and, on plans with worker registration,
+// those to register a team worker.
+type bindingCredentials struct {
+	Team string `json:"team"`
+	*apiCredentials
+	*workerCredentials
+}
+
+// apiCredentials and workerCredentials let both kinds of credentials be embedded, so they share
+// the JSON object of the binding.
+type apiCredentials buildapi.Credentials
+type workerCredentials workerkeys.Credentials
+
 func (c *concourseBroker) Bind(context context.Context, instanceID,
 	bindingID string, details brokerapi.BindDetails) (brokerapi.Binding, error) {
-	return brokerapi.Binding{}, errors.New("service does not support bind")
+	entry := newAuditEntry(context, "bind", instanceID, details.PlanID)
+	credentials, err := c.bind(instanceID, bindingID, details, &entry)
+	c.record(entry, err)
+	if err != nil {
+		return brokerapi.Binding{}, err
+	}
+	return brokerapi.Binding{Credentials: credentials}, nil
 }
+
+func (c *concourseBroker) bind(instanceID, bindingID string, details brokerapi.BindDetails,
+	entry *audit.Entry) (bindingCredentials, error) {
+	_, found, err := store.GetPipelineInstance(c.store, instanceID)
+	if err != nil {
+		return bindingCredentials{}, err
+	}
+	if found {
+		return bindingCredentials{}, fmt.Errorf("Service %s does not support bindings", PipelineService)
+	}
+	instance, err := c.auditedInstance(instanceID, entry)
+	if err != nil {
+		return bindingCredentials{}, err
+	}
+	entry.Detail = "binding " + bindingID
+	workerRegistration := c.plans.Get(instance.PlanID).WorkerRegistration && c.workerKeys != nil
+	if !c.buildAPI.Enabled() && !workerRegistration {
+		return bindingCredentials{}, fmt.Errorf("Plan %s does not support bindings, BROKER_URL is not set", instance.PlanID)
+	}
+	_, found, err = store.GetBinding(c.store, bindingID)
+	if err != nil {
+		return bindingCredentials{}, err
+	}
+	_, hasKey, err := store.GetWorkerKey(c.store, bindingID)
+	if err != nil {
+		return bindingCredentials{}, err
+	}
+	if found || hasKey {
+		return bindingCredentials{}, brokerapi.ErrBindingAlreadyExists
+	}
+	params := buildapi.BindParams{}
+	if pipeline, ok := details.Parameters["pipeline"].(string); ok {
+		params.Pipeline = pipeline
+	}
+	if job, ok := details.Parameters["job"].(string); ok {
+		params.Job = job
+	}
+	credentials := bindingCredentials{Team: instance.TeamName}
+	if c.buildAPI.Enabled() {
+		issued, err := c.buildAPI.Issue(instance, bindingID, params)
+		if err != nil {
+			return bindingCredentials{}, err
+		}
+		credentials.apiCredentials = (*apiCredentials)(&issued)
+	}
+	if workerRegistration {
+		registered, err := c.workerKeys.Register(instance, bindingID)
+		if err != nil {
+			if credentials.apiCredentials != nil {
+				// best effort, the binding fails either way
+				c.buildAPI.Revoke(bindingID)
+			}
+			return bindingCredentials{}, err
+		}
+		credentials.workerCredentials = (*workerCredentials)(&registered)
+	}
+	return credentials, nil
+}
+
 func (c *concourseBroker) Unbind(context context.Context, instanceID, bindingID string,
 	details brokerapi.UnbindDetails) error {
-	return errors.New("service does not support bind")
+	entry := newAuditEntry(context, "unbind", instanceID, details.PlanID)
+	err := c.unbind(instanceID, bindingID, &entry)
+	c.record(entry, err)
+	return err
+}
+
+func (c *concourseBroker) unbind(instanceID, bindingID string, entry *audit.Entry) error {
+	_, err := c.auditedInstance(instanceID, entry)
+	if err != nil {
+		return err
+	}
+	entry.Detail = "binding " + bindingID
+	revoked := false
+	if c.buildAPI != nil {
+		revoked, err = c.buildAPI.Revoke(bindingID)
+		if err != nil {
+			return err
+		}
+	}
+	if c.workerKeys != nil {
+		hadKey, err := c.workerKeys.Revoke(bindingID)
+		if err != nil {
+			return err
+		}
+		revoked = revoked || hadKey
+	}
+	if !revoked {
+		return brokerapi.ErrBindingDoesNotExist
+	}
+	return nil
+}
+
+// auditedInstance returns an instance and fills in the audit details from it.
+func (c *concourseBroker) auditedInstance(instanceID string, entry *audit.Entry) (store.Instance, error) {
+	instance, found, err := store.GetInstance(c.store, instanceID)
+	if err != nil {
+		return store.Instance{}, err
+	}
+	if !found {
+		return store.Instance{}, brokerapi.ErrInstanceDoesNotExist
+	}
+	entry.TeamName = instance.TeamName
+	entry.OrgGUID = instance.OrgGUID
+	entry.OrgName = instance.OrgName
+	entry.SpaceGUID = instance.SpaceGUID
+	entry.SpaceName = instance.SpaceName
+	return instance, nil
 }
 
 func (c *concourseBroker) Update(context context.Context, instanceID string,
 	details brokerapi.UpdateDetails, asyncAllowed bool) (brokerapi.UpdateServiceSpec, error) {
+	entry := newAuditEntry(context, "update", instanceID, details.PlanID)
+	err := c.update(instanceID, details, &entry)
+	c.record(entry, err)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
 	return brokerapi.UpdateServiceSpec{}, nil
 }
 
+func (c *concourseBroker) update(instanceID string, details brokerapi.UpdateDetails, entry *audit.Entry) error {
+	pipelineInstance, found, err := store.GetPipelineInstance(c.store, instanceID)
+	if err != nil {
+		return err
+	}
+	if found {
+		return c.updatePipelineInstance(pipelineInstance, details, entry)
+	}
+	instance, err := c.auditedInstance(instanceID, entry)
+	if err != nil {
+		return err
+	}
+	if details.PlanID == "" || details.PlanID == instance.PlanID {
+		return nil
+	}
+	return c.changePlan(concourse.NewClient(c.env, c.logger), instance, details.PlanID)
+}
+
 func (c *concourseBroker) LastOperation(context context.Context, instanceID,
 	operationData string) (brokerapi.LastOperation, error) {
-	return brokerapi.LastOperation{}, nil
+	instance, found, err := store.GetInstance(c.store, instanceID)
+	if err != nil {
+		return brokerapi.LastOperation{}, err
+	}
+	if !found {
+		return c.pipelineLastOperation(instanceID)
+	}
+	descriptions := []string{}
+	if c.plans.Get(instance.PlanID).Suspend {
+		descriptions = append(descriptions, "The team is suspended, its pipelines are paused")
+	} else if len(instance.Warnings) > 0 {
+		descriptions = append(descriptions, "Concourse warned about the seeded pipelines: "+strings.Join(instance.Warnings, "; "))
+	}
+	if instance.IdleSince != nil {
+		descriptions = append(descriptions, "The team is idle since "+instance.IdleSince.Format("2006-01-02"))
+	}
+	usage, checked, err := store.GetQuota(c.store, instance.TeamName)
+	if err != nil {
+		return brokerapi.LastOperation{}, err
+	}
+	if checked {
+		description := "Usage: " + quota.Summary(usage)
+		if usage.ExceededAt != nil {
+			description += ", over the limits of the plan"
+		}
+		descriptions = append(descriptions, description)
+	}
+	return brokerapi.LastOperation{State: brokerapi.Succeeded, Description: strings.Join(descriptions, ". ")}, nil
+}
+
+// authorize checks the configured policy against the CF roles of the user the platform acts for.
+func (c *concourseBroker) authorize(ctx context.Context, action authz.Action, cfClient cf.Client, details cf.Details) error {
+	if !c.policy.Restricts(action) {
+		return nil
+	}
+	identity := requestInfo(ctx).Identity
+	roles := []cf.Role{}
+	if identity.UserID != "" {
+		var err error
+		roles, err = cfClient.GetUserRoles(identity.UserID, details)
+		if err != nil {
+			return err
+		}
+	}
+	err := c.policy.Authorize(action, identity.UserID, roles)
+	if err != nil {
+		c.logger.Error("authorize.forbidden", err, lager.Data{
+			"action":  action,
+			"user-id": identity.UserID,
+			"roles":   roles,
+		})
+	}
+	return err
+}
+
+func newAuditEntry(ctx context.Context, operation, instanceID, planID string) audit.Entry {
+	info := requestInfo(ctx)
+	return audit.Entry{
+		Operation:  operation,
+		InstanceID: instanceID,
+		PlanID:     planID,
+		Caller:     info.Identity.String(),
+		RequestID:  info.RequestID,
+	}
+}
+
+// orgTeamName returns the team of the org of details. An org keeps the team its first instance
+// created when it is renamed, so the team is looked up by org GUID among the instances first.
+func (c *concourseBroker) orgTeamName(concourseClient concourse.Client, details cf.Details) (string, error) {
+	instances, err := store.ListInstances(c.store)
+	if err != nil {
+		return "", err
+	}
+	for _, instance := range instances {
+		if details.OrgGUID != "" && instance.OrgGUID == details.OrgGUID {
+			return instance.TeamName, nil
+		}
+	}
+	return concourseClient.TeamName(details), nil
+}
+
+func setAuditDetails(entry *audit.Entry, concourseClient concourse.Client, details cf.Details) {
+	entry.TeamName = concourseClient.TeamName(details)
+	entry.OrgName = details.OrgName
+	entry.SpaceName = details.SpaceName
+	if details.OrgGUID != "" {
+		entry.OrgGUID = details.OrgGUID
+	}
+	if details.SpaceGUID != "" {
+		entry.SpaceGUID = details.SpaceGUID
+	}
+}
+
+// record writes the outcome of an operation to the audit log. A failure to audit is logged
+// but does not fail the operation, which has already happened at that point.
+func (c *concourseBroker) record(entry audit.Entry, err error) {
+	entry.Time = time.Now().UTC()
+	entry.Outcome = audit.Succeeded
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+	}
+	auditErr := c.auditLog.Record(entry)
+	if auditErr != nil {
+		c.logger.Error("audit.record-error", auditErr, lager.Data{
+			"operation":   entry.Operation,
+			"instance-id": entry.InstanceID,
+		})
+	}
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,69 @@
+package broker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/archive"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Renamed orgs", func() {
+	var (
+		broker          *concourseBroker
+		cfClient        *fakes.CFClient
+		concourseClient *fakes.ConcourseClient
+		dir             string
+		renamed         = cf.Details{OrgGUID: "org-guid", OrgName: "venture-industries", SpaceGUID: "space-a", SpaceName: "dev"}
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "archives")
+		Expect(err).NotTo(HaveOccurred())
+		broker = &concourseBroker{
+			logger:    lagertest.NewTestLogger("broker"),
+			store:     store.NewMemoryStore(),
+			teamsLock: &sync.Mutex{},
+		}
+		broker.archiver = archive.NewArchiver(archive.NewDir(dir), broker.store)
+		cfClient = fakes.NewCFClient()
+		cfClient.AddInstance("instance-1", renamed)
+		concourseClient = fakes.NewConcourseClient()
+		Expect(concourseClient.CreateTeam(cf.Details{OrgName: "venture", SpaceGUID: "space-a"})).To(Succeed())
+		Expect(store.SaveTeam(broker.store, store.Team{Name: "venture", CreatedBy: "instance-1"})).To(Succeed())
+		Expect(store.SaveInstance(broker.store, store.Instance{ID: "instance-1", OrgGUID: "org-guid", OrgName: "venture",
+			SpaceGUID: "space-a", TeamName: "venture"})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("keeps the team of the org for new instances", func() {
+		teamName, err := broker.orgTeamName(concourseClient, renamed)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(teamName).To(Equal("venture"))
+		teamName, err = broker.orgTeamName(concourseClient, cf.Details{OrgGUID: "other-guid", OrgName: "other"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(teamName).To(Equal("other"))
+	})
+
+	It("destroys the team the instance created on deprovision", func() {
+		entry := audit.Entry{Operation: "deprovision"}
+		Expect(broker.deprovisionTeam(context.Background(), cfClient, concourseClient, "instance-1", &entry)).To(Succeed())
+		Expect(entry.TeamName).To(Equal("venture"))
+		Expect(entry.OrgName).To(Equal("venture-industries"))
+		Expect(concourseClient.Destroyed).To(Equal([]string{"venture"}))
+		_, found, _ := store.GetTeam(broker.store, "venture")
+		Expect(found).To(BeFalse())
+	})
+})
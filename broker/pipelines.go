@@ -0,0 +1,365 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/authz"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// PipelineService is the name of the service whose instances each manage one pipeline in the team
+// of their org, next to the service whose instances manage the team.
+const PipelineService = "concourse-pipeline"
+
+// pipelineParams are the provision and update parameters of a pipeline instance.
+type pipelineParams struct {
+	Name string `json:"name"`
+	// Config is the pipeline config, as YAML text or as a JSON object
+	Config  json.RawMessage        `json:"config"`
+	Vars    map[string]interface{} `json:"vars"`
+	Unpause bool                   `json:"unpause"`
+}
+
+func parsePipelineParams(raw json.RawMessage) (pipelineParams, error) {
+	params := pipelineParams{}
+	if len(raw) == 0 {
+		return params, nil
+	}
+	err := json.Unmarshal(raw, &params)
+	return params, err
+}
+
+// rawConfig returns the config as the YAML the seeder reads, which JSON is too.
+func (p pipelineParams) rawConfig() []byte {
+	var text string
+	if json.Unmarshal(p.Config, &text) == nil {
+		return []byte(text)
+	}
+	return p.Config
+}
+
+func (p pipelineParams) empty() bool {
+	return p.Name == "" && len(p.Config) == 0 && len(p.Vars) == 0 && !p.Unpause
+}
+
+// isPipelineService tells whether a service ID is the one of the pipeline service.
+func (c *concourseBroker) isPipelineService(serviceID string) bool {
+	for _, service := range c.services {
+		if service.ID == serviceID {
+			return service.Name == PipelineService
+		}
+	}
+	return false
+}
+
+func (c *concourseBroker) provisionPipeline(ctx context.Context, instanceID string,
+	details brokerapi.ProvisionDetails, entry *audit.Entry) ([]string, error) {
+	entry.OrgGUID = details.OrganizationGUID
+	entry.SpaceGUID = details.SpaceGUID
+	_, found, err := store.GetPipelineInstance(c.store, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, brokerapi.ErrInstanceAlreadyExists
+	}
+	params, err := parsePipelineParams(details.RawParameters)
+	if err != nil {
+		return nil, brokerapi.ErrRawParamsInvalid
+	}
+	if params.Name == "" || len(params.Config) == 0 {
+		return nil, errors.New("A pipeline instance needs the name and the config of the pipeline in the parameters")
+	}
+	entry.Detail = "pipeline " + params.Name
+	cfClient, err := cf.NewClient(c.env)
+	if err != nil {
+		return nil, err
+	}
+	cfDetails, err := cfClient.GetProvisionDetails(details.SpaceGUID)
+	cfDetails.SpaceGUID = details.SpaceGUID
+	if err != nil {
+		return nil, err
+	}
+	concourseClient := concourse.NewClient(c.env, c.logger)
+	setAuditDetails(entry, concourseClient, cfDetails)
+	entry.TeamName, err = c.orgTeamName(concourseClient, cfDetails)
+	if err != nil {
+		return nil, err
+	}
+	err = c.authorize(ctx, authz.Provision, cfClient, cfDetails)
+	if err != nil {
+		return nil, err
+	}
+	c.teamsLock.Lock()
+	defer c.teamsLock.Unlock()
+	// the pipeline goes into a team the org has already, so only the rules on orgs and spaces apply,
+	// not the limits on the instances and teams of an org
+	err = c.rules.Check(cfDetails, entry.TeamName, nil)
+	if err != nil {
+		c.logger.Error("provision-pipeline.rejected", err, lager.Data{
+			"org-name":   cfDetails.OrgName,
+			"space-name": cfDetails.SpaceName,
+		})
+		return nil, err
+	}
+	instance := store.PipelineInstance{
+		ID:           instanceID,
+		ServiceID:    details.ServiceID,
+		PlanID:       details.PlanID,
+		OrgGUID:      entry.OrgGUID,
+		OrgName:      cfDetails.OrgName,
+		SpaceGUID:    cfDetails.SpaceGUID,
+		SpaceName:    cfDetails.SpaceName,
+		TeamName:     entry.TeamName,
+		PipelineName: params.Name,
+		CreatedAt:    time.Now().UTC(),
+	}
+	err = c.createPipeline(concourseClient, &instance, params)
+	if err != nil {
+		return nil, err
+	}
+	return instance.Warnings, nil
+}
+
+// createPipeline sets the pipeline of a new pipeline instance into the team of its org. The team
+// must be one the broker manages, and the pipeline must not exist yet.
+func (c *concourseBroker) createPipeline(concourseClient concourse.Client, instance *store.PipelineInstance,
+	params pipelineParams) error {
+	team, managed, err := store.GetTeam(c.store, instance.TeamName)
+	if err != nil {
+		return err
+	}
+	if !managed {
+		return fmt.Errorf("Org %s has no Concourse team yet, create an instance of the team service in the org first", instance.OrgName)
+	}
+	_, deleted, err := store.GetDeletion(c.store, team.Name)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return fmt.Errorf("Team %s was deleted, pipelines cannot be added to it", team.Name)
+	}
+	shared, err := store.TeamInstances(c.store, team.Name)
+	if err != nil {
+		return err
+	}
+	if !containsString(store.SpaceGUIDs(shared), instance.SpaceGUID) {
+		return fmt.Errorf("Space %s has no access to team %s, create an instance of the team service in the space first",
+			instance.SpaceName, team.Name)
+	}
+	owners, err := store.TeamPipelineInstances(c.store, team.Name)
+	if err != nil {
+		return err
+	}
+	for _, owner := range owners {
+		if owner.PipelineName == instance.PipelineName {
+			return fmt.Errorf("Pipeline %s of team %s is managed by instance %s already", owner.PipelineName, team.Name, owner.ID)
+		}
+	}
+	_, _, _, exists, err := concourseClient.PipelineConfig(team.Name, instance.PipelineName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Pipeline %s already exists in team %s", instance.PipelineName, team.Name)
+	}
+	instance.Warnings, err = c.setPipeline(concourseClient, team, *instance, "", params)
+	if err != nil {
+		return err
+	}
+	err = store.SavePipelineInstance(c.store, *instance)
+	if err != nil {
+		// best effort, the pipeline would be left without an instance
+		concourseClient.DeletePipeline(team.Name, instance.PipelineName)
+		return err
+	}
+	c.logger.Info("provision-pipeline", lager.Data{"team-name": team.Name, "pipeline-name": instance.PipelineName})
+	return nil
+}
+
+// updatePipeline sets the config of the parameters on the pipeline of an instance. The pipeline
+// keeps its name.
+func (c *concourseBroker) updatePipeline(concourseClient concourse.Client, instance store.PipelineInstance,
+	params pipelineParams) error {
+	if params.empty() {
+		return nil
+	}
+	if params.Name != "" && params.Name != instance.PipelineName {
+		return fmt.Errorf("The pipeline of an instance cannot be renamed, it is %s", instance.PipelineName)
+	}
+	if len(params.Config) == 0 {
+		return errors.New("An update of a pipeline instance needs the config of the pipeline in the parameters")
+	}
+	team, managed, err := store.GetTeam(c.store, instance.TeamName)
+	if err != nil {
+		return err
+	}
+	if !managed {
+		return fmt.Errorf("Team %s of pipeline %s is gone", instance.TeamName, instance.PipelineName)
+	}
+	// a pipeline deleted in Concourse is set again
+	_, _, version, _, err := concourseClient.PipelineConfig(team.Name, instance.PipelineName)
+	if err != nil {
+		return err
+	}
+	instance.Warnings, err = c.setPipeline(concourseClient, team, instance, version, params)
+	if err != nil {
+		return err
+	}
+	return store.SavePipelineInstance(c.store, instance)
+}
+
+func (c *concourseBroker) updatePipelineInstance(instance store.PipelineInstance, details brokerapi.UpdateDetails,
+	entry *audit.Entry) error {
+	entry.TeamName = instance.TeamName
+	entry.OrgGUID = instance.OrgGUID
+	entry.OrgName = instance.OrgName
+	entry.SpaceGUID = instance.SpaceGUID
+	entry.SpaceName = instance.SpaceName
+	entry.Detail = "pipeline " + instance.PipelineName
+	raw, err := json.Marshal(details.Parameters)
+	if err != nil {
+		return brokerapi.ErrRawParamsInvalid
+	}
+	params, err := parsePipelineParams(raw)
+	if err != nil {
+		return brokerapi.ErrRawParamsInvalid
+	}
+	c.teamsLock.Lock()
+	defer c.teamsLock.Unlock()
+	err = c.updatePipeline(concourse.NewClient(c.env, c.logger), instance, params)
+	if err != nil {
+		return err
+	}
+	if details.PlanID == "" || details.PlanID == instance.PlanID {
+		return nil
+	}
+	// the pipeline was saved with its warnings by now
+	instance, found, err := store.GetPipelineInstance(c.store, instance.ID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return brokerapi.ErrInstanceDoesNotExist
+	}
+	instance.PlanID = details.PlanID
+	return store.SavePipelineInstance(c.store, instance)
+}
+
+// pipelineLastOperation reports the warnings Concourse gave for the config of a pipeline instance.
+func (c *concourseBroker) pipelineLastOperation(instanceID string) (brokerapi.LastOperation, error) {
+	instance, found, err := store.GetPipelineInstance(c.store, instanceID)
+	if err != nil {
+		return brokerapi.LastOperation{}, err
+	}
+	if !found {
+		return brokerapi.LastOperation{}, brokerapi.ErrInstanceDoesNotExist
+	}
+	description := ""
+	if len(instance.Warnings) > 0 {
+		description = "Concourse warned about the pipeline: " + strings.Join(instance.Warnings, "; ")
+	}
+	return brokerapi.LastOperation{State: brokerapi.Succeeded, Description: description}, nil
+}
+
+func (c *concourseBroker) setPipeline(concourseClient concourse.Client, team store.Team, instance store.PipelineInstance,
+	version string, params pipelineParams) ([]string, error) {
+	vars := map[string]interface{}{
+		"team_name":     team.Name,
+		"org_name":      instance.OrgName,
+		"space_name":    instance.SpaceName,
+		"instance_id":   instance.ID,
+		"pipeline_name": instance.PipelineName,
+	}
+	for name, value := range params.Vars {
+		vars[name] = value
+	}
+	warnings, err := c.seeder.SetPipeline(concourseClient, team.Name, team.WorkerTag, instance.PipelineName, version,
+		params.rawConfig(), vars)
+	if err != nil {
+		return nil, fmt.Errorf("Setting pipeline %s failed: %s", instance.PipelineName, err)
+	}
+	if !params.Unpause {
+		return warnings, nil
+	}
+	// a suspended team keeps its pipelines paused until it is resumed
+	suspension, suspended, err := store.GetSuspension(c.store, team.Name)
+	if err != nil {
+		return nil, err
+	}
+	if suspended {
+		if !containsString(suspension.Running, instance.PipelineName) {
+			suspension.Running = append(suspension.Running, instance.PipelineName)
+		}
+		return warnings, store.SaveSuspension(c.store, suspension)
+	}
+	return warnings, concourseClient.UnpausePipeline(team.Name, instance.PipelineName)
+}
+
+func (c *concourseBroker) deprovisionPipeline(ctx context.Context, instance store.PipelineInstance, entry *audit.Entry) error {
+	entry.TeamName = instance.TeamName
+	entry.OrgGUID = instance.OrgGUID
+	entry.OrgName = instance.OrgName
+	entry.SpaceGUID = instance.SpaceGUID
+	entry.SpaceName = instance.SpaceName
+	entry.Detail = "pipeline " + instance.PipelineName
+	cfClient, err := cf.NewClient(c.env)
+	if err != nil {
+		return err
+	}
+	err = c.authorize(ctx, authz.Deprovision, cfClient, cf.Details{
+		OrgGUID:   instance.OrgGUID,
+		OrgName:   instance.OrgName,
+		SpaceGUID: instance.SpaceGUID,
+		SpaceName: instance.SpaceName,
+	})
+	if err != nil {
+		return err
+	}
+	c.teamsLock.Lock()
+	defer c.teamsLock.Unlock()
+	return c.deletePipeline(concourse.NewClient(c.env, c.logger), instance)
+}
+
+// deletePipeline deletes the pipeline of an instance, unless it is gone already.
+func (c *concourseBroker) deletePipeline(concourseClient concourse.Client, instance store.PipelineInstance) error {
+	_, _, _, found, err := concourseClient.PipelineConfig(instance.TeamName, instance.PipelineName)
+	if err != nil {
+		return err
+	}
+	if found {
+		err = concourseClient.DeletePipeline(instance.TeamName, instance.PipelineName)
+		if err != nil {
+			return err
+		}
+	}
+	c.logger.Info("deprovision-pipeline", lager.Data{"team-name": instance.TeamName, "pipeline-name": instance.PipelineName})
+	return store.DeletePipelineInstance(c.store, instance.ID)
+}
+
+// checkNoPipelineInstances refuses to destroy a team while pipeline instances manage pipelines in it.
+func (c *concourseBroker) checkNoPipelineInstances(teamName string) error {
+	instances, err := store.TeamPipelineInstances(c.store, teamName)
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return nil
+	}
+	names := []string{}
+	for _, instance := range instances {
+		names = append(names, instance.PipelineName)
+	}
+	return fmt.Errorf("Team %s still has instances of the %s service for pipelines %s, delete them first",
+		teamName, PipelineService, strings.Join(names, ", "))
+}
@@ -0,0 +1,163 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/policy"
+	"github.com/vchrisr/concourse-broker/seed"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+const appPipeline = `
+resources:
+- name: source
+  type: git
+  source: {uri: ((repo))}
+jobs:
+- name: test
+  plan:
+  - get: source
+`
+
+var _ = Describe("Pipeline instances", func() {
+	var (
+		broker          *concourseBroker
+		concourseClient *fakes.ConcourseClient
+		instance        store.PipelineInstance
+	)
+
+	params := func(raw string) pipelineParams {
+		params, err := parsePipelineParams(json.RawMessage(raw))
+		Expect(err).NotTo(HaveOccurred())
+		return params
+	}
+
+	configParams := func(extra string) pipelineParams {
+		config, _ := json.Marshal(appPipeline)
+		return params(`{"name": "app", "config": ` + string(config) + extra + `}`)
+	}
+
+	BeforeEach(func() {
+		broker = &concourseBroker{
+			services: []brokerapi.Service{
+				{ID: "team-service", Name: "concourse-ci"},
+				{ID: "pipeline-service", Name: PipelineService},
+			},
+			logger:    lagertest.NewTestLogger("broker"),
+			store:     store.NewMemoryStore(),
+			seeder:    seed.New(config.Env{}, policy.Rules{}),
+			teamsLock: &sync.Mutex{},
+		}
+		concourseClient = fakes.NewConcourseClient()
+		Expect(concourseClient.CreateTeam(cf.Details{OrgName: "venture", SpaceGUID: "space-a"})).To(Succeed())
+		Expect(store.SaveTeam(broker.store, store.Team{Name: "venture", CreatedBy: "instance-1", WorkerTag: "segment-a"})).To(Succeed())
+		Expect(store.SaveInstance(broker.store, store.Instance{ID: "instance-1", TeamName: "venture", SpaceGUID: "space-a"})).To(Succeed())
+		instance = store.PipelineInstance{ID: "pipeline-1", OrgName: "venture", SpaceGUID: "space-a", SpaceName: "dev", TeamName: "venture", PipelineName: "app"}
+	})
+
+	It("tells the services apart", func() {
+		Expect(broker.isPipelineService("pipeline-service")).To(BeTrue())
+		Expect(broker.isPipelineService("team-service")).To(BeFalse())
+	})
+
+	It("answers the deprovision of an unknown pipeline instance without touching the teams", func() {
+		entry := newAuditEntry(context.Background(), "deprovision", "instance-1", "")
+		err := broker.deprovision(context.Background(), "instance-1", "pipeline-service", &entry)
+		Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+		_, found, _ := store.GetTeam(broker.store, "venture")
+		Expect(found).To(BeTrue())
+	})
+
+	It("sets the pipeline into the team of the org", func() {
+		Expect(broker.createPipeline(concourseClient, &instance, configParams(`, "vars": {"repo": "https://example.com/app"}, "unpause": true`))).To(Succeed())
+		pipeline := concourseClient.Pipelines["venture"][0]
+		Expect(pipeline.Name).To(Equal("app"))
+		Expect(pipeline.Paused).To(BeFalse())
+		Expect(pipeline.Config.Resources[0].Source["uri"]).To(Equal("https://example.com/app"))
+		Expect(pipeline.Config.Resources[0].Tags).To(Equal(atc.Tags{"segment-a"}))
+		_, found, _ := store.GetPipelineInstance(broker.store, "pipeline-1")
+		Expect(found).To(BeTrue())
+	})
+
+	It("takes the config as a JSON object too", func() {
+		Expect(broker.createPipeline(concourseClient, &instance,
+			params(`{"name": "app", "config": {"jobs": [{"name": "noop", "plan": []}]}}`))).To(Succeed())
+		Expect(concourseClient.Pipelines["venture"][0].Config.Jobs[0].Name).To(Equal("noop"))
+	})
+
+	It("refuses orgs without a team of the broker and pipelines that exist", func() {
+		instance.TeamName = "other"
+		instance.OrgName = "other"
+		Expect(broker.createPipeline(concourseClient, &instance, configParams(""))).To(MatchError(ContainSubstring("Org other has no Concourse team yet")))
+
+		instance.TeamName = "venture"
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "app"}, atc.Config{})
+		Expect(broker.createPipeline(concourseClient, &instance, configParams(""))).To(MatchError("Pipeline app already exists in team venture"))
+		_, found, _ := store.GetPipelineInstance(broker.store, "pipeline-1")
+		Expect(found).To(BeFalse())
+	})
+
+	It("refuses spaces without access to the team", func() {
+		instance.SpaceGUID = "space-b"
+		Expect(broker.createPipeline(concourseClient, &instance, configParams(""))).To(
+			MatchError("Space dev has no access to team venture, create an instance of the team service in the space first"))
+		Expect(concourseClient.Pipelines["venture"]).To(BeEmpty())
+	})
+
+	It("keeps the pipeline of a suspended team paused until it is resumed", func() {
+		Expect(store.SaveSuspension(broker.store, store.Suspension{TeamName: "venture", Running: []string{}})).To(Succeed())
+		Expect(broker.createPipeline(concourseClient, &instance, configParams(`, "unpause": true`))).To(Succeed())
+		Expect(concourseClient.Pipelines["venture"][0].Paused).To(BeTrue())
+		suspension, _, _ := store.GetSuspension(broker.store, "venture")
+		Expect(suspension.Running).To(Equal([]string{"app"}))
+	})
+
+	It("updates the config but not the name", func() {
+		Expect(broker.createPipeline(concourseClient, &instance, configParams(""))).To(Succeed())
+		Expect(broker.updatePipeline(concourseClient, instance, params(`{}`))).To(Succeed())
+		Expect(concourseClient.Pipelines["venture"][0].Version).To(Equal("1"))
+
+		Expect(broker.updatePipeline(concourseClient, instance, params(`{"config": "jobs: [{name: build, plan: []}]"}`))).To(Succeed())
+		pipeline := concourseClient.Pipelines["venture"][0]
+		Expect(pipeline.Version).To(Equal("2"))
+		Expect(pipeline.Config.Jobs[0].Name).To(Equal("build"))
+
+		Expect(broker.updatePipeline(concourseClient, instance, params(`{"name": "other", "config": "jobs: []"}`))).To(
+			MatchError("The pipeline of an instance cannot be renamed, it is app"))
+		Expect(broker.updatePipeline(concourseClient, instance, params(`{"unpause": true}`))).To(
+			MatchError(ContainSubstring("needs the config")))
+	})
+
+	It("keeps the plan of an instance whose update fails", func() {
+		instance.PlanID = "small"
+		Expect(store.SavePipelineInstance(broker.store, instance)).To(Succeed())
+		entry := newAuditEntry(context.Background(), "update", "pipeline-1", "large")
+		err := broker.updatePipelineInstance(instance, brokerapi.UpdateDetails{PlanID: "large",
+			Parameters: map[string]interface{}{"name": "other", "config": "jobs: []"}}, &entry)
+		Expect(err).To(MatchError("The pipeline of an instance cannot be renamed, it is app"))
+		saved, _, _ := store.GetPipelineInstance(broker.store, "pipeline-1")
+		Expect(saved.PlanID).To(Equal("small"))
+	})
+
+	It("deletes the pipeline and keeps the team from being destroyed until then", func() {
+		Expect(broker.createPipeline(concourseClient, &instance, configParams(""))).To(Succeed())
+		Expect(broker.checkNoPipelineInstances("venture")).To(MatchError(
+			"Team venture still has instances of the concourse-pipeline service for pipelines app, delete them first"))
+
+		Expect(broker.deletePipeline(concourseClient, instance)).To(Succeed())
+		Expect(concourseClient.Pipelines["venture"]).To(BeEmpty())
+		Expect(broker.checkNoPipelineInstances("venture")).To(Succeed())
+		_, found, _ := store.GetPipelineInstance(broker.store, "pipeline-1")
+		Expect(found).To(BeFalse())
+	})
+})
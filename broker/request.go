@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	originatingIdentityHeader = "X-Broker-API-Originating-Identity"
+	requestIdentityHeader     = "X-Broker-API-Request-Identity"
+)
+
+type requestInfoKey struct{}
+
+// Identity is the platform user on whose behalf the platform calls the broker.
+type Identity struct {
+	Platform string
+	UserID   string
+}
+
+func (i Identity) String() string {
+	if i.UserID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s", i.Platform, i.UserID)
+}
+
+// RequestInfo holds the OSB headers that brokerapi does not pass on to the broker.
+type RequestInfo struct {
+	Identity  Identity
+	RequestID string
+}
+
+// ParseOriginatingIdentity decodes a X-Broker-API-Originating-Identity header value,
+// which is the platform name followed by a base64 encoded JSON object.
+func ParseOriginatingIdentity(header string) (Identity, error) {
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return Identity{}, fmt.Errorf("Malformed originating identity %q", header)
+	}
+	value, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Identity{}, fmt.Errorf("Error decoding originating identity %v", err)
+	}
+	var properties struct {
+		UserID string `json:"user_id"`
+	}
+	err = json.Unmarshal(value, &properties)
+	if err != nil {
+		return Identity{}, fmt.Errorf("Error unmarshalling originating identity %v", err)
+	}
+	return Identity{Platform: parts[0], UserID: properties.UserID}, nil
+}
+
+// WithRequestInfo makes the OSB request headers available to the broker through the request context.
+func WithRequestInfo(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := RequestInfo{RequestID: r.Header.Get(requestIdentityHeader)}
+		if header := r.Header.Get(originatingIdentityHeader); header != "" {
+			// a malformed identity is treated like a missing one
+			info.Identity, _ = ParseOriginatingIdentity(header)
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+	})
+}
+
+func requestInfo(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info
+}
@@ -0,0 +1,145 @@
+package broker
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// Steps a provision takes. Each has an undo in undoStep.
+const (
+	createTeamStep   = "create-team"
+	addSpaceStep     = "add-space"
+	saveTeamStep     = "save-team"
+	seedPipelineStep = "seed-pipelines"
+	saveCloneStep    = "save-clone"
+	saveInstanceStep = "save-instance"
+)
+
+// step is one change a provision makes.
+type step struct {
+	name string
+	do   func() error
+}
+
+// runSteps runs the steps of a provision in order and journals every completed step. When a step
+// fails the completed steps are undone in reverse order and the error of the step is returned.
+// Steps can add to the journal through provision.
+func (c *concourseBroker) runSteps(concourseClient concourse.Client, provision *store.Provision, steps []step) error {
+	provision.Steps = []string{}
+	err := store.SaveProvision(c.store, *provision)
+	if err != nil {
+		return err
+	}
+	for _, s := range steps {
+		err = s.do()
+		if err == nil {
+			provision.Steps = append(provision.Steps, s.name)
+			err = store.SaveProvision(c.store, *provision)
+		}
+		if err != nil {
+			c.logger.Error("provision.step-error", err, lager.Data{
+				"instance-id": provision.InstanceID,
+				"step":        s.name,
+			})
+			c.rollback(concourseClient, *provision)
+			return err
+		}
+	}
+	return store.DeleteProvision(c.store, provision.InstanceID)
+}
+
+// rollback undoes the completed steps of a provision in reverse order. Steps that cannot be undone
+// stay in the journal, so the orphan mitigation deprovision of the platform can try again.
+func (c *concourseBroker) rollback(concourseClient concourse.Client, provision store.Provision) error {
+	undone := []string{}
+	failed := []string{}
+	var lastErr error
+	for i := len(provision.Steps) - 1; i >= 0; i-- {
+		name := provision.Steps[i]
+		err := c.undoStep(concourseClient, provision, name)
+		if err != nil {
+			c.logger.Error("provision.rollback-error", err, lager.Data{
+				"instance-id": provision.InstanceID,
+				"step":        name,
+			})
+			failed = append([]string{name}, failed...)
+			lastErr = err
+			continue
+		}
+		undone = append(undone, name)
+	}
+	c.logger.Info("provision.rollback", lager.Data{
+		"instance-id": provision.InstanceID,
+		"team-name":   provision.TeamName,
+		"undone":      undone,
+		"failed":      failed,
+	})
+	if lastErr != nil {
+		provision.Steps = failed
+		err := store.SaveProvision(c.store, provision)
+		if err != nil {
+			c.logger.Error("provision.rollback-save-error", err, lager.Data{"instance-id": provision.InstanceID})
+		}
+		return fmt.Errorf("Rolling back the provision of instance %s failed: %s", provision.InstanceID, lastErr)
+	}
+	return store.DeleteProvision(c.store, provision.InstanceID)
+}
+
+func (c *concourseBroker) undoStep(concourseClient concourse.Client, provision store.Provision, name string) error {
+	switch name {
+	case createTeamStep:
+		return concourseClient.DestroyTeam(provision.TeamName)
+	case addSpaceStep:
+		// the instance was never recorded, so the other instances of the team hold the spaces it should keep
+		shared, err := store.TeamInstances(c.store, provision.TeamName)
+		if err != nil {
+			return err
+		}
+		remaining := []store.Instance{}
+		for _, instance := range shared {
+			if instance.ID != provision.InstanceID {
+				remaining = append(remaining, instance)
+			}
+		}
+		if len(remaining) == 0 {
+			return nil
+		}
+		spaces := store.SpaceGUIDs(remaining)
+		return concourseClient.UpdateTeam(provision.TeamName, concourseClient.TeamConfig(spaces))
+	case saveTeamStep:
+		return store.DeleteTeam(c.store, provision.TeamName)
+	case seedPipelineStep:
+		for _, pipeline := range provision.Pipelines {
+			err := concourseClient.DeletePipeline(provision.TeamName, pipeline)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case saveCloneStep:
+		return store.DeleteClone(c.store, provision.TeamName)
+	case saveInstanceStep:
+		return store.DeleteInstance(c.store, provision.InstanceID)
+	}
+	return fmt.Errorf("Unknown provision step %s", name)
+}
+
+// mitigateOrphan handles the deprovision the platform sends when it gave up on a provision. If the
+// provision never finished, whatever it did is undone instead of deprovisioning the usual way.
+func (c *concourseBroker) mitigateOrphan(instanceID string, concourseClient concourse.Client) (bool, store.Provision, error) {
+	c.teamsLock.Lock()
+	defer c.teamsLock.Unlock()
+	provision, found, err := store.GetProvision(c.store, instanceID)
+	if err != nil || !found {
+		return false, provision, err
+	}
+	c.logger.Info("deprovision.orphan-mitigation", lager.Data{
+		"instance-id": instanceID,
+		"team-name":   provision.TeamName,
+		"steps":       provision.Steps,
+	})
+	return true, provision, c.rollback(concourseClient, provision)
+}
@@ -0,0 +1,155 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/vchrisr/concourse-broker/archive"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Provision steps", func() {
+	var (
+		broker          *concourseBroker
+		concourseClient *fakes.ConcourseClient
+		provision       store.Provision
+		details         = cf.Details{OrgGUID: "org-guid", OrgName: "venture", SpaceGUID: "space-a", SpaceName: "dev"}
+		entry           audit.Entry
+		steps           []step
+	)
+
+	saveInstance := step{name: saveInstanceStep, do: func() error {
+		return store.SaveInstance(broker.store, store.Instance{ID: "instance-1", SpaceGUID: "space-a", TeamName: "venture"})
+	}}
+	failing := step{name: "fail", do: func() error { return errors.New("boom") }}
+
+	BeforeEach(func() {
+		broker = &concourseBroker{
+			logger:    lagertest.NewTestLogger("broker"),
+			store:     store.NewMemoryStore(),
+			teamsLock: &sync.Mutex{},
+		}
+		concourseClient = fakes.NewConcourseClient()
+		provision = store.Provision{InstanceID: "instance-1", TeamName: "venture", SpaceGUID: "space-a"}
+		entry = audit.Entry{TeamName: "venture"}
+		steps = broker.createTeamSteps(concourseClient, "instance-1", details, "", &entry)
+	})
+
+	It("runs every step and forgets the journal", func() {
+		err := broker.runSteps(concourseClient, &provision, append(steps, saveInstance))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(concourseClient.Teams).To(HaveKey("venture"))
+		_, found, _ := store.GetTeam(broker.store, "venture")
+		Expect(found).To(BeTrue())
+		_, found, _ = store.GetProvision(broker.store, "instance-1")
+		Expect(found).To(BeFalse())
+	})
+
+	It("undoes the completed steps when a step fails", func() {
+		err := broker.runSteps(concourseClient, &provision, append(steps, saveInstance, failing))
+		Expect(err).To(MatchError("boom"))
+		Expect(concourseClient.Teams).NotTo(HaveKey("venture"))
+		Expect(concourseClient.Destroyed).To(Equal([]string{"venture"}))
+		_, found, _ := store.GetTeam(broker.store, "venture")
+		Expect(found).To(BeFalse())
+		_, found, _ = store.GetInstance(broker.store, "instance-1")
+		Expect(found).To(BeFalse())
+		_, found, _ = store.GetProvision(broker.store, "instance-1")
+		Expect(found).To(BeFalse())
+	})
+
+	It("removes only the space it added from a shared team", func() {
+		Expect(concourseClient.CreateTeam(cf.Details{OrgName: "venture", SpaceGUID: "space-b"})).To(Succeed())
+		Expect(store.SaveInstance(broker.store, store.Instance{ID: "instance-0", SpaceGUID: "space-b", TeamName: "venture"})).To(Succeed())
+		shared, _ := store.TeamInstances(broker.store, "venture")
+		steps := []step{{name: addSpaceStep, do: func() error {
+			return broker.updateTeamSpaces(concourseClient, "venture", store.SpaceGUIDs(shared), []string{"space-b", "space-a"}, &entry)
+		}}}
+		err := broker.runSteps(concourseClient, &provision, append(steps, failing))
+		Expect(err).To(HaveOccurred())
+		Expect(concourseClient.Teams["venture"].UAAAuth.CFSpaces).To(Equal([]string{"space-b"}))
+	})
+
+	Context("when the platform gives up on a provision", func() {
+		It("undoes the journaled steps on the orphan mitigation deprovision", func() {
+			Expect(concourseClient.CreateTeam(details)).To(Succeed())
+			Expect(store.SaveTeam(broker.store, store.Team{Name: "venture", CreatedBy: "instance-1"})).To(Succeed())
+			provision.Steps = []string{createTeamStep, saveTeamStep}
+			Expect(store.SaveProvision(broker.store, provision)).To(Succeed())
+
+			mitigated, _, err := broker.mitigateOrphan("instance-1", concourseClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mitigated).To(BeTrue())
+			Expect(concourseClient.Teams).NotTo(HaveKey("venture"))
+			_, found, _ := store.GetProvision(broker.store, "instance-1")
+			Expect(found).To(BeFalse())
+		})
+
+		It("keeps the steps that could not be undone", func() {
+			provision.Steps = []string{createTeamStep, saveTeamStep}
+			Expect(store.SaveProvision(broker.store, provision)).To(Succeed())
+			concourseClient.Err = errors.New("concourse is down")
+
+			mitigated, _, err := broker.mitigateOrphan("instance-1", concourseClient)
+			Expect(mitigated).To(BeTrue())
+			Expect(err).To(HaveOccurred())
+			pending, found, _ := store.GetProvision(broker.store, "instance-1")
+			Expect(found).To(BeTrue())
+			Expect(pending.Steps).To(Equal([]string{createTeamStep}))
+		})
+
+		It("leaves finished provisions to the usual deprovision", func() {
+			mitigated, _, err := broker.mitigateOrphan("instance-1", concourseClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mitigated).To(BeFalse())
+		})
+
+		Context("when the provision finished after the platform gave up", func() {
+			var (
+				cfClient *fakes.CFClient
+				dir      string
+			)
+
+			BeforeEach(func() {
+				var err error
+				dir, err = ioutil.TempDir("", "archives")
+				Expect(err).NotTo(HaveOccurred())
+				broker.archiver = archive.NewArchiver(archive.NewDir(dir), broker.store)
+				cfClient = fakes.NewCFClient()
+				Expect(broker.runSteps(concourseClient, &provision, append(steps, saveInstance))).To(Succeed())
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(dir)
+			})
+
+			It("destroys the team of the instance CF never recorded", func() {
+				entry := audit.Entry{Operation: "deprovision"}
+				Expect(broker.deprovisionTeam(context.Background(), cfClient, concourseClient, "instance-1", &entry)).To(Succeed())
+				Expect(entry.Operation).To(Equal("orphan-mitigation"))
+				Expect(entry.TeamName).To(Equal("venture"))
+				Expect(concourseClient.Destroyed).To(Equal([]string{"venture"}))
+				_, found, _ := store.GetTeam(broker.store, "venture")
+				Expect(found).To(BeFalse())
+				_, found, _ = store.GetInstance(broker.store, "instance-1")
+				Expect(found).To(BeFalse())
+			})
+
+			It("tells the platform an instance it knows nothing about is gone", func() {
+				err := broker.deprovisionTeam(context.Background(), cfClient, concourseClient, "instance-2", &audit.Entry{})
+				Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+				Expect(concourseClient.Teams).To(HaveKey("venture"))
+			})
+		})
+	})
+})
@@ -0,0 +1,100 @@
+package broker
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// changePlan moves an instance to another plan. A team is suspended while any of its instances is
+// on a suspending plan and resumed when the last of them moves off one. The plan is only saved once
+// the team was suspended or resumed, so the update the platform retries after a failure does it again.
+func (c *concourseBroker) changePlan(concourseClient concourse.Client, instance store.Instance, planID string) error {
+	c.teamsLock.Lock()
+	defer c.teamsLock.Unlock()
+	instance.PlanID = planID
+	instances, err := store.TeamInstances(c.store, instance.TeamName)
+	if err != nil {
+		return err
+	}
+	suspended := c.plans.Get(planID).Suspend
+	for _, other := range instances {
+		if other.ID != instance.ID && c.plans.Get(other.PlanID).Suspend {
+			suspended = true
+		}
+	}
+	if suspended {
+		err = c.suspend(concourseClient, instance.TeamName)
+	} else {
+		err = c.resume(concourseClient, instance.TeamName)
+	}
+	if err != nil {
+		return err
+	}
+	return store.SaveInstance(c.store, instance)
+}
+
+// suspend pauses every running pipeline of a team and remembers which ones were running.
+func (c *concourseBroker) suspend(concourseClient concourse.Client, teamName string) error {
+	suspension, found, err := store.GetSuspension(c.store, teamName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		suspension = store.Suspension{TeamName: teamName, SuspendedAt: time.Now().UTC(), Running: []string{}}
+	}
+	pipelines, err := concourseClient.ListPipelines(teamName)
+	if err != nil {
+		return err
+	}
+	// a running pipeline of a suspended team was unpaused by hand, it is running when the team resumes too
+	for _, pipeline := range pipelines {
+		if !pipeline.Paused && !containsString(suspension.Running, pipeline.Name) {
+			suspension.Running = append(suspension.Running, pipeline.Name)
+		}
+	}
+	// saved before anything is paused, so the pipelines to unpause are known even if pausing fails
+	err = store.SaveSuspension(c.store, suspension)
+	if err != nil {
+		return err
+	}
+	for _, pipeline := range pipelines {
+		if pipeline.Paused {
+			continue
+		}
+		err = concourseClient.PausePipeline(teamName, pipeline.Name)
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		c.logger.Info("update.suspended", lager.Data{"team-name": teamName, "paused": suspension.Running})
+	}
+	return nil
+}
+
+// resume unpauses the pipelines that were running when a team was suspended. Pipelines deleted in
+// the meantime are skipped.
+func (c *concourseBroker) resume(concourseClient concourse.Client, teamName string) error {
+	suspension, found, err := store.GetSuspension(c.store, teamName)
+	if err != nil || !found {
+		return err
+	}
+	pipelines, err := concourseClient.ListPipelines(teamName)
+	if err != nil {
+		return err
+	}
+	for _, pipeline := range pipelines {
+		if !pipeline.Paused || !containsString(suspension.Running, pipeline.Name) {
+			continue
+		}
+		err = concourseClient.UnpausePipeline(teamName, pipeline.Name)
+		if err != nil {
+			return err
+		}
+	}
+	c.logger.Info("update.resumed", lager.Data{"team-name": teamName, "unpaused": suspension.Running})
+	return store.DeleteSuspension(c.store, teamName)
+}
@@ -0,0 +1,95 @@
+package broker
+
+import (
+	"errors"
+	"sync"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Suspended plans", func() {
+	var (
+		broker          *concourseBroker
+		concourseClient *fakes.ConcourseClient
+		instance        store.Instance
+	)
+
+	paused := func() map[string]bool {
+		pipelines, err := concourseClient.ListPipelines("venture")
+		Expect(err).NotTo(HaveOccurred())
+		result := map[string]bool{}
+		for _, pipeline := range pipelines {
+			result[pipeline.Name] = pipeline.Paused
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		broker = &concourseBroker{
+			logger:    lagertest.NewTestLogger("broker"),
+			store:     store.NewMemoryStore(),
+			plans:     plans.Plans{"suspended": {Suspend: true}},
+			teamsLock: &sync.Mutex{},
+		}
+		concourseClient = fakes.NewConcourseClient()
+		Expect(concourseClient.CreateTeam(cf.Details{OrgName: "venture", SpaceGUID: "space-a"})).To(Succeed())
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "deploy"}, atc.Config{})
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "nightly", Paused: true}, atc.Config{})
+		instance = store.Instance{ID: "instance-1", PlanID: "small", SpaceGUID: "space-a", TeamName: "venture"}
+		Expect(store.SaveInstance(broker.store, instance)).To(Succeed())
+	})
+
+	It("pauses every pipeline when an instance is suspended", func() {
+		Expect(broker.changePlan(concourseClient, instance, "suspended")).To(Succeed())
+		Expect(paused()).To(Equal(map[string]bool{"deploy": true, "nightly": true}))
+		suspension, found, err := store.GetSuspension(broker.store, "venture")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(suspension.Running).To(Equal([]string{"deploy"}))
+		updated, _, _ := store.GetInstance(broker.store, "instance-1")
+		Expect(updated.PlanID).To(Equal("suspended"))
+	})
+
+	It("unpauses only the pipelines that were running when it moves back", func() {
+		Expect(broker.changePlan(concourseClient, instance, "suspended")).To(Succeed())
+		instance.PlanID = "suspended"
+		Expect(broker.changePlan(concourseClient, instance, "small")).To(Succeed())
+		Expect(paused()).To(Equal(map[string]bool{"deploy": false, "nightly": true}))
+		_, found, err := store.GetSuspension(broker.store, "venture")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+	})
+
+	It("keeps a shared team suspended while another instance is suspended", func() {
+		other := store.Instance{ID: "instance-2", PlanID: "small", SpaceGUID: "space-b", TeamName: "venture"}
+		Expect(store.SaveInstance(broker.store, other)).To(Succeed())
+		Expect(broker.changePlan(concourseClient, instance, "suspended")).To(Succeed())
+		Expect(broker.changePlan(concourseClient, other, "suspended")).To(Succeed())
+		instance.PlanID = "suspended"
+		Expect(broker.changePlan(concourseClient, instance, "small")).To(Succeed())
+		Expect(paused()["deploy"]).To(BeTrue())
+	})
+
+	It("keeps the plan when pausing fails, so the retried update pauses again", func() {
+		concourseClient.Err = errors.New("concourse is down")
+		Expect(broker.changePlan(concourseClient, instance, "suspended")).NotTo(Succeed())
+		unchanged, _, _ := store.GetInstance(broker.store, "instance-1")
+		Expect(unchanged.PlanID).To(Equal("small"))
+
+		concourseClient.Err = nil
+		Expect(broker.changePlan(concourseClient, unchanged, "suspended")).To(Succeed())
+		Expect(paused()["deploy"]).To(BeTrue())
+	})
+
+	It("changes nothing when moving between plans that do not suspend", func() {
+		Expect(broker.changePlan(concourseClient, instance, "large")).To(Succeed())
+		Expect(paused()).To(Equal(map[string]bool{"deploy": false, "nightly": true}))
+	})
+})
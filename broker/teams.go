@@ -0,0 +1,49 @@
+package broker
+
+import (
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// checkCreatable refuses teams the broker must never manage and soft deleted teams waiting to be destroyed.
+func (c *concourseBroker) checkCreatable(teamName string) error {
+	if concourse.IsProtected(c.env, teamName) {
+		err := fmt.Errorf("Team %s is protected and cannot be provisioned", teamName)
+		c.logger.Error("provision.protected-team-error", err, lager.Data{"team-name": teamName})
+		return err
+	}
+	deletion, deleted, err := store.GetDeletion(c.store, teamName)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		err := fmt.Errorf("Team %s was deleted and will be destroyed after %s, an operator can undelete it until then",
+			teamName, deletion.DeleteAfter.Format(time.RFC3339))
+		c.logger.Error("provision.soft-deleted-team-error", err, lager.Data{"team-name": teamName})
+		return err
+	}
+	return nil
+}
+
+// checkDeletable refuses to destroy protected teams and teams the broker has no record of creating.
+func (c *concourseBroker) checkDeletable(teamName string) error {
+	if concourse.IsProtected(c.env, teamName) {
+		err := fmt.Errorf("Refusing to destroy team %s: the team is protected", teamName)
+		c.logger.Error("deprovision.protected-team-error", err, lager.Data{"team-name": teamName})
+		return err
+	}
+	_, owned, err := store.GetTeam(c.store, teamName)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		err := fmt.Errorf("Refusing to destroy team %s: it was not created by this broker", teamName)
+		c.logger.Error("deprovision.unmanaged-team-error", err, lager.Data{"team-name": teamName})
+		return err
+	}
+	return nil
+}
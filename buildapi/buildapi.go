@@ -0,0 +1,314 @@
+package buildapi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/gorilla/mux"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// Limits of the builds listed for a job.
+const (
+	defaultLimit = 10
+	maxLimit     = 100
+)
+
+// Credentials are what a binding hands out to use the build API.
+type Credentials struct {
+	APIURL      string `json:"api_url"`
+	APIUsername string `json:"api_username"`
+	APIPassword string `json:"api_password"`
+	// TriggerURL starts a build on a POST without further credentials. When the binding named a job
+	// it only starts that job, otherwise {pipeline} and {job} must be filled in.
+	TriggerURL string `json:"trigger_url"`
+}
+
+// BindParams are the bind parameters of the build API. Pipeline and Job are set together or not at all.
+type BindParams struct {
+	Pipeline string `json:"pipeline"`
+	Job      string `json:"job"`
+}
+
+// API lets apps bound to an instance trigger and read the builds of the jobs of its team, without
+// fly. It authenticates with the credentials of the binding.
+//
+//	GET  /api/v1/pipelines/{pipeline}/jobs/{job}/builds?limit=<n>
+//	POST /api/v1/pipelines/{pipeline}/jobs/{job}/builds
+//	GET  /api/v1/builds/{id}
+//	POST /api/v1/trigger/{binding}/{token}/pipelines/{pipeline}/jobs/{job}
+type API struct {
+	store           store.Store
+	auditLog        audit.Log
+	concourseClient concourse.Client
+	logger          lager.Logger
+	brokerURL       string
+	now             func() time.Time
+}
+
+// New returns the build API. It serves under BROKER_URL.
+func New(s store.Store, auditLog audit.Log, concourseClient concourse.Client, logger lager.Logger, env config.Env) *API {
+	return &API{
+		store:           s,
+		auditLog:        auditLog,
+		concourseClient: concourseClient,
+		logger:          logger.Session("build-api"),
+		brokerURL:       strings.TrimRight(env.BrokerURL, "/"),
+		now:             time.Now,
+	}
+}
+
+// Enabled tells whether BROKER_URL is set, bindings cannot point to the API otherwise.
+func (a *API) Enabled() bool {
+	return a != nil && a.brokerURL != ""
+}
+
+// Issue generates the credentials of a binding of an instance.
+func (a *API) Issue(instance store.Instance, bindingID string, params BindParams) (Credentials, error) {
+	if (params.Pipeline == "") != (params.Job == "") {
+		return Credentials{}, errors.New("The pipeline and job bind parameters must be set together")
+	}
+	password, err := secret()
+	if err != nil {
+		return Credentials{}, err
+	}
+	token, err := secret()
+	if err != nil {
+		return Credentials{}, err
+	}
+	err = store.SaveBinding(a.store, store.Binding{
+		ID:               bindingID,
+		InstanceID:       instance.ID,
+		TeamName:         instance.TeamName,
+		PasswordHash:     hash(password),
+		TriggerTokenHash: hash(token),
+		Pipeline:         params.Pipeline,
+		Job:              params.Job,
+		CreatedAt:        a.now().UTC(),
+	})
+	if err != nil {
+		return Credentials{}, err
+	}
+	pipeline, job := "{pipeline}", "{job}"
+	if params.Pipeline != "" {
+		pipeline, job = params.Pipeline, params.Job
+	}
+	return Credentials{
+		APIURL:      a.brokerURL + "/api/v1",
+		APIUsername: bindingID,
+		APIPassword: password,
+		TriggerURL:  fmt.Sprintf("%s/api/v1/trigger/%s/%s/pipelines/%s/jobs/%s", a.brokerURL, bindingID, token, pipeline, job),
+	}, nil
+}
+
+// Revoke removes the credentials of a binding. It tells whether the binding had any.
+func (a *API) Revoke(bindingID string) (bool, error) {
+	_, found, err := store.GetBinding(a.store, bindingID)
+	if err != nil || !found {
+		return found, err
+	}
+	return true, store.DeleteBinding(a.store, bindingID)
+}
+
+// Handler returns the HTTP handler of the API.
+func (a *API) Handler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/pipelines/{pipeline}/jobs/{job}/builds", a.authenticated(a.jobBuilds)).Methods("GET")
+	router.HandleFunc("/api/v1/pipelines/{pipeline}/jobs/{job}/builds", a.authenticated(a.createJobBuild)).Methods("POST")
+	router.HandleFunc("/api/v1/builds/{id}", a.authenticated(a.build)).Methods("GET")
+	router.HandleFunc("/api/v1/trigger/{binding}/{token}/pipelines/{pipeline}/jobs/{job}", a.trigger).Methods("POST")
+	return router
+}
+
+type handlerFunc func(w http.ResponseWriter, req *http.Request, binding store.Binding)
+
+// authenticated checks the basic auth credentials of a binding.
+func (a *API) authenticated(handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		username, password, ok := req.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="concourse-broker"`)
+			respondError(w, http.StatusUnauthorized, errors.New("Not authorized"))
+			return
+		}
+		binding, ok := a.binding(w, username, password, func(binding store.Binding) string { return binding.PasswordHash })
+		if ok {
+			handler(w, req, binding)
+		}
+	}
+}
+
+// binding looks up a binding and checks a secret against it. It responds itself when the
+// binding is unknown, the secret is wrong or the instance of the binding is gone.
+func (a *API) binding(w http.ResponseWriter, bindingID, secret string, secretHash func(store.Binding) string) (store.Binding, bool) {
+	binding, found, err := store.GetBinding(a.store, bindingID)
+	if err != nil {
+		a.logger.Error("get-binding-error", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return store.Binding{}, false
+	}
+	if !found || subtle.ConstantTimeCompare([]byte(secretHash(binding)), []byte(hash(secret))) != 1 {
+		respondError(w, http.StatusUnauthorized, errors.New("Not authorized"))
+		return store.Binding{}, false
+	}
+	_, found, err = store.GetInstance(a.store, binding.InstanceID)
+	if err != nil {
+		a.logger.Error("get-instance-error", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return store.Binding{}, false
+	}
+	if !found {
+		respondError(w, http.StatusUnauthorized, errors.New("Not authorized"))
+		return store.Binding{}, false
+	}
+	return binding, true
+}
+
+func (a *API) jobBuilds(w http.ResponseWriter, req *http.Request, binding store.Binding) {
+	vars := mux.Vars(req)
+	limit := defaultLimit
+	if raw := req.FormValue("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxLimit {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("Invalid limit %q, expected 1 to %d", raw, maxLimit))
+			return
+		}
+	}
+	builds, found, err := a.concourseClient.RecentJobBuilds(binding.TeamName, vars["pipeline"], vars["job"], limit)
+	if err != nil {
+		respondError(w, http.StatusBadGateway, err)
+		return
+	}
+	if !found {
+		respondError(w, http.StatusNotFound, fmt.Errorf("Job %s of pipeline %s does not exist", vars["job"], vars["pipeline"]))
+		return
+	}
+	respond(w, http.StatusOK, builds)
+}
+
+func (a *API) createJobBuild(w http.ResponseWriter, req *http.Request, binding store.Binding) {
+	a.start(w, req, binding)
+}
+
+func (a *API) trigger(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	binding, ok := a.binding(w, vars["binding"], vars["token"], func(binding store.Binding) string { return binding.TriggerTokenHash })
+	if !ok {
+		return
+	}
+	// a leaked trigger URL must not start other jobs than the one it was issued for
+	if binding.Pipeline != "" && (vars["pipeline"] != binding.Pipeline || vars["job"] != binding.Job) {
+		respondError(w, http.StatusForbidden, fmt.Errorf("The trigger URL of binding %s only starts job %s of pipeline %s",
+			binding.ID, binding.Job, binding.Pipeline))
+		return
+	}
+	a.start(w, req, binding)
+}
+
+// start starts a build of a job of the team of the binding.
+func (a *API) start(w http.ResponseWriter, req *http.Request, binding store.Binding) {
+	vars := mux.Vars(req)
+	pipelineName, jobName := vars["pipeline"], vars["job"]
+	pipelineConfig, _, _, found, err := a.concourseClient.PipelineConfig(binding.TeamName, pipelineName)
+	if err != nil {
+		respondError(w, http.StatusBadGateway, err)
+		return
+	}
+	if _, hasJob := pipelineConfig.Jobs.Lookup(jobName); !found || !hasJob {
+		respondError(w, http.StatusNotFound, fmt.Errorf("Job %s of pipeline %s does not exist", jobName, pipelineName))
+		return
+	}
+	build, err := a.concourseClient.CreateJobBuild(binding.TeamName, pipelineName, jobName)
+	a.record(binding, pipelineName, jobName, build, err)
+	if err != nil {
+		respondError(w, http.StatusBadGateway, err)
+		return
+	}
+	a.logger.Info("created-build", lager.Data{"team-name": binding.TeamName, "pipeline-name": pipelineName,
+		"job-name": jobName, "build-id": build.ID})
+	respond(w, http.StatusCreated, build)
+}
+
+func (a *API) build(w http.ResponseWriter, req *http.Request, binding store.Binding) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Errorf("Invalid build ID %q", mux.Vars(req)["id"]))
+		return
+	}
+	build, found, err := a.concourseClient.Build(id)
+	if err != nil {
+		respondError(w, http.StatusBadGateway, err)
+		return
+	}
+	// the builds of other teams do not exist for a binding
+	if !found || build.TeamName != binding.TeamName {
+		respondError(w, http.StatusNotFound, fmt.Errorf("Build %d does not exist", id))
+		return
+	}
+	respond(w, http.StatusOK, build)
+}
+
+func (a *API) record(binding store.Binding, pipelineName, jobName string, build atc.Build, err error) {
+	entry := audit.Entry{
+		Time:       a.now().UTC(),
+		Operation:  "create-build",
+		InstanceID: binding.InstanceID,
+		TeamName:   binding.TeamName,
+		Caller:     "binding " + binding.ID,
+		Outcome:    audit.Succeeded,
+		Detail:     fmt.Sprintf("%s/%s build %s", pipelineName, jobName, build.Name),
+	}
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+		entry.Detail = pipelineName + "/" + jobName
+	}
+	auditErr := a.auditLog.Record(entry)
+	if auditErr != nil {
+		a.logger.Error("audit-error", auditErr)
+	}
+}
+
+// secret returns a random secret of 32 bytes, hex encoded.
+func secret() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type errorResponse struct {
+	Description string `json:"description"`
+}
+
+func respond(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func respondError(w http.ResponseWriter, status int, err error) {
+	respond(w, status, errorResponse{Description: err.Error()})
+}
@@ -0,0 +1,13 @@
+package buildapi
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestBuildAPI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Build API Suite")
+}
@@ -0,0 +1,141 @@
+package buildapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("API", func() {
+	var (
+		brokerStore     store.Store
+		auditLog        audit.Log
+		concourseClient *fakes.ConcourseClient
+		api             *API
+		credentials     Credentials
+	)
+
+	request := func(method, path, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if password != "" {
+			req.SetBasicAuth("binding-1", password)
+		}
+		recorder := httptest.NewRecorder()
+		api.Handler().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	BeforeEach(func() {
+		brokerStore = store.NewMemoryStore()
+		auditLog = audit.NewStoreLog(brokerStore)
+		concourseClient = fakes.NewConcourseClient()
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "app"}, atc.Config{Jobs: atc.JobConfigs{{Name: "deploy"}}})
+		concourseClient.AddPipeline("other", atc.Pipeline{Name: "app"}, atc.Config{Jobs: atc.JobConfigs{{Name: "deploy"}}})
+		concourseClient.AllBuilds = []atc.Build{
+			{ID: 2, Name: "1", TeamName: "other", PipelineName: "app", JobName: "deploy"},
+			{ID: 1, Name: "1", TeamName: "venture", PipelineName: "app", JobName: "deploy", Status: "succeeded"},
+		}
+		api = New(brokerStore, auditLog, concourseClient, lagertest.NewTestLogger("build-api"), config.Env{BrokerURL: "https://broker.example.com"})
+		instance := store.Instance{ID: "instance-1", TeamName: "venture"}
+		Expect(store.SaveInstance(brokerStore, instance)).To(Succeed())
+		var err error
+		credentials, err = api.Issue(instance, "binding-1", BindParams{Pipeline: "app", Job: "deploy"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("is disabled without BROKER_URL", func() {
+		Expect(New(brokerStore, auditLog, concourseClient, lagertest.NewTestLogger("build-api"), config.Env{}).Enabled()).To(BeFalse())
+		var disabled *API
+		Expect(disabled.Enabled()).To(BeFalse())
+		Expect(api.Enabled()).To(BeTrue())
+	})
+
+	It("only lets the credentials of a binding in", func() {
+		Expect(request("GET", "/api/v1/pipelines/app/jobs/deploy/builds", "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(request("GET", "/api/v1/pipelines/app/jobs/deploy/builds", "wrong").Code).To(Equal(http.StatusUnauthorized))
+		Expect(request("GET", "/api/v1/pipelines/app/jobs/deploy/builds", credentials.APIPassword).Code).To(Equal(http.StatusOK))
+
+		Expect(store.DeleteInstance(brokerStore, "instance-1")).To(Succeed())
+		Expect(request("GET", "/api/v1/pipelines/app/jobs/deploy/builds", credentials.APIPassword).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("lists the builds of a job of the team", func() {
+		response := request("GET", "/api/v1/pipelines/app/jobs/deploy/builds?limit=5", credentials.APIPassword)
+		Expect(response.Code).To(Equal(http.StatusOK))
+		builds := []atc.Build{}
+		Expect(json.Unmarshal(response.Body.Bytes(), &builds)).To(Succeed())
+		Expect(builds).To(HaveLen(1))
+		Expect(builds[0].ID).To(Equal(1))
+
+		Expect(request("GET", "/api/v1/pipelines/app/jobs/missing/builds", credentials.APIPassword).Code).To(Equal(http.StatusNotFound))
+		Expect(request("GET", "/api/v1/pipelines/app/jobs/deploy/builds?limit=1000", credentials.APIPassword).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("starts builds and audits them", func() {
+		response := request("POST", "/api/v1/pipelines/app/jobs/deploy/builds", credentials.APIPassword)
+		Expect(response.Code).To(Equal(http.StatusCreated))
+		var build atc.Build
+		Expect(json.Unmarshal(response.Body.Bytes(), &build)).To(Succeed())
+		Expect(build.TeamName).To(Equal("venture"))
+		Expect(build.JobName).To(Equal("deploy"))
+
+		entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Operation).To(Equal("create-build"))
+		Expect(entries[0].Caller).To(Equal("binding binding-1"))
+
+		Expect(request("POST", "/api/v1/pipelines/app/jobs/missing/builds", credentials.APIPassword).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("starts builds through the trigger URL", func() {
+		Expect(credentials.TriggerURL).To(HavePrefix("https://broker.example.com/api/v1/trigger/binding-1/"))
+		path := strings.TrimPrefix(credentials.TriggerURL, "https://broker.example.com")
+		Expect(request("POST", path, "").Code).To(Equal(http.StatusCreated))
+		Expect(concourseClient.AllBuilds[0].TeamName).To(Equal("venture"))
+
+		wrong := strings.Replace(path, "/trigger/binding-1/", "/trigger/binding-1/0", 1)
+		Expect(request("POST", wrong, "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(request("POST", path, credentials.APIPassword).Code).To(Equal(http.StatusCreated))
+	})
+
+	It("only starts the job of the binding through the trigger URL", func() {
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "infra"}, atc.Config{Jobs: atc.JobConfigs{{Name: "destroy"}}})
+		path := strings.TrimPrefix(credentials.TriggerURL, "https://broker.example.com")
+		other := strings.Replace(path, "/pipelines/app/jobs/deploy", "/pipelines/infra/jobs/destroy", 1)
+		Expect(request("POST", other, "").Code).To(Equal(http.StatusForbidden))
+		Expect(concourseClient.AllBuilds).To(HaveLen(2))
+	})
+
+	It("refuses a binding that names only a pipeline or only a job", func() {
+		_, err := api.Issue(store.Instance{ID: "instance-1", TeamName: "venture"}, "binding-2", BindParams{Pipeline: "app"})
+		Expect(err).To(HaveOccurred())
+		_, found, _ := store.GetBinding(brokerStore, "binding-2")
+		Expect(found).To(BeFalse())
+	})
+
+	It("only shows builds of the team", func() {
+		Expect(request("GET", "/api/v1/builds/1", credentials.APIPassword).Code).To(Equal(http.StatusOK))
+		Expect(request("GET", "/api/v1/builds/2", credentials.APIPassword).Code).To(Equal(http.StatusNotFound))
+		Expect(request("GET", "/api/v1/builds/nope", credentials.APIPassword).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("revokes the credentials", func() {
+		revoked, err := api.Revoke("binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(revoked).To(BeTrue())
+		Expect(request("GET", "/api/v1/builds/1", credentials.APIPassword).Code).To(Equal(http.StatusUnauthorized))
+		revoked, err = api.Revoke("binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(revoked).To(BeFalse())
+	})
+})
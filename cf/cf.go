@@ -20,6 +20,10 @@ type Details struct {
 type Client interface {
 	GetProvisionDetails(spaceGUID string) (Details, error)
 	GetDeprovisionDetails(serviceGUID string) (Details, error)
+	GetUserRoles(userGUID string, details Details) ([]Role, error)
+	InstanceExists(serviceGUID string) (bool, error)
+	GetSpaceDetails(spaceGUID string) (Details, bool, error)
+	GetIsolationSegment(details Details) (string, error)
 }
 
 func NewClient(env config.Env) (Client, error) {
@@ -46,11 +50,7 @@ type cfClient struct {
 
 func (c *cfClient) GetProvisionDetails(spaceGUID string) (Details, error) {
 	requestURI := fmt.Sprintf("/v2/spaces/%s", spaceGUID)
-	orgName, err := c.getOrgName(requestURI)
-	if err != nil {
-		return Details{}, err
-	}
-	return Details{OrgName: orgName}, nil
+	return c.getDetails(requestURI)
 }
 
 func (c *cfClient) GetDeprovisionDetails(serviceGUID string) (Details, error) {
@@ -58,43 +58,44 @@ func (c *cfClient) GetDeprovisionDetails(serviceGUID string) (Details, error) {
 	if err != nil {
 		return Details{}, err
 	}
-	orgName, err := c.getOrgName(serviceInstance.SpaceUrl)
-	if err != nil {
-		return Details{}, err
-	}
-	return Details{OrgName: orgName}, nil
+	return c.getDetails(serviceInstance.SpaceUrl)
 }
 
-func (c *cfClient) getOrgName(requestUrl string) (string, error) {
+func (c *cfClient) getDetails(requestUrl string) (Details, error) {
 	var spaceResp cfclient.SpaceResource
 	r := c.client.NewRequest("GET", requestUrl)
 	resp, err := c.client.DoRequest(r)
 	if err != nil {
-		return "", fmt.Errorf("Error requesting spaces %v", err)
+		return Details{}, fmt.Errorf("Error requesting spaces %v", err)
 	}
 	resBody, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
-		return "", fmt.Errorf("Error reading space request %v", err)
+		return Details{}, fmt.Errorf("Error reading space request %v", err)
 	}
 	err = json.Unmarshal(resBody, &spaceResp)
 	if err != nil {
-		return "", fmt.Errorf("Error unmarshalling space %v", err)
+		return Details{}, fmt.Errorf("Error unmarshalling space %v", err)
 	}
 	var orgResp cfclient.OrgResource
 	r = c.client.NewRequest("GET", spaceResp.Entity.OrgURL)
 	resp, err = c.client.DoRequest(r)
 	if err != nil {
-		return "", fmt.Errorf("Error requesting orgs %v", err)
+		return Details{}, fmt.Errorf("Error requesting orgs %v", err)
 	}
 	resBody, err = ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
-		return "", fmt.Errorf("Error reading org request %v", err)
+		return Details{}, fmt.Errorf("Error reading org request %v", err)
 	}
 	err = json.Unmarshal(resBody, &orgResp)
 	if err != nil {
-		return "", fmt.Errorf("Error unmarshalling org %v", err)
+		return Details{}, fmt.Errorf("Error unmarshalling org %v", err)
 	}
-	return orgResp.Entity.Name, nil
+	return Details{
+		OrgGUID:   orgResp.Meta.Guid,
+		OrgName:   orgResp.Entity.Name,
+		SpaceGUID: spaceResp.Meta.Guid,
+		SpaceName: spaceResp.Entity.Name,
+	}, nil
 }
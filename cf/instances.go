@@ -0,0 +1,38 @@
+package cf
+
+import (
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func (c *cfClient) InstanceExists(serviceGUID string) (bool, error) {
+	var instance cfclient.ServiceInstanceResource
+	err := c.getJSON(fmt.Sprintf("/v2/service_instances/%s", serviceGUID), &instance)
+	if err == errNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *cfClient) GetSpaceDetails(spaceGUID string) (Details, bool, error) {
+	var space cfclient.SpaceResource
+	err := c.getJSON(fmt.Sprintf("/v2/spaces/%s", spaceGUID), &space)
+	if err == errNotFound {
+		return Details{}, false, nil
+	}
+	if err != nil {
+		return Details{}, false, err
+	}
+	var org cfclient.OrgResource
+	err = c.getJSON(space.Entity.OrgURL, &org)
+	if err != nil {
+		return Details{}, false, err
+	}
+	return Details{
+		OrgGUID:   org.Meta.Guid,
+		OrgName:   org.Entity.Name,
+		SpaceGUID: space.Meta.Guid,
+		SpaceName: space.Entity.Name,
+	}, true, nil
+}
@@ -0,0 +1,60 @@
+package cf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// errNotFound is returned by getJSON when the CF API answers 404.
+var errNotFound = errors.New("not found")
+
+type resourcePage struct {
+	NextURL   string            `json:"next_url"`
+	Resources []json.RawMessage `json:"resources"`
+}
+
+// getAll calls each for every resource of a paginated v2 list.
+func (c *cfClient) getAll(requestURL string, each func(resource json.RawMessage) error) error {
+	for requestURL != "" {
+		var page resourcePage
+		err := c.getJSON(requestURL, &page)
+		if err != nil {
+			return err
+		}
+		for _, resource := range page.Resources {
+			err = each(resource)
+			if err != nil {
+				return fmt.Errorf("Error unmarshalling %s %v", requestURL, err)
+			}
+		}
+		requestURL = page.NextURL
+	}
+	return nil
+}
+
+func (c *cfClient) getJSON(requestURL string, out interface{}) error {
+	r := c.client.NewRequest("GET", requestURL)
+	resp, err := c.client.DoRequest(r)
+	if err != nil {
+		return fmt.Errorf("Error requesting %s %v", requestURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Error requesting %s: %s", requestURL, resp.Status)
+	}
+	resBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Error reading %s %v", requestURL, err)
+	}
+	err = json.Unmarshal(resBody, out)
+	if err != nil {
+		return fmt.Errorf("Error unmarshalling %s %v", requestURL, err)
+	}
+	return nil
+}
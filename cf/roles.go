@@ -0,0 +1,61 @@
+package cf
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// Role is a CF role a user can hold in a space or org.
+type Role string
+
+const (
+	SpaceManager   Role = "space_manager"
+	SpaceDeveloper Role = "space_developer"
+	SpaceAuditor   Role = "space_auditor"
+	OrgManager     Role = "org_manager"
+)
+
+// Roles are all the roles the broker knows how to look up.
+var Roles = []Role{SpaceManager, SpaceDeveloper, SpaceAuditor, OrgManager}
+
+// roleEndpoints are the /v2/users/:guid/<endpoint> lists that tell whether a user holds a role.
+var roleEndpoints = map[Role]string{
+	SpaceManager:   "managed_spaces",
+	SpaceDeveloper: "spaces",
+	SpaceAuditor:   "audited_spaces",
+	OrgManager:     "managed_organizations",
+}
+
+func (c *cfClient) GetUserRoles(userGUID string, details Details) ([]Role, error) {
+	roles := []Role{}
+	for _, role := range Roles {
+		target := details.SpaceGUID
+		if role == OrgManager {
+			target = details.OrgGUID
+		}
+		if target == "" {
+			continue
+		}
+		requestURL := fmt.Sprintf("/v2/users/%s/%s", userGUID, roleEndpoints[role])
+		found := false
+		err := c.getAll(requestURL, func(resource json.RawMessage) error {
+			var meta struct {
+				Meta cfclient.Meta `json:"metadata"`
+			}
+			err := json.Unmarshal(resource, &meta)
+			if meta.Meta.Guid == target {
+				found = true
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
@@ -0,0 +1,33 @@
+package cf
+
+import "fmt"
+
+type relationship struct {
+	Data *struct {
+		GUID string `json:"guid"`
+	} `json:"data"`
+}
+
+// GetIsolationSegment returns the name of the isolation segment the apps of a space run in: the
+// one of the space, or else the default of its org. It is empty for the shared segment.
+func (c *cfClient) GetIsolationSegment(details Details) (string, error) {
+	var segment relationship
+	err := c.getJSON(fmt.Sprintf("/v3/spaces/%s/relationships/isolation_segment", details.SpaceGUID), &segment)
+	if err != nil {
+		return "", err
+	}
+	if segment.Data == nil {
+		err = c.getJSON(fmt.Sprintf("/v3/organizations/%s/relationships/default_isolation_segment", details.OrgGUID), &segment)
+		if err != nil {
+			return "", err
+		}
+	}
+	if segment.Data == nil {
+		return "", nil
+	}
+	var isolationSegment struct {
+		Name string `json:"name"`
+	}
+	err = c.getJSON(fmt.Sprintf("/v3/isolation_segments/%s", segment.Data.GUID), &isolationSegment)
+	return isolationSegment.Name, err
+}
@@ -0,0 +1,109 @@
+// Command concourse-broker-admin talks to the admin API of a running broker.
+//
+//	concourse-broker-admin [-url URL -username USER -password PASSWORD] archives [-team TEAM] [-instance ID]
+//	concourse-broker-admin [-url URL -username USER -password PASSWORD] restore [-instance ID] ARCHIVE_ID
+//
+// The flags default to the BROKER_URL, BROKER_USERNAME and BROKER_PASSWORD environment variables.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type client struct {
+	url      string
+	username string
+	password string
+}
+
+func (c client) do(method, path string, body interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.url, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var response interface{}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	out, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return nil
+}
+
+func archives(c client, args []string) error {
+	flags := flag.NewFlagSet("archives", flag.ExitOnError)
+	team := flags.String("team", "", "only archives of this team")
+	instance := flags.String("instance", "", "only archives of this service instance")
+	flags.Parse(args)
+	query := url.Values{}
+	if *team != "" {
+		query.Set("team", *team)
+	}
+	if *instance != "" {
+		query.Set("instance", *instance)
+	}
+	return c.do("GET", "/admin/archives?"+query.Encode(), nil)
+}
+
+func restore(c client, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	instance := flags.String("instance", "", "attach the restored team to this service instance")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("restore needs exactly one archive ID")
+	}
+	path := fmt.Sprintf("/admin/archives/%s/restore", url.QueryEscape(flags.Arg(0)))
+	return c.do("POST", path, map[string]string{"instance_id": *instance})
+}
+
+func main() {
+	c := client{}
+	flag.StringVar(&c.url, "url", os.Getenv("BROKER_URL"), "the URL of the broker")
+	flag.StringVar(&c.username, "username", os.Getenv("BROKER_USERNAME"), "the broker username")
+	flag.StringVar(&c.password, "password", os.Getenv("BROKER_PASSWORD"), "the broker password")
+	flag.Parse()
+	commands := map[string]func(client, []string) error{
+		"archives": archives,
+		"restore":  restore,
+	}
+	if flag.NArg() == 0 || commands[flag.Arg(0)] == nil {
+		log.Fatalln("Usage: concourse-broker-admin [flags] archives|restore [args]")
+	}
+	err := commands[flag.Arg(0)](c, flag.Args()[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -6,24 +6,78 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
 
+	"github.com/gorilla/mux"
 	"github.com/pivotal-cf/brokerapi"
+	"github.com/vchrisr/concourse-broker/admin"
+	"github.com/vchrisr/concourse-broker/admission"
+	"github.com/vchrisr/concourse-broker/archive"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/authz"
 	"github.com/vchrisr/concourse-broker/broker"
+	"github.com/vchrisr/concourse-broker/buildapi"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/concourse"
 	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/exposure"
+	"github.com/vchrisr/concourse-broker/footprint"
+	"github.com/vchrisr/concourse-broker/gc"
+	"github.com/vchrisr/concourse-broker/idle"
+	"github.com/vchrisr/concourse-broker/jobs"
 	"github.com/vchrisr/concourse-broker/logger"
+	"github.com/vchrisr/concourse-broker/notify"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/policy"
+	"github.com/vchrisr/concourse-broker/quota"
+	"github.com/vchrisr/concourse-broker/reconcile"
+	"github.com/vchrisr/concourse-broker/restore"
+	"github.com/vchrisr/concourse-broker/seed"
+	"github.com/vchrisr/concourse-broker/softdelete"
+	"github.com/vchrisr/concourse-broker/store"
+	"github.com/vchrisr/concourse-broker/usage"
+	"github.com/vchrisr/concourse-broker/workerkeys"
+	"github.com/vchrisr/concourse-broker/workers"
 )
 
+// loadServices reads the services of the catalog. The catalog is a list of services, or a single
+// service as it used to be.
 func loadServices() ([]brokerapi.Service, error) {
-	var service brokerapi.Service
 	buf, err := ioutil.ReadFile("./catalog.json")
 	if err != nil {
 		return []brokerapi.Service{}, err
 	}
-	err = json.Unmarshal(buf, &service)
+	services := []brokerapi.Service{}
+	if strings.HasPrefix(strings.TrimSpace(string(buf)), "[") {
+		err = json.Unmarshal(buf, &services)
+	} else {
+		var service brokerapi.Service
+		err = json.Unmarshal(buf, &service)
+		services = append(services, service)
+	}
 	if err != nil {
 		return []brokerapi.Service{}, err
 	}
-	return []brokerapi.Service{service}, nil
+	ids := map[string]bool{}
+	for _, service := range services {
+		if ids[service.ID] {
+			return []brokerapi.Service{}, fmt.Errorf("Service ID %s is in the catalog twice", service.ID)
+		}
+		ids[service.ID] = true
+	}
+	return services, nil
+}
+
+func newAuditLog(env config.Env, s store.Store) (audit.Log, error) {
+	switch env.AuditBackend {
+	case "file":
+		return audit.NewFileLog(filepath.Join(env.DataDir, "audit.jsonl")), nil
+	case "store":
+		return audit.NewStoreLog(s), nil
+	}
+	return nil, fmt.Errorf("Unknown audit backend %s. Available audit backends are: file and store", env.AuditBackend)
 }
 
 func main() {
@@ -43,8 +97,126 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
-	serviceBroker := broker.New(services, logger, env)
+	brokerStore, err := store.NewFileStore(filepath.Join(env.DataDir, "store.json"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	auditLog, err := newAuditLog(env, brokerStore)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	authzPolicy, err := authz.NewPolicy(env)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	rules, err := admission.NewRules(env)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	brokerPlans, err := plans.Load(env.PlansFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	pipelineRules, err := policy.NewRules(env)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	teamsLock := &sync.Mutex{}
+	archiver := archive.NewArchiver(archive.NewDir(filepath.Join(env.DataDir, "archives")), brokerStore)
+	newCFClient := func() (cf.Client, error) { return cf.NewClient(env) }
+	concourseClient := concourse.NewClient(env, logger)
+	notifier := notify.New(env, logger)
+	deleter := softdelete.New(brokerStore, teamsLock, auditLog, notifier, concourseClient, logger, env)
+	workerChecker := workers.New(env, concourseClient, logger)
+	workerKeys := workerkeys.New(brokerStore, auditLog, concourseClient, logger, env)
+	buildAPI := buildapi.New(brokerStore, auditLog, concourseClient, logger, env)
+	serviceBroker := broker.New(services, logger, env, broker.Dependencies{
+		Store:      brokerStore,
+		AuditLog:   auditLog,
+		Policy:     authzPolicy,
+		Rules:      rules,
+		Plans:      brokerPlans,
+		Seeder:     seed.New(env, pipelineRules),
+		Archiver:   archiver,
+		Deleter:    deleter,
+		Workers:    workerChecker,
+		WorkerKeys: workerKeys,
+		BuildAPI:   buildAPI,
+		TeamsLock:  teamsLock,
+	})
+	reconciler := reconcile.New(brokerStore, teamsLock, auditLog, newCFClient, concourseClient, logger, env.ReconcileRepair)
+	jobs.Every(env.ReconcileInterval, logger.Session("reconcile-job"), func() error {
+		_, err := reconciler.Run()
+		return err
+	})
+	collector := gc.New(brokerStore, teamsLock, auditLog, notifier, archiver, newCFClient, concourseClient, logger, env)
+	jobs.Every(env.GCInterval, logger.Session("gc-job"), func() error {
+		_, err := collector.Run()
+		return err
+	})
+	syncer := seed.NewSyncer(brokerStore, teamsLock, concourseClient, pipelineRules, logger)
+	jobs.Every(env.TemplateSyncInterval, logger.Session("template-sync-job"), syncer.Run)
+	// runs even when soft deletion is turned off, so teams deleted before that are still destroyed
+	jobs.Every(env.SoftDeleteInterval, logger.Session("soft-delete-job"), deleter.Run)
+	enforcer := quota.New(brokerStore, teamsLock, auditLog, notifier, brokerPlans, concourseClient, logger, env)
+	jobs.Every(env.QuotaInterval, logger.Session("quota-job"), func() error {
+		_, err := enforcer.Run()
+		return err
+	})
+	scanner := policy.NewScanner(brokerStore, teamsLock, auditLog, notifier, pipelineRules, env.PolicyAutoPause, concourseClient, logger)
+	jobs.Every(env.PolicyScanInterval, logger.Session("policy-scan-job"), func() error {
+		_, err := scanner.Run()
+		return err
+	})
+	exposureEnforcer := exposure.New(brokerStore, teamsLock, auditLog, notifier, brokerPlans, concourseClient, logger)
+	jobs.Every(env.ExposureInterval, logger.Session("exposure-job"), func() error {
+		_, err := exposureEnforcer.Run()
+		return err
+	})
+	usageCollector := usage.New(brokerStore, teamsLock, concourseClient, logger)
+	jobs.Every(env.UsageInterval, logger.Session("usage-job"), func() error {
+		_, err := usageCollector.Run()
+		return err
+	})
+	detector, err := idle.New(brokerStore, teamsLock, auditLog, notifier, concourseClient, logger, env)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	jobs.Every(env.IdleInterval, logger.Session("idle-job"), func() error {
+		_, err := detector.Run()
+		return err
+	})
+	footprintEnforcer := footprint.New(brokerStore, teamsLock, auditLog, notifier, brokerPlans, concourseClient, logger, env)
+	jobs.Every(env.FootprintInterval, logger.Session("footprint-job"), func() error {
+		_, err := footprintEnforcer.Run()
+		return err
+	})
+	jobs.Every(env.WorkersCheckInterval, logger.Session("workers-job"), func() error {
+		_, err := workerChecker.Run()
+		return err
+	})
+	jobs.Every(env.WorkerPruneInterval, logger.Session("worker-prune-job"), func() error {
+		_, err := workerKeys.Run()
+		return err
+	})
 	brokerAPI := brokerapi.New(serviceBroker, logger, credentials)
-	http.Handle("/", brokerAPI)
+	adminRouter := mux.NewRouter()
+	admin.AttachAuditRoutes(adminRouter, auditLog, logger)
+	admin.AttachReconcileRoutes(adminRouter, reconciler, logger)
+	admin.AttachGCRoutes(adminRouter, collector, logger)
+	restorer := restore.New(brokerStore, teamsLock, auditLog, archiver, newCFClient, concourseClient, logger, env)
+	admin.AttachArchiveRoutes(adminRouter, brokerStore, archiver, restorer, logger)
+	admin.AttachDeletionRoutes(adminRouter, brokerStore, deleter, logger)
+	admin.AttachQuotaRoutes(adminRouter, enforcer, logger)
+	admin.AttachPolicyRoutes(adminRouter, scanner, logger)
+	admin.AttachExposureRoutes(adminRouter, exposureEnforcer, logger)
+	admin.AttachUsageRoutes(adminRouter, brokerStore, usageCollector, logger)
+	admin.AttachIdleRoutes(adminRouter, detector, logger)
+	admin.AttachFootprintRoutes(adminRouter, brokerStore, footprintEnforcer, logger)
+	admin.AttachWorkerRoutes(adminRouter, workerChecker, logger)
+	admin.AttachWorkerKeyRoutes(adminRouter, workerKeys, logger)
+	http.Handle("/admin/", admin.New(adminRouter, credentials))
+	http.Handle("/api/v1/", buildAPI.Handler())
+	http.Handle("/", broker.WithRequestInfo(brokerAPI))
 	http.ListenAndServe(fmt.Sprintf(":%s", env.Port), nil)
 }
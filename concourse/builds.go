@@ -0,0 +1,127 @@
+package concourse
+
+import (
+	"strconv"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/concourse/go-concourse/concourse"
+)
+
+// buildPageSize is how many builds are read per request.
+const buildPageSize = 100
+
+// JobBuilds returns the builds of a job with an ID above after, newest first. A job or pipeline
+// that does not exist has no builds.
+func (c *concourseClient) JobBuilds(teamName, pipelineName, jobName string, after int) ([]atc.Build, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("job-builds.auth-client-error", err)
+		return nil, err
+	}
+	team := client.Team(teamName)
+	return pageBuilds(after, func(page concourse.Page) ([]atc.Build, concourse.Pagination, error) {
+		builds, pagination, found, err := team.JobBuilds(pipelineName, jobName, page)
+		if err != nil {
+			c.logger.Error("job-builds.unknown-list-error", err, lager.Data{
+				"team-name":     teamName,
+				"pipeline-name": pipelineName,
+				"job-name":      jobName,
+			})
+		}
+		if !found {
+			return nil, concourse.Pagination{}, err
+		}
+		return builds, pagination, err
+	})
+}
+
+// Builds returns the builds of every team with an ID above after, newest first.
+func (c *concourseClient) Builds(after int) ([]atc.Build, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("builds.auth-client-error", err)
+		return nil, err
+	}
+	return pageBuilds(after, func(page concourse.Page) ([]atc.Build, concourse.Pagination, error) {
+		builds, pagination, err := client.Builds(page)
+		if err != nil {
+			c.logger.Error("builds.unknown-list-error", err)
+		}
+		return builds, pagination, err
+	})
+}
+
+// pageBuilds follows the pages of a build list from the newest build back to the first build with
+// an ID of after or below.
+func pageBuilds(after int, list func(concourse.Page) ([]atc.Build, concourse.Pagination, error)) ([]atc.Build, error) {
+	result := []atc.Build{}
+	page := concourse.Page{Limit: buildPageSize}
+	for {
+		builds, pagination, err := list(page)
+		if err != nil {
+			return nil, err
+		}
+		for _, build := range builds {
+			if build.ID <= after {
+				return result, nil
+			}
+			result = append(result, build)
+		}
+		if pagination.Next == nil {
+			return result, nil
+		}
+		page = *pagination.Next
+		page.Limit = buildPageSize
+	}
+}
+
+// RecentJobBuilds returns up to limit of the newest builds of a job. It tells whether the job exists.
+func (c *concourseClient) RecentJobBuilds(teamName, pipelineName, jobName string, limit int) ([]atc.Build, bool, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("recent-job-builds.auth-client-error", err)
+		return nil, false, err
+	}
+	builds, _, found, err := client.Team(teamName).JobBuilds(pipelineName, jobName, concourse.Page{Limit: limit})
+	if err != nil {
+		c.logger.Error("recent-job-builds.unknown-list-error", err, lager.Data{
+			"team-name":     teamName,
+			"pipeline-name": pipelineName,
+			"job-name":      jobName,
+		})
+	}
+	return builds, found, err
+}
+
+// CreateJobBuild starts a build of a job.
+func (c *concourseClient) CreateJobBuild(teamName, pipelineName, jobName string) (atc.Build, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("create-job-build.auth-client-error", err)
+		return atc.Build{}, err
+	}
+	build, err := client.Team(teamName).CreateJobBuild(pipelineName, jobName)
+	if err != nil {
+		c.logger.Error("create-job-build.unknown-create-error", err, lager.Data{
+			"team-name":     teamName,
+			"pipeline-name": pipelineName,
+			"job-name":      jobName,
+		})
+	}
+	return build, err
+}
+
+// Build returns a build of any team.
+func (c *concourseClient) Build(buildID int) (atc.Build, bool, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("build.auth-client-error", err)
+		return atc.Build{}, false, err
+	}
+	build, found, err := client.Build(strconv.Itoa(buildID))
+	if err != nil {
+		c.logger.Error("build.unknown-get-error", err, lager.Data{"build-id": buildID})
+	}
+	return build, found, err
+}
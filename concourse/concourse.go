@@ -17,6 +17,31 @@ const adminTeam = "main"
 type Client interface {
 	CreateTeam(details cf.Details) error
 	DeleteTeam(details cf.Details) error
+	DestroyTeam(teamName string) error
+	TeamName(details cf.Details) string
+	TeamConfig(spaceGUIDs []string) atc.Team
+	UpdateTeam(teamName string, team atc.Team) error
+	SetTeam(teamName string, team atc.Team) error
+	ListTeams() ([]atc.Team, error)
+	ListAuthMethods(teamName string) ([]atc.AuthMethod, error)
+	ListPipelines(teamName string) ([]atc.Pipeline, error)
+	PipelineConfig(teamName, pipelineName string) (atc.Config, atc.RawConfig, string, bool, error)
+	SetPipelineConfig(teamName, pipelineName, version string, config atc.Config) (bool, []string, error)
+	UnpausePipeline(teamName, pipelineName string) error
+	PausePipeline(teamName, pipelineName string) error
+	ExposePipeline(teamName, pipelineName string) error
+	HidePipeline(teamName, pipelineName string) error
+	DeletePipeline(teamName, pipelineName string) error
+	JobBuilds(teamName, pipelineName, jobName string, after int) ([]atc.Build, error)
+	Builds(after int) ([]atc.Build, error)
+	RecentJobBuilds(teamName, pipelineName, jobName string, limit int) ([]atc.Build, bool, error)
+	CreateJobBuild(teamName, pipelineName, jobName string) (atc.Build, error)
+	Build(buildID int) (atc.Build, bool, error)
+	ListContainers(teamName string) ([]atc.Container, error)
+	ListVolumes(teamName string) ([]atc.Volume, error)
+	ListWorkers() ([]atc.Worker, error)
+	ListTeamWorkers(teamName string) ([]atc.Worker, error)
+	PruneWorker(workerName string) error
 }
 
 // NewClient returns a client that can be used to interface with a deployed Concourse CI instance.
@@ -45,23 +70,29 @@ func (c *concourseClient) getAuthClient(concourseURL string) (concourse.Client,
 	return concourse.NewClient(concourseURL, httpClient), nil
 }
 
-func (c *concourseClient) getTeamName(details cf.Details) string {
+// TeamName returns the name of the team that belongs to the given CF details.
+func (c *concourseClient) TeamName(details cf.Details) string {
 	return details.OrgName
 }
 
-func (c *concourseClient) CreateTeam(details cf.Details) error {
-	teamName := c.getTeamName(details)
-	team := atc.Team{
+// TeamConfig returns the auth config the broker sets on a team that grants the given CF spaces access.
+func (c *concourseClient) TeamConfig(spaceGUIDs []string) atc.Team {
+	return atc.Team{
 		UAAAuth: &atc.UAAAuth{
 			ClientID:     c.env.ClientID,
 			ClientSecret: c.env.ClientSecret,
 			AuthURL:      c.env.AuthURL,
 			TokenURL:     c.env.TokenURL,
-			CFSpaces:     []string{details.SpaceGUID},
+			CFSpaces:     spaceGUIDs,
 			CFCACert:     "",
 			CFURL:        c.env.CFURL,
 		},
 	}
+}
+
+func (c *concourseClient) CreateTeam(details cf.Details) error {
+	teamName := c.TeamName(details)
+	team := c.TeamConfig([]string{details.SpaceGUID})
 	client, err := c.getAuthClient(c.env.ConcourseURL)
 	if err != nil {
 		c.logger.Error("create-team.auth-client-error", err)
@@ -96,14 +127,48 @@ func (c *concourseClient) CreateTeam(details cf.Details) error {
 	return nil
 }
 
+// UpdateTeam replaces the config of an existing team.
+func (c *concourseClient) UpdateTeam(teamName string, team atc.Team) error {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("update-team.auth-client-error", err)
+		return err
+	}
+	_, created, _, err := client.Team(teamName).CreateOrUpdate(team)
+	if err != nil {
+		c.logger.Error("update-team.unknown-update-error", err,
+			lager.Data{
+				"team-name": teamName,
+			})
+		return err
+	}
+	if created {
+		err := fmt.Errorf("Team %s did not exist and was created", teamName)
+		c.logger.Error("update-team.missing-team-error", err,
+			lager.Data{
+				"team-name": teamName,
+			})
+		return err
+	}
+	return nil
+}
+
 func (c *concourseClient) DeleteTeam(details cf.Details) error {
-	teamName := c.getTeamName(details)
+	return c.DestroyTeam(c.TeamName(details))
+}
+
+func (c *concourseClient) DestroyTeam(teamName string) error {
+	if teamName == adminTeam {
+		err := fmt.Errorf("Refusing to destroy the %s team", adminTeam)
+		c.logger.Error("delete-team.protected-team-error", err)
+		return err
+	}
 	client, err := c.getAuthClient(c.env.ConcourseURL)
 	if err != nil {
 		c.logger.Error("delete-team.auth-client-error", err)
 		return err
 	}
-	err = client.Team(details.OrgName).DestroyTeam(teamName)
+	err = client.Team(teamName).DestroyTeam(teamName)
 	if err != nil {
 		c.logger.Error("delete-team.unknown-delete-error", err,
 			lager.Data{
@@ -159,6 +159,72 @@ var _ = Describe("Concourse", func() {
 		})
 
 	})
+	Describe("IsProtected", func() {
+		It("always protects the main team", func() {
+			Expect(IsProtected(config.Env{}, "main")).To(BeTrue())
+		})
+		It("protects the configured teams", func() {
+			env := config.Env{ProtectedTeams: []string{"ops", " release"}}
+			Expect(IsProtected(env, "release")).To(BeTrue())
+			Expect(IsProtected(env, "venture")).To(BeFalse())
+		})
+	})
+	Describe("UpdateTeam", func() {
+		var expectedURL = "/api/v1/teams/team venture"
+		var expectedAuthToken = atc.AuthToken{
+			Type:  "Bearer",
+			Value: "gobbeldigook",
+		}
+		var desiredTeam = atc.Team{
+			UAAAuth: &atc.UAAAuth{
+				CFSpaces: []string{"space-a", "space-b"},
+			},
+		}
+
+		BeforeEach(func() {
+			atcServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v1/teams/main/auth/token"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, expectedAuthToken),
+				),
+			)
+		})
+		Context("when the team exists", func() {
+			BeforeEach(func() {
+				atcServer.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("PUT", expectedURL),
+						ghttp.VerifyJSONRepresenting(desiredTeam),
+						ghttp.RespondWithJSONEncoded(http.StatusOK, atc.Team{ID: 1, Name: "team venture"}),
+					),
+				)
+			})
+			It("returns no error", func() {
+				client := NewClient(env, logger)
+				err := client.UpdateTeam("team venture", client.TeamConfig([]string{"space-a", "space-b"}))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(logger.Logs()).To(HaveLen(0))
+			})
+		})
+		Context("when the team had disappeared", func() {
+			BeforeEach(func() {
+				atcServer.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("PUT", expectedURL),
+						ghttp.RespondWithJSONEncoded(http.StatusCreated, atc.Team{ID: 1, Name: "team venture"}),
+					),
+				)
+			})
+			It("returns an error", func() {
+				client := NewClient(env, logger)
+				err := client.UpdateTeam("team venture", desiredTeam)
+				Expect(err).To(MatchError("Team team venture did not exist and was created"))
+				logs := logger.Logs()
+				Expect(logs).To(HaveLen(1))
+				Expect(logs[0].Message).To(ContainSubstring("concourse-client.update-team.missing-team-error"))
+			})
+		})
+	})
 	Describe("DeleteTeam", func() {
 		var expectedURL = "/api/v1/teams/team venture"
 		var expectedAuthToken = atc.AuthToken{
@@ -215,6 +281,18 @@ var _ = Describe("Concourse", func() {
 			})
 		})
 
+		Context("when I try to delete the main team", func() {
+			It("refuses without calling Concourse", func() {
+				client := NewClient(env, logger)
+				err := client.DeleteTeam(cf.Details{OrgName: "main"})
+				Expect(err).To(MatchError("Refusing to destroy the main team"))
+				Expect(atcServer.ReceivedRequests()).To(BeEmpty())
+				logs := logger.Logs()
+				Expect(logs).To(HaveLen(1))
+				Expect(logs[0].Message).To(ContainSubstring("concourse-client.delete-team.protected-team-error"))
+			})
+		})
+
 		Context("when I try to delete a team but I can't auth as an admin", func() {
 			BeforeEach(func() {
 				atcServer.AppendHandlers(
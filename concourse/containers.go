@@ -0,0 +1,51 @@
+package concourse
+
+import (
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/concourse/go-concourse/concourse"
+)
+
+// ListContainers returns the containers of a team.
+func (c *concourseClient) ListContainers(teamName string) ([]atc.Container, error) {
+	client, err := c.getTeamClient(teamName)
+	if err != nil {
+		c.logger.Error("list-containers.auth-client-error", err, lager.Data{"team-name": teamName})
+		return nil, err
+	}
+	containers, err := client.ListContainers(map[string]string{})
+	if err != nil {
+		c.logger.Error("list-containers.unknown-list-error", err, lager.Data{"team-name": teamName})
+		return nil, err
+	}
+	return containers, nil
+}
+
+// ListVolumes returns the volumes of a team.
+func (c *concourseClient) ListVolumes(teamName string) ([]atc.Volume, error) {
+	client, err := c.getTeamClient(teamName)
+	if err != nil {
+		c.logger.Error("list-volumes.auth-client-error", err, lager.Data{"team-name": teamName})
+		return nil, err
+	}
+	volumes, err := client.ListVolumes()
+	if err != nil {
+		c.logger.Error("list-volumes.unknown-list-error", err, lager.Data{"team-name": teamName})
+		return nil, err
+	}
+	return volumes, nil
+}
+
+// getTeamClient returns a client that acts as the team. Concourse lists containers and volumes
+// for the team of the token only, and hands a token of any team to the holder of a valid token.
+func (c *concourseClient) getTeamClient(teamName string) (concourse.Client, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		return nil, err
+	}
+	token, err := client.Team(teamName).AuthToken()
+	if err != nil {
+		return nil, err
+	}
+	return concourse.NewClient(c.env.ConcourseURL, newOAuthClient(token.Type, token.Value)), nil
+}
@@ -0,0 +1,111 @@
+package concourse
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/concourse/go-concourse/concourse"
+)
+
+func (c *concourseClient) ListPipelines(teamName string) ([]atc.Pipeline, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("list-pipelines.auth-client-error", err)
+		return nil, err
+	}
+	pipelines, err := client.Team(teamName).ListPipelines()
+	if err != nil {
+		c.logger.Error("list-pipelines.unknown-list-error", err, lager.Data{"team-name": teamName})
+		return nil, err
+	}
+	return pipelines, nil
+}
+
+func (c *concourseClient) PipelineConfig(teamName, pipelineName string) (atc.Config, atc.RawConfig, string, bool, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("pipeline-config.auth-client-error", err)
+		return atc.Config{}, "", "", false, err
+	}
+	config, rawConfig, version, found, err := client.Team(teamName).PipelineConfig(pipelineName)
+	if err != nil {
+		c.logger.Error("pipeline-config.unknown-get-error", err, lager.Data{
+			"team-name":     teamName,
+			"pipeline-name": pipelineName,
+		})
+	}
+	return config, rawConfig, version, found, err
+}
+
+// SetPipelineConfig creates or updates a pipeline. version must be the config version of the
+// pipeline being updated, or empty for a new pipeline. It returns the warnings Concourse gave.
+func (c *concourseClient) SetPipelineConfig(teamName, pipelineName, version string, config atc.Config) (bool, []string, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("set-pipeline-config.auth-client-error", err)
+		return false, nil, err
+	}
+	created, _, configWarnings, err := client.Team(teamName).CreateOrUpdatePipelineConfig(pipelineName, version, config)
+	if err != nil {
+		c.logger.Error("set-pipeline-config.unknown-set-error", err, lager.Data{
+			"team-name":     teamName,
+			"pipeline-name": pipelineName,
+		})
+		return false, nil, err
+	}
+	warnings := []string{}
+	for _, warning := range configWarnings {
+		warnings = append(warnings, fmt.Sprintf("%s: %s", warning.Type, warning.Message))
+	}
+	return created, warnings, nil
+}
+
+func (c *concourseClient) UnpausePipeline(teamName, pipelineName string) error {
+	return c.managePipeline("unpause-pipeline", teamName, pipelineName, func(team concourse.Team) (bool, error) {
+		return team.UnpausePipeline(pipelineName)
+	})
+}
+
+func (c *concourseClient) PausePipeline(teamName, pipelineName string) error {
+	return c.managePipeline("pause-pipeline", teamName, pipelineName, func(team concourse.Team) (bool, error) {
+		return team.PausePipeline(pipelineName)
+	})
+}
+
+func (c *concourseClient) ExposePipeline(teamName, pipelineName string) error {
+	return c.managePipeline("expose-pipeline", teamName, pipelineName, func(team concourse.Team) (bool, error) {
+		return team.ExposePipeline(pipelineName)
+	})
+}
+
+func (c *concourseClient) HidePipeline(teamName, pipelineName string) error {
+	return c.managePipeline("hide-pipeline", teamName, pipelineName, func(team concourse.Team) (bool, error) {
+		return team.HidePipeline(pipelineName)
+	})
+}
+
+func (c *concourseClient) DeletePipeline(teamName, pipelineName string) error {
+	return c.managePipeline("delete-pipeline", teamName, pipelineName, func(team concourse.Team) (bool, error) {
+		return team.DeletePipeline(pipelineName)
+	})
+}
+
+func (c *concourseClient) managePipeline(action, teamName, pipelineName string, manage func(concourse.Team) (bool, error)) error {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error(action+".auth-client-error", err)
+		return err
+	}
+	found, err := manage(client.Team(teamName))
+	if err == nil && !found {
+		err = fmt.Errorf("Pipeline %s of team %s does not exist", pipelineName, teamName)
+	}
+	if err != nil {
+		c.logger.Error(action+".unknown-error", err, lager.Data{
+			"team-name":     teamName,
+			"pipeline-name": pipelineName,
+		})
+	}
+	return err
+}
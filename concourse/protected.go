@@ -0,0 +1,21 @@
+package concourse
+
+import (
+	"strings"
+
+	"github.com/vchrisr/concourse-broker/config"
+)
+
+// IsProtected reports whether the broker must never create or destroy the team.
+// The admin team is always protected, operators can add more with PROTECTED_TEAMS.
+func IsProtected(env config.Env, teamName string) bool {
+	if teamName == adminTeam {
+		return true
+	}
+	for _, name := range env.ProtectedTeams {
+		if strings.TrimSpace(name) == teamName {
+			return true
+		}
+	}
+	return false
+}
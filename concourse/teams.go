@@ -0,0 +1,66 @@
+package concourse
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+)
+
+// ListTeams returns every team in Concourse, including the ones the broker did not create.
+func (c *concourseClient) ListTeams() ([]atc.Team, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("list-teams.auth-client-error", err)
+		return nil, err
+	}
+	// go-concourse has no call for this route yet
+	resp, err := client.HTTPClient().Get(client.URL() + "/api/v1/teams")
+	if err != nil {
+		c.logger.Error("list-teams.unknown-list-error", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err := fmt.Errorf("Error listing teams: %s", resp.Status)
+		c.logger.Error("list-teams.unknown-list-error", err)
+		return nil, err
+	}
+	var teams []atc.Team
+	err = json.NewDecoder(resp.Body).Decode(&teams)
+	if err != nil {
+		c.logger.Error("list-teams.unmarshal-error", err)
+		return nil, err
+	}
+	return teams, nil
+}
+
+func (c *concourseClient) ListAuthMethods(teamName string) ([]atc.AuthMethod, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("list-auth-methods.auth-client-error", err)
+		return nil, err
+	}
+	authMethods, err := client.Team(teamName).ListAuthMethods()
+	if err != nil {
+		c.logger.Error("list-auth-methods.unknown-list-error", err, lager.Data{"team-name": teamName})
+		return nil, err
+	}
+	return authMethods, nil
+}
+
+// SetTeam creates the team or replaces its config.
+func (c *concourseClient) SetTeam(teamName string, team atc.Team) error {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("set-team.auth-client-error", err)
+		return err
+	}
+	_, _, _, err = client.Team(teamName).CreateOrUpdate(team)
+	if err != nil {
+		c.logger.Error("set-team.unknown-set-error", err, lager.Data{"team-name": teamName})
+		return err
+	}
+	return nil
+}
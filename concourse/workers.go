@@ -0,0 +1,57 @@
+package concourse
+
+import (
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+)
+
+// ListWorkers returns the workers every team can use and the ones of the main team.
+func (c *concourseClient) ListWorkers() ([]atc.Worker, error) {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("list-workers.auth-client-error", err)
+		return nil, err
+	}
+	workers, err := client.ListWorkers()
+	if err != nil {
+		c.logger.Error("list-workers.unknown-list-error", err)
+		return nil, err
+	}
+	return workers, nil
+}
+
+// ListTeamWorkers returns the workers that only run the builds of a team.
+func (c *concourseClient) ListTeamWorkers(teamName string) ([]atc.Worker, error) {
+	client, err := c.getTeamClient(teamName)
+	if err != nil {
+		c.logger.Error("list-team-workers.auth-client-error", err, lager.Data{"team-name": teamName})
+		return nil, err
+	}
+	workers, err := client.ListWorkers()
+	if err != nil {
+		c.logger.Error("list-team-workers.unknown-list-error", err, lager.Data{"team-name": teamName})
+		return nil, err
+	}
+	team := []atc.Worker{}
+	for _, worker := range workers {
+		if worker.Team == teamName {
+			team = append(team, worker)
+		}
+	}
+	return team, nil
+}
+
+// PruneWorker removes a worker. Concourse only prunes workers that stalled.
+func (c *concourseClient) PruneWorker(workerName string) error {
+	client, err := c.getAuthClient(c.env.ConcourseURL)
+	if err != nil {
+		c.logger.Error("prune-worker.auth-client-error", err)
+		return err
+	}
+	err = client.PruneWorker(workerName)
+	if err != nil {
+		c.logger.Error("prune-worker.unknown-prune-error", err, lager.Data{"worker-name": workerName})
+		return err
+	}
+	return nil
+}
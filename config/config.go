@@ -1,21 +1,78 @@
 package config
 
-import "github.com/kelseyhightower/envconfig"
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
 
 type Env struct {
-	BrokerUsername    string `envconfig:"broker_username" required:"true"`
-	BrokerPassword    string `envconfig:"broker_password" required:"true"`
-	AdminUsername     string `envconfig:"admin_username" required:"true"`
-	AdminPassword     string `envconfig:"admin_password" required:"true"`
-	ConcourseURL      string `envconfig:"concourse_url" required:"true"`
-	CFURL             string `envconfig:"cf_url" required:"true"`
-	TokenURL          string `envconfig:"token_url" required:"true"`
-	AuthURL           string `envconfig:"auth_url" required:"true"`
-	ClientID          string `envconfig:"client_id" required:"true"`
-	ClientSecret      string `envconfig:"client_secret" required:"true"`
-	LogLevel          string `envconfig:"log_level" default:"INFO"`
-	Port              string `envconfig:"port" default:"3000"`
-	SkipSslValidation string `envconfig:"skip_ssl_validation" default:"false"`
+	BrokerUsername       string            `envconfig:"broker_username" required:"true"`
+	BrokerPassword       string            `envconfig:"broker_password" required:"true"`
+	AdminUsername        string            `envconfig:"admin_username" required:"true"`
+	AdminPassword        string            `envconfig:"admin_password" required:"true"`
+	ConcourseURL         string            `envconfig:"concourse_url" required:"true"`
+	CFURL                string            `envconfig:"cf_url" required:"true"`
+	TokenURL             string            `envconfig:"token_url" required:"true"`
+	AuthURL              string            `envconfig:"auth_url" required:"true"`
+	ClientID             string            `envconfig:"client_id" required:"true"`
+	ClientSecret         string            `envconfig:"client_secret" required:"true"`
+	LogLevel             string            `envconfig:"log_level" default:"INFO"`
+	Port                 string            `envconfig:"port" default:"3000"`
+	SkipSslValidation    string            `envconfig:"skip_ssl_validation" default:"false"`
+	DataDir              string            `envconfig:"data_dir" default:"data"`
+	AuditBackend         string            `envconfig:"audit_backend" default:"file"`
+	ProvisionRoles       []string          `envconfig:"provision_roles"`
+	DeprovisionRoles     []string          `envconfig:"deprovision_roles"`
+	AddSpaceRoles        []string          `envconfig:"add_space_roles"`
+	AllowedOrgs          []string          `envconfig:"allowed_orgs"`
+	DeniedOrgs           []string          `envconfig:"denied_orgs"`
+	AllowedSpaces        []string          `envconfig:"allowed_spaces"`
+	DeniedSpaces         []string          `envconfig:"denied_spaces"`
+	OrgInstanceLimit     int               `envconfig:"org_instance_limit" default:"0"`
+	OrgTeamLimit         int               `envconfig:"org_team_limit" default:"0"`
+	ProtectedTeams       []string          `envconfig:"protected_teams"`
+	ReconcileInterval    time.Duration     `envconfig:"reconcile_interval" default:"15m"`
+	ReconcileRepair      bool              `envconfig:"reconcile_repair" default:"false"`
+	NotifyWebhookURL     string            `envconfig:"notify_webhook_url"`
+	GCInterval           time.Duration     `envconfig:"gc_interval" default:"1h"`
+	GCGracePeriod        time.Duration     `envconfig:"gc_grace_period" default:"168h"`
+	GCDryRun             bool              `envconfig:"gc_dry_run" default:"true"`
+	GCSkipTeams          []string          `envconfig:"gc_skip_teams"`
+	PlansFile            string            `envconfig:"plans_file" default:"plans.json"`
+	PipelinesDir         string            `envconfig:"pipelines_dir" default:"pipelines"`
+	SeedParamURLs        bool              `envconfig:"seed_param_urls" default:"false"`
+	TemplateSyncInterval time.Duration     `envconfig:"template_sync_interval" default:"10m"`
+	SoftDeleteWindow     time.Duration     `envconfig:"soft_delete_window" default:"0"`
+	SoftDeleteInterval   time.Duration     `envconfig:"soft_delete_interval" default:"10m"`
+	QuotaInterval        time.Duration     `envconfig:"quota_interval" default:"15m"`
+	QuotaGracePeriod     time.Duration     `envconfig:"quota_grace_period" default:"24h"`
+	PolicyDenyPrivileged bool              `envconfig:"policy_deny_privileged" default:"false"`
+	PolicyResourceTypes  []string          `envconfig:"policy_resource_types"`
+	PolicyRegistries     []string          `envconfig:"policy_registries"`
+	PolicySerialJobs     []string          `envconfig:"policy_serial_jobs"`
+	PolicyAutoPause      bool              `envconfig:"policy_auto_pause" default:"false"`
+	PolicyScanInterval   time.Duration     `envconfig:"policy_scan_interval" default:"1h"`
+	ExposureInterval     time.Duration     `envconfig:"exposure_interval" default:"5m"`
+	UsageInterval        time.Duration     `envconfig:"usage_interval" default:"1h"`
+	IdleAfter            time.Duration     `envconfig:"idle_after" default:"0"`
+	IdleAction           string            `envconfig:"idle_action" default:"none"`
+	IdleInterval         time.Duration     `envconfig:"idle_interval" default:"1h"`
+	FootprintInterval    time.Duration     `envconfig:"footprint_interval" default:"5m"`
+	FootprintEnforce     bool              `envconfig:"footprint_enforce" default:"false"`
+	FootprintGracePeriod time.Duration     `envconfig:"footprint_grace_period" default:"30m"`
+	WorkersMin           int               `envconfig:"workers_min" default:"0"`
+	WorkersTags          []string          `envconfig:"workers_tags"`
+	WorkersPlatforms     []string          `envconfig:"workers_platforms"`
+	WorkersTargets       []string          `envconfig:"workers_targets"`
+	WorkersCheckInterval time.Duration     `envconfig:"workers_check_interval" default:"5m"`
+	IsolationSegmentTags map[string]string `envconfig:"isolation_segment_tags"`
+	TSAHost              string            `envconfig:"tsa_host"`
+	TSAPort              string            `envconfig:"tsa_port" default:"2222"`
+	TSAPublicKey         string            `envconfig:"tsa_public_key"`
+	WorkerKeysDir        string            `envconfig:"worker_keys_dir"`
+	WorkerPruneInterval  time.Duration     `envconfig:"worker_prune_interval" default:"5m"`
+	BrokerURL            string            `envconfig:"broker_url"`
 }
 
 func LoadEnv() (Env, error) {
@@ -0,0 +1,264 @@
+package exposure
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/notify"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// Action is a pipeline the enforcer hid or exposed.
+type Action struct {
+	TeamName string `json:"team"`
+	Pipeline string `json:"pipeline"`
+	// Action is hide-pipeline or expose-pipeline
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+// Report is the outcome of an enforcer run.
+type Report struct {
+	Time    time.Time `json:"time"`
+	Actions []Action  `json:"actions"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Policy is what the plans of a team's instances allow.
+type Policy struct {
+	Hidden  bool
+	Exposed bool
+	// Required are the names or glob patterns of pipelines that must stay exposed
+	Required []string
+}
+
+// Restricts tells whether the policy changes anything.
+func (p Policy) Restricts() bool {
+	return !p.Hidden || !p.Exposed || len(p.Required) > 0
+}
+
+// TeamPolicy returns the policy of a team. A team shared by instances of several plans may use the
+// exposure states any of them allows, and keeps exposed what any of them requires.
+func TeamPolicy(brokerPlans plans.Plans, instances []store.Instance) Policy {
+	policy := Policy{}
+	for _, instance := range instances {
+		plan := brokerPlans.Get(instance.PlanID)
+		if len(plan.Exposure) == 0 {
+			policy.Hidden = true
+			policy.Exposed = true
+		}
+		for _, state := range plan.Exposure {
+			policy.Hidden = policy.Hidden || state == plans.Hidden
+			policy.Exposed = policy.Exposed || state == plans.Exposed
+		}
+		policy.Required = append(policy.Required, plan.ExposedPipelines...)
+	}
+	return policy
+}
+
+func (p Policy) required(pipelineName string) bool {
+	for _, pattern := range p.Required {
+		if ok, _ := path.Match(pattern, pipelineName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Enforcer hides pipelines that are exposed against the exposure policy of their team's plans and
+// exposes the ones the plans require to be exposed.
+type Enforcer struct {
+	store           store.Store
+	lock            sync.Locker
+	auditLog        audit.Log
+	notifier        notify.Notifier
+	plans           plans.Plans
+	concourseClient concourse.Client
+	logger          lager.Logger
+
+	mu   sync.Mutex
+	last Report
+}
+
+// New returns an enforcer.
+func New(s store.Store, lock sync.Locker, auditLog audit.Log, notifier notify.Notifier, brokerPlans plans.Plans,
+	concourseClient concourse.Client, logger lager.Logger) *Enforcer {
+	return &Enforcer{
+		store:           s,
+		lock:            lock,
+		auditLog:        auditLog,
+		notifier:        notifier,
+		plans:           brokerPlans,
+		concourseClient: concourseClient,
+		logger:          logger.Session("exposure"),
+	}
+}
+
+// Run enforces once.
+func (e *Enforcer) Run() (Report, error) {
+	report := Report{Time: time.Now().UTC(), Actions: []Action{}}
+	err := e.run(&report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	e.mu.Lock()
+	e.last = report
+	e.mu.Unlock()
+	return report, err
+}
+
+// LastReport returns the report of the most recent run.
+func (e *Enforcer) LastReport() Report {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.last
+}
+
+func (e *Enforcer) run(report *Report) error {
+	instances, err := e.instances()
+	if err != nil {
+		return err
+	}
+	teamInstances := map[string][]store.Instance{}
+	for _, instance := range instances {
+		teamInstances[instance.TeamName] = append(teamInstances[instance.TeamName], instance)
+	}
+	teamNames := []string{}
+	for teamName := range teamInstances {
+		teamNames = append(teamNames, teamName)
+	}
+	sort.Strings(teamNames)
+	var lastErr error
+	for _, teamName := range teamNames {
+		policy := TeamPolicy(e.plans, teamInstances[teamName])
+		if !policy.Restricts() {
+			continue
+		}
+		actions, err := e.enforce(teamName, policy)
+		report.Actions = append(report.Actions, actions...)
+		if err != nil {
+			e.logger.Error("enforce-error", err, lager.Data{"team-name": teamName})
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// instances returns the instances under the lock. The pipelines are listed without it, this runs
+// every few minutes.
+func (e *Enforcer) instances() ([]store.Instance, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return store.ListInstances(e.store)
+}
+
+func (e *Enforcer) enforce(teamName string, policy Policy) ([]Action, error) {
+	actions := []Action{}
+	pipelines, err := e.concourseClient.ListPipelines(teamName)
+	if err != nil {
+		return actions, err
+	}
+	for _, pipeline := range pipelines {
+		action := policy.action(teamName, pipeline)
+		if action == nil {
+			continue
+		}
+		applied, err := e.apply(*action, pipeline)
+		if err != nil {
+			return actions, err
+		}
+		if !applied {
+			continue
+		}
+		e.logger.Info(action.Action, lager.Data{"team-name": teamName, "pipeline-name": pipeline.Name})
+		e.notify(*action)
+		actions = append(actions, *action)
+	}
+	return actions, nil
+}
+
+// action returns what the policy asks to be done to a pipeline, if anything.
+func (p Policy) action(teamName string, pipeline atc.Pipeline) *Action {
+	var action *Action
+	switch {
+	case p.required(pipeline.Name):
+		if !pipeline.Public {
+			action = &Action{Action: "expose-pipeline", Reason: "the plan requires the pipeline to stay exposed"}
+		}
+	case pipeline.Public && !p.Exposed:
+		action = &Action{Action: "hide-pipeline", Reason: "the plan does not allow exposed pipelines"}
+	case !pipeline.Public && !p.Hidden:
+		action = &Action{Action: "expose-pipeline", Reason: "the plan does not allow hidden pipelines"}
+	}
+	if action != nil {
+		action.TeamName = teamName
+		action.Pipeline = pipeline.Name
+	}
+	return action
+}
+
+// apply exposes or hides a pipeline under the lock. The policy is worked out again from the
+// instances of the team, so a team that was deprovisioned or changed its plan meanwhile is left
+// alone.
+func (e *Enforcer) apply(action Action, pipeline atc.Pipeline) (bool, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	instances, err := store.TeamInstances(e.store, action.TeamName)
+	if err != nil || len(instances) == 0 {
+		return false, err
+	}
+	current := TeamPolicy(e.plans, instances).action(action.TeamName, pipeline)
+	if current == nil || *current != action {
+		return false, nil
+	}
+	if action.Action == "hide-pipeline" {
+		err = e.concourseClient.HidePipeline(action.TeamName, action.Pipeline)
+	} else {
+		err = e.concourseClient.ExposePipeline(action.TeamName, action.Pipeline)
+	}
+	e.record(action, err)
+	return err == nil, err
+}
+
+func (e *Enforcer) notify(action Action) {
+	verb := "hid"
+	if action.Action == "expose-pipeline" {
+		verb = "exposed"
+	}
+	err := e.notifier.Notify(notify.Notification{
+		Time:     time.Now().UTC(),
+		Event:    "pipeline-exposure",
+		TeamName: action.TeamName,
+		Message:  fmt.Sprintf("%s pipeline %s: %s", verb, action.Pipeline, action.Reason),
+	})
+	if err != nil {
+		e.logger.Error("notify-error", err, lager.Data{"team-name": action.TeamName})
+	}
+}
+
+func (e *Enforcer) record(action Action, err error) {
+	entry := audit.Entry{
+		Time:      time.Now().UTC(),
+		Operation: action.Action,
+		TeamName:  action.TeamName,
+		Caller:    "broker",
+		Outcome:   audit.Succeeded,
+		Detail:    fmt.Sprintf("%s: %s", action.Pipeline, action.Reason),
+	}
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+	}
+	auditErr := e.auditLog.Record(entry)
+	if auditErr != nil {
+		e.logger.Error("audit-error", auditErr)
+	}
+}
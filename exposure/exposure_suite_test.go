@@ -0,0 +1,13 @@
+package exposure
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestExposure(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Exposure Suite")
+}
@@ -0,0 +1,88 @@
+package exposure
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Enforcer", func() {
+	var (
+		brokerStore     store.Store
+		auditLog        audit.Log
+		notifier        *fakes.Notifier
+		concourseClient *fakes.ConcourseClient
+		brokerPlans     plans.Plans
+		build, status   *fakes.Pipeline
+	)
+
+	run := func() Report {
+		enforcer := New(brokerStore, &sync.Mutex{}, auditLog, notifier, brokerPlans, concourseClient, lagertest.NewTestLogger("exposure"))
+		report, err := enforcer.Run()
+		Expect(err).NotTo(HaveOccurred())
+		return report
+	}
+
+	BeforeEach(func() {
+		brokerStore = store.NewMemoryStore()
+		auditLog = audit.NewStoreLog(brokerStore)
+		notifier = &fakes.Notifier{}
+		concourseClient = fakes.NewConcourseClient()
+		brokerPlans = plans.Plans{
+			"private": {Exposure: []string{plans.Hidden}, ExposedPipelines: []string{"status*"}},
+			"public":  {},
+		}
+		Expect(concourseClient.CreateTeam(cf.Details{OrgName: "venture", SpaceGUID: "space-a"})).To(Succeed())
+		build = concourseClient.AddPipeline("venture", atc.Pipeline{Name: "build", Public: true}, atc.Config{})
+		status = concourseClient.AddPipeline("venture", atc.Pipeline{Name: "status-page"}, atc.Config{})
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-1", PlanID: "private", TeamName: "venture"})).To(Succeed())
+	})
+
+	It("hides pipelines exposed against the plan and exposes required ones", func() {
+		report := run()
+		Expect(report.Actions).To(Equal([]Action{
+			{TeamName: "venture", Pipeline: "build", Action: "hide-pipeline", Reason: "the plan does not allow exposed pipelines"},
+			{TeamName: "venture", Pipeline: "status-page", Action: "expose-pipeline", Reason: "the plan requires the pipeline to stay exposed"},
+		}))
+		Expect(build.Public).To(BeFalse())
+		Expect(status.Public).To(BeTrue())
+
+		entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+		Expect(entries).To(HaveLen(2))
+		Expect(notifier.Notifications).To(HaveLen(2))
+		Expect(notifier.Notifications[0].Message).To(Equal("hid pipeline build: the plan does not allow exposed pipelines"))
+
+		Expect(run().Actions).To(BeEmpty())
+	})
+
+	It("allows what any plan of a shared team allows", func() {
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-2", PlanID: "public", TeamName: "venture"})).To(Succeed())
+		report := run()
+		Expect(report.Actions).To(HaveLen(1))
+		Expect(report.Actions[0].Pipeline).To(Equal("status-page"))
+		Expect(build.Public).To(BeTrue())
+	})
+
+	It("leaves teams without a policy alone", func() {
+		brokerPlans = plans.Plans{}
+		Expect(run().Actions).To(BeEmpty())
+		Expect(build.Public).To(BeTrue())
+	})
+	It("leaves pipelines of a team deprovisioned during the run alone", func() {
+		enforcer := New(brokerStore, &sync.Mutex{}, auditLog, notifier, brokerPlans, concourseClient, lagertest.NewTestLogger("exposure"))
+		action := Action{TeamName: "venture", Pipeline: "build", Action: "hide-pipeline", Reason: "the plan does not allow exposed pipelines"}
+		Expect(store.DeleteInstance(brokerStore, "instance-1")).To(Succeed())
+		applied, err := enforcer.apply(action, build.Pipeline)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(applied).To(BeFalse())
+		Expect(build.Public).To(BeTrue())
+	})
+})
@@ -0,0 +1,59 @@
+package fakes
+
+import (
+	"github.com/vchrisr/concourse-broker/cf"
+)
+
+// CFClient is an in-memory cf.Client for tests.
+type CFClient struct {
+	Spaces    map[string]cf.Details
+	Instances map[string]string
+	UserRoles map[string][]cf.Role
+	// IsolationSegments are the isolation segments of spaces, by space GUID
+	IsolationSegments map[string]string
+	Err               error
+}
+
+var _ cf.Client = &CFClient{}
+
+// NewCFClient returns a CF without spaces or service instances.
+func NewCFClient() *CFClient {
+	return &CFClient{
+		Spaces:            map[string]cf.Details{},
+		Instances:         map[string]string{},
+		UserRoles:         map[string][]cf.Role{},
+		IsolationSegments: map[string]string{},
+	}
+}
+
+// AddInstance adds a service instance in a space to the fake CF.
+func (c *CFClient) AddInstance(instanceID string, details cf.Details) {
+	c.Spaces[details.SpaceGUID] = details
+	c.Instances[instanceID] = details.SpaceGUID
+}
+
+func (c *CFClient) GetProvisionDetails(spaceGUID string) (cf.Details, error) {
+	return c.Spaces[spaceGUID], c.Err
+}
+
+func (c *CFClient) GetDeprovisionDetails(serviceGUID string) (cf.Details, error) {
+	return c.Spaces[c.Instances[serviceGUID]], c.Err
+}
+
+func (c *CFClient) GetUserRoles(userGUID string, details cf.Details) ([]cf.Role, error) {
+	return c.UserRoles[userGUID], c.Err
+}
+
+func (c *CFClient) InstanceExists(serviceGUID string) (bool, error) {
+	_, ok := c.Instances[serviceGUID]
+	return ok, c.Err
+}
+
+func (c *CFClient) GetSpaceDetails(spaceGUID string) (cf.Details, bool, error) {
+	details, ok := c.Spaces[spaceGUID]
+	return details, ok, c.Err
+}
+
+func (c *CFClient) GetIsolationSegment(details cf.Details) (string, error) {
+	return c.IsolationSegments[details.SpaceGUID], c.Err
+}
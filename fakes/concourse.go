@@ -0,0 +1,326 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/concourse"
+)
+
+// ConcourseClient is an in-memory concourse.Client for tests.
+type ConcourseClient struct {
+	Teams       map[string]atc.Team
+	AuthMethods map[string][]atc.AuthMethod
+	Pipelines   map[string][]*Pipeline
+	Destroyed   []string
+	// ConfigWarnings are returned by every SetPipelineConfig
+	ConfigWarnings []string
+	// AllBuilds are the builds of every team, newest first
+	AllBuilds []atc.Build
+	// Containers and Volumes are the ones of each team
+	Containers map[string][]atc.Container
+	Volumes    map[string][]atc.Volume
+	Workers    []atc.Worker
+	// Pruned are the names of the workers PruneWorker removed
+	Pruned []string
+	Err    error
+}
+
+// Pipeline is a pipeline in the fake Concourse.
+type Pipeline struct {
+	atc.Pipeline
+	Config    atc.Config
+	RawConfig atc.RawConfig
+	Version   string
+}
+
+var _ concourse.Client = &ConcourseClient{}
+
+// NewConcourseClient returns a Concourse with only the main team.
+func NewConcourseClient() *ConcourseClient {
+	return &ConcourseClient{
+		Teams:       map[string]atc.Team{"main": {Name: "main"}},
+		AuthMethods: map[string][]atc.AuthMethod{},
+		Pipelines:   map[string][]*Pipeline{},
+		Containers:  map[string][]atc.Container{},
+		Volumes:     map[string][]atc.Volume{},
+	}
+}
+
+// UAAAuthMethods are the auth methods of a team the broker configured.
+var UAAAuthMethods = []atc.AuthMethod{{Type: atc.AuthTypeOAuth, DisplayName: "UAA"}}
+
+func (c *ConcourseClient) TeamName(details cf.Details) string {
+	return details.OrgName
+}
+
+func (c *ConcourseClient) TeamConfig(spaceGUIDs []string) atc.Team {
+	return atc.Team{UAAAuth: &atc.UAAAuth{CFSpaces: spaceGUIDs}}
+}
+
+func (c *ConcourseClient) CreateTeam(details cf.Details) error {
+	if c.Err != nil {
+		return c.Err
+	}
+	name := c.TeamName(details)
+	if _, ok := c.Teams[name]; ok {
+		return fmt.Errorf("Team %s already exists", name)
+	}
+	return c.SetTeam(name, c.TeamConfig([]string{details.SpaceGUID}))
+}
+
+func (c *ConcourseClient) UpdateTeam(teamName string, team atc.Team) error {
+	if _, ok := c.Teams[teamName]; !ok {
+		return fmt.Errorf("Team %s did not exist and was created", teamName)
+	}
+	return c.SetTeam(teamName, team)
+}
+
+func (c *ConcourseClient) SetTeam(teamName string, team atc.Team) error {
+	if c.Err != nil {
+		return c.Err
+	}
+	team.Name = teamName
+	c.Teams[teamName] = team
+	c.AuthMethods[teamName] = UAAAuthMethods
+	return nil
+}
+
+func (c *ConcourseClient) DeleteTeam(details cf.Details) error {
+	return c.DestroyTeam(c.TeamName(details))
+}
+
+func (c *ConcourseClient) DestroyTeam(teamName string) error {
+	if c.Err != nil {
+		return c.Err
+	}
+	delete(c.Teams, teamName)
+	delete(c.AuthMethods, teamName)
+	delete(c.Pipelines, teamName)
+	c.Destroyed = append(c.Destroyed, teamName)
+	return nil
+}
+
+func (c *ConcourseClient) ListTeams() ([]atc.Team, error) {
+	teams := []atc.Team{}
+	for _, team := range c.Teams {
+		teams = append(teams, atc.Team{Name: team.Name})
+	}
+	return teams, c.Err
+}
+
+func (c *ConcourseClient) ListAuthMethods(teamName string) ([]atc.AuthMethod, error) {
+	return c.AuthMethods[teamName], c.Err
+}
+
+// AddPipeline adds a pipeline to a team in the fake Concourse.
+func (c *ConcourseClient) AddPipeline(teamName string, pipeline atc.Pipeline, config atc.Config) *Pipeline {
+	pipeline.TeamName = teamName
+	raw, _ := json.Marshal(config)
+	p := &Pipeline{Pipeline: pipeline, Config: config, RawConfig: atc.RawConfig(raw), Version: "1"}
+	c.Pipelines[teamName] = append(c.Pipelines[teamName], p)
+	return p
+}
+
+func (c *ConcourseClient) pipeline(teamName, pipelineName string) *Pipeline {
+	for _, p := range c.Pipelines[teamName] {
+		if p.Name == pipelineName {
+			return p
+		}
+	}
+	return nil
+}
+
+func (c *ConcourseClient) ListPipelines(teamName string) ([]atc.Pipeline, error) {
+	pipelines := []atc.Pipeline{}
+	for _, p := range c.Pipelines[teamName] {
+		pipelines = append(pipelines, p.Pipeline)
+	}
+	return pipelines, c.Err
+}
+
+func (c *ConcourseClient) PipelineConfig(teamName, pipelineName string) (atc.Config, atc.RawConfig, string, bool, error) {
+	p := c.pipeline(teamName, pipelineName)
+	if p == nil {
+		return atc.Config{}, "", "", false, c.Err
+	}
+	return p.Config, p.RawConfig, p.Version, true, c.Err
+}
+
+func (c *ConcourseClient) SetPipelineConfig(teamName, pipelineName, version string, config atc.Config) (bool, []string, error) {
+	if c.Err != nil {
+		return false, nil, c.Err
+	}
+	raw, _ := json.Marshal(config)
+	p := c.pipeline(teamName, pipelineName)
+	if p == nil {
+		// Concourse pauses new pipelines
+		c.AddPipeline(teamName, atc.Pipeline{Name: pipelineName, Paused: true}, config)
+		return true, c.ConfigWarnings, nil
+	}
+	if p.Version != version {
+		return false, nil, fmt.Errorf("config version %s of pipeline %s is out of date", version, pipelineName)
+	}
+	p.Config = config
+	p.RawConfig = atc.RawConfig(raw)
+	current, _ := strconv.Atoi(p.Version)
+	p.Version = strconv.Itoa(current + 1)
+	return false, c.ConfigWarnings, nil
+}
+
+func (c *ConcourseClient) UnpausePipeline(teamName, pipelineName string) error {
+	return c.managePipeline(teamName, pipelineName, func(p *Pipeline) { p.Paused = false })
+}
+
+func (c *ConcourseClient) PausePipeline(teamName, pipelineName string) error {
+	return c.managePipeline(teamName, pipelineName, func(p *Pipeline) { p.Paused = true })
+}
+
+func (c *ConcourseClient) ExposePipeline(teamName, pipelineName string) error {
+	return c.managePipeline(teamName, pipelineName, func(p *Pipeline) { p.Public = true })
+}
+
+func (c *ConcourseClient) HidePipeline(teamName, pipelineName string) error {
+	return c.managePipeline(teamName, pipelineName, func(p *Pipeline) { p.Public = false })
+}
+
+func (c *ConcourseClient) DeletePipeline(teamName, pipelineName string) error {
+	if c.Err != nil {
+		return c.Err
+	}
+	pipelines := []*Pipeline{}
+	for _, p := range c.Pipelines[teamName] {
+		if p.Name != pipelineName {
+			pipelines = append(pipelines, p)
+		}
+	}
+	if len(pipelines) == len(c.Pipelines[teamName]) {
+		return fmt.Errorf("Pipeline %s of team %s does not exist", pipelineName, teamName)
+	}
+	c.Pipelines[teamName] = pipelines
+	return nil
+}
+
+func (c *ConcourseClient) JobBuilds(teamName, pipelineName, jobName string, after int) ([]atc.Build, error) {
+	builds := []atc.Build{}
+	for _, build := range c.AllBuilds {
+		if build.TeamName == teamName && build.PipelineName == pipelineName && build.JobName == jobName && build.ID > after {
+			builds = append(builds, build)
+		}
+	}
+	return builds, c.Err
+}
+
+func (c *ConcourseClient) Builds(after int) ([]atc.Build, error) {
+	builds := []atc.Build{}
+	for _, build := range c.AllBuilds {
+		if build.ID > after {
+			builds = append(builds, build)
+		}
+	}
+	return builds, c.Err
+}
+
+func (c *ConcourseClient) RecentJobBuilds(teamName, pipelineName, jobName string, limit int) ([]atc.Build, bool, error) {
+	if c.Err != nil {
+		return nil, false, c.Err
+	}
+	if !c.hasJob(teamName, pipelineName, jobName) {
+		return nil, false, nil
+	}
+	builds, _ := c.JobBuilds(teamName, pipelineName, jobName, 0)
+	if len(builds) > limit {
+		builds = builds[:limit]
+	}
+	return builds, true, nil
+}
+
+// CreateJobBuild adds a pending build to AllBuilds for a job of a pipeline config.
+func (c *ConcourseClient) CreateJobBuild(teamName, pipelineName, jobName string) (atc.Build, error) {
+	if c.Err != nil {
+		return atc.Build{}, c.Err
+	}
+	if !c.hasJob(teamName, pipelineName, jobName) {
+		return atc.Build{}, fmt.Errorf("Job %s of pipeline %s does not exist", jobName, pipelineName)
+	}
+	id := 1
+	if len(c.AllBuilds) > 0 {
+		id = c.AllBuilds[0].ID + 1
+	}
+	build := atc.Build{ID: id, Name: strconv.Itoa(id), Status: "pending", TeamName: teamName, PipelineName: pipelineName, JobName: jobName}
+	c.AllBuilds = append([]atc.Build{build}, c.AllBuilds...)
+	return build, nil
+}
+
+func (c *ConcourseClient) Build(buildID int) (atc.Build, bool, error) {
+	for _, build := range c.AllBuilds {
+		if build.ID == buildID {
+			return build, true, c.Err
+		}
+	}
+	return atc.Build{}, false, c.Err
+}
+
+func (c *ConcourseClient) hasJob(teamName, pipelineName, jobName string) bool {
+	p := c.pipeline(teamName, pipelineName)
+	if p == nil {
+		return false
+	}
+	for _, job := range p.Config.Jobs {
+		if job.Name == jobName {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ConcourseClient) ListContainers(teamName string) ([]atc.Container, error) {
+	return c.Containers[teamName], c.Err
+}
+
+func (c *ConcourseClient) ListVolumes(teamName string) ([]atc.Volume, error) {
+	return c.Volumes[teamName], c.Err
+}
+
+func (c *ConcourseClient) ListWorkers() ([]atc.Worker, error) {
+	return c.Workers, c.Err
+}
+
+func (c *ConcourseClient) ListTeamWorkers(teamName string) ([]atc.Worker, error) {
+	workers := []atc.Worker{}
+	for _, worker := range c.Workers {
+		if worker.Team == teamName {
+			workers = append(workers, worker)
+		}
+	}
+	return workers, c.Err
+}
+
+func (c *ConcourseClient) PruneWorker(workerName string) error {
+	if c.Err != nil {
+		return c.Err
+	}
+	for i, worker := range c.Workers {
+		if worker.Name == workerName {
+			c.Workers = append(c.Workers[:i], c.Workers[i+1:]...)
+			c.Pruned = append(c.Pruned, workerName)
+			return nil
+		}
+	}
+	return fmt.Errorf("Worker %s does not exist", workerName)
+}
+
+func (c *ConcourseClient) managePipeline(teamName, pipelineName string, manage func(*Pipeline)) error {
+	if c.Err != nil {
+		return c.Err
+	}
+	p := c.pipeline(teamName, pipelineName)
+	if p == nil {
+		return fmt.Errorf("Pipeline %s of team %s does not exist", pipelineName, teamName)
+	}
+	manage(p)
+	return nil
+}
@@ -0,0 +1,17 @@
+package fakes
+
+import (
+	"github.com/vchrisr/concourse-broker/notify"
+)
+
+// Notifier is a notify.Notifier that keeps the notifications it was sent, for tests.
+type Notifier struct {
+	Notifications []notify.Notification
+}
+
+var _ notify.Notifier = &Notifier{}
+
+func (n *Notifier) Notify(notification notify.Notification) error {
+	n.Notifications = append(n.Notifications, notification)
+	return nil
+}
@@ -0,0 +1,362 @@
+package footprint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/notify"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// States of a team against its limits.
+const (
+	Within   = "within"
+	Warned   = "warned"
+	Enforced = "enforced"
+)
+
+// Usage is what an enforcer run found for a team.
+type Usage struct {
+	store.Footprint
+	State string `json:"state"`
+	// Paused are the busiest pipelines the run paused
+	Paused []string `json:"paused,omitempty"`
+}
+
+// Report is the outcome of an enforcer run.
+type Report struct {
+	Time    time.Time `json:"time"`
+	Enforce bool      `json:"enforce"`
+	Teams   []Usage   `json:"teams"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Enforcer counts the containers and volumes of every team on the workers against the limits of
+// its plans. A team over its limits is warned and, when FOOTPRINT_ENFORCE is set and the team
+// stays over them for FOOTPRINT_GRACE_PERIOD, its busiest pipelines are paused.
+type Enforcer struct {
+	store           store.Store
+	lock            sync.Locker
+	auditLog        audit.Log
+	notifier        notify.Notifier
+	plans           plans.Plans
+	concourseClient concourse.Client
+	logger          lager.Logger
+	enforce         bool
+	gracePeriod     time.Duration
+	now             func() time.Time
+
+	mu   sync.Mutex
+	last Report
+}
+
+// New returns an enforcer.
+func New(s store.Store, lock sync.Locker, auditLog audit.Log, notifier notify.Notifier, brokerPlans plans.Plans,
+	concourseClient concourse.Client, logger lager.Logger, env config.Env) *Enforcer {
+	return &Enforcer{
+		store:           s,
+		lock:            lock,
+		auditLog:        auditLog,
+		notifier:        notifier,
+		plans:           brokerPlans,
+		concourseClient: concourseClient,
+		logger:          logger.Session("footprint"),
+		enforce:         env.FootprintEnforce,
+		gracePeriod:     env.FootprintGracePeriod,
+		now:             time.Now,
+	}
+}
+
+// Run checks every team once.
+func (e *Enforcer) Run() (Report, error) {
+	report := Report{Time: e.now().UTC(), Enforce: e.enforce, Teams: []Usage{}}
+	err := e.run(&report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	e.mu.Lock()
+	e.last = report
+	e.mu.Unlock()
+	return report, err
+}
+
+// LastReport returns the report of the most recent run.
+func (e *Enforcer) LastReport() Report {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.last
+}
+
+func (e *Enforcer) run(report *Report) error {
+	instances, err := e.instances()
+	if err != nil {
+		return err
+	}
+	teamInstances := map[string][]store.Instance{}
+	for _, instance := range instances {
+		teamInstances[instance.TeamName] = append(teamInstances[instance.TeamName], instance)
+	}
+	var lastErr error
+	for teamName, instances := range teamInstances {
+		maxContainers, maxVolumes := Limits(e.plans, instances)
+		usage, err := e.check(teamName, maxContainers, maxVolumes)
+		if err != nil {
+			e.logger.Error("check-error", err, lager.Data{"team-name": teamName})
+			lastErr = err
+			continue
+		}
+		report.Teams = append(report.Teams, usage)
+	}
+	sort.Sort(byTeam(report.Teams))
+	err = e.dropFootprints()
+	if err != nil {
+		return err
+	}
+	return lastErr
+}
+
+// dropFootprints deletes the footprint of teams that are gone, it is not shown any more.
+func (e *Enforcer) dropFootprints() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	footprints, err := store.ListFootprints(e.store)
+	if err != nil {
+		return err
+	}
+	for _, footprint := range footprints {
+		instances, err := store.TeamInstances(e.store, footprint.TeamName)
+		if err != nil {
+			return err
+		}
+		if len(instances) == 0 {
+			err = store.DeleteFootprint(e.store, footprint.TeamName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// instances returns the instances under the lock. The containers and volumes are listed without it,
+// a team that goes away meanwhile fails its check and its footprint is dropped.
+func (e *Enforcer) instances() ([]store.Instance, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return store.ListInstances(e.store)
+}
+
+// Limits returns the limits of a team. A team shared by instances of several plans gets the most
+// generous limits among them.
+func Limits(brokerPlans plans.Plans, instances []store.Instance) (int, int) {
+	maxContainers, maxVolumes := 0, 0
+	for i, instance := range instances {
+		plan := brokerPlans.Get(instance.PlanID)
+		if i == 0 || (maxContainers != 0 && (plan.MaxContainers == 0 || plan.MaxContainers > maxContainers)) {
+			maxContainers = plan.MaxContainers
+		}
+		if i == 0 || (maxVolumes != 0 && (plan.MaxVolumes == 0 || plan.MaxVolumes > maxVolumes)) {
+			maxVolumes = plan.MaxVolumes
+		}
+	}
+	return maxContainers, maxVolumes
+}
+
+func (e *Enforcer) check(teamName string, maxContainers, maxVolumes int) (Usage, error) {
+	containers, err := e.concourseClient.ListContainers(teamName)
+	if err != nil {
+		return Usage{}, err
+	}
+	volumes, err := e.concourseClient.ListVolumes(teamName)
+	if err != nil {
+		return Usage{}, err
+	}
+	var listed []atc.Pipeline
+	if e.enforce {
+		listed, err = e.concourseClient.ListPipelines(teamName)
+		if err != nil {
+			return Usage{}, err
+		}
+	}
+	footprint := store.Footprint{
+		TeamName:      teamName,
+		Containers:    len(containers),
+		Volumes:       len(volumes),
+		MaxContainers: maxContainers,
+		MaxVolumes:    maxVolumes,
+		// containers of one-off builds belong to no pipeline
+		Pipelines: map[string]int{},
+	}
+	for _, container := range containers {
+		if container.PipelineName != "" {
+			footprint.Pipelines[container.PipelineName]++
+		}
+	}
+	var notifications []notify.Notification
+	usage, err := e.apply(footprint, listed, &notifications)
+	for _, notification := range notifications {
+		e.notify(notification.Event, teamName, notification.Message)
+	}
+	return usage, err
+}
+
+// apply saves the footprint of a team and pauses its busiest pipelines once the grace period is
+// over. It holds the lock, and a team that was deprovisioned while its containers were listed fails
+// its check.
+func (e *Enforcer) apply(footprint store.Footprint, listed []atc.Pipeline, notifications *[]notify.Notification) (Usage, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	instances, err := store.TeamInstances(e.store, footprint.TeamName)
+	if err != nil {
+		return Usage{}, err
+	}
+	if len(instances) == 0 {
+		return Usage{}, fmt.Errorf("Team %s was deprovisioned while its containers were listed", footprint.TeamName)
+	}
+	now := e.now().UTC()
+	saved, _, err := store.GetFootprint(e.store, footprint.TeamName)
+	if err != nil {
+		return Usage{}, err
+	}
+	footprint.ExceededAt = saved.ExceededAt
+	footprint.CheckedAt = now
+	usage := Usage{State: Within}
+	if !exceeds(footprint.Containers, footprint.MaxContainers) && !exceeds(footprint.Volumes, footprint.MaxVolumes) {
+		footprint.ExceededAt = nil
+		usage.Footprint = footprint
+		return usage, store.SaveFootprint(e.store, footprint)
+	}
+
+	usage.State = Warned
+	if footprint.ExceededAt == nil {
+		footprint.ExceededAt = &now
+		message := Summary(footprint)
+		if e.enforce {
+			message += fmt.Sprintf(", the busiest pipelines will be paused if the team is still over the limits after %s",
+				now.Add(e.gracePeriod).Format(time.RFC3339))
+		}
+		*notifications = append(*notifications, notify.Notification{Event: "footprint-exceeded", Message: message})
+	}
+	if e.enforce && !now.Before(footprint.ExceededAt.Add(e.gracePeriod)) {
+		usage.State = Enforced
+		usage.Paused, err = e.pauseBusiest(footprint, listed)
+		e.record(footprint.TeamName, usage.Paused, err)
+		if err != nil {
+			return Usage{}, err
+		}
+		if len(usage.Paused) > 0 {
+			*notifications = append(*notifications, notify.Notification{Event: "footprint-enforced",
+				Message: fmt.Sprintf("%s, paused %v", Summary(footprint), usage.Paused)})
+		}
+	}
+	usage.Footprint = footprint
+	return usage, store.SaveFootprint(e.store, footprint)
+}
+
+type pipeline struct {
+	name       string
+	paused     bool
+	containers int
+}
+
+// pauseBusiest pauses the running pipelines with the most containers until the containers of the
+// running ones fit within the limit. Volumes do not tell which pipeline they belong to, so a team
+// over the volume limit only has its busiest running pipeline paused on each run.
+func (e *Enforcer) pauseBusiest(footprint store.Footprint, listed []atc.Pipeline) ([]string, error) {
+	paused := []string{}
+	pipelines := []pipeline{}
+	// containers of paused pipelines go away as their builds finish
+	running := footprint.Containers
+	for _, p := range listed {
+		pipelines = append(pipelines, pipeline{name: p.Name, paused: p.Paused, containers: footprint.Pipelines[p.Name]})
+		if p.Paused {
+			running -= footprint.Pipelines[p.Name]
+		}
+	}
+	sort.Stable(busiestFirst(pipelines))
+	overVolumes := exceeds(footprint.Volumes, footprint.MaxVolumes)
+	for _, p := range pipelines {
+		if p.paused || p.containers == 0 {
+			continue
+		}
+		if !exceeds(running, footprint.MaxContainers) && !(overVolumes && len(paused) == 0) {
+			break
+		}
+		err := e.concourseClient.PausePipeline(footprint.TeamName, p.name)
+		if err != nil {
+			return paused, err
+		}
+		paused = append(paused, p.name)
+		running -= p.containers
+	}
+	return paused, nil
+}
+
+// Summary returns the footprint of a team in words.
+func Summary(footprint store.Footprint) string {
+	return fmt.Sprintf("%s, %s", amount(footprint.Containers, footprint.MaxContainers, "containers"),
+		amount(footprint.Volumes, footprint.MaxVolumes, "volumes"))
+}
+
+func amount(used, limit int, what string) string {
+	if limit == 0 {
+		return fmt.Sprintf("%d %s", used, what)
+	}
+	return fmt.Sprintf("%d of %d %s", used, limit, what)
+}
+
+func exceeds(used, limit int) bool {
+	return limit > 0 && used > limit
+}
+
+func (e *Enforcer) notify(event, teamName, message string) {
+	err := e.notifier.Notify(notify.Notification{Time: e.now().UTC(), Event: event, TeamName: teamName, Message: message})
+	if err != nil {
+		e.logger.Error("notify-error", err, lager.Data{"team-name": teamName})
+	}
+}
+
+// record audits the pipelines an enforcement paused. Runs that paused nothing are not recorded.
+func (e *Enforcer) record(teamName string, paused []string, err error) {
+	if len(paused) == 0 && err == nil {
+		return
+	}
+	e.logger.Info("enforced", lager.Data{"team-name": teamName, "paused": paused})
+	entry := audit.Entry{
+		Time:      e.now().UTC(),
+		Operation: "footprint-pause-pipelines",
+		TeamName:  teamName,
+		Caller:    "broker",
+		Outcome:   audit.Succeeded,
+		Detail:    strings.Join(paused, ", "),
+	}
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+	}
+	auditErr := e.auditLog.Record(entry)
+	if auditErr != nil {
+		e.logger.Error("audit-error", auditErr)
+	}
+}
+
+type busiestFirst []pipeline
+
+func (p busiestFirst) Len() int           { return len(p) }
+func (p busiestFirst) Less(i, j int) bool { return p[i].containers > p[j].containers }
+func (p busiestFirst) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type byTeam []Usage
+
+func (u byTeam) Len() int           { return len(u) }
+func (u byTeam) Less(i, j int) bool { return u[i].TeamName < u[j].TeamName }
+func (u byTeam) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
@@ -0,0 +1,13 @@
+package footprint
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestFootprint(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Footprint Suite")
+}
@@ -0,0 +1,132 @@
+package footprint
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/notify"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Enforcer", func() {
+	var (
+		brokerStore           store.Store
+		auditLog              audit.Log
+		notifier              *fakes.Notifier
+		concourseClient       *fakes.ConcourseClient
+		brokerPlans           plans.Plans
+		env                   config.Env
+		now                   time.Time
+		build, deploy, status *fakes.Pipeline
+	)
+
+	run := func() Report {
+		enforcer := New(brokerStore, &sync.Mutex{}, auditLog, notifier, brokerPlans, concourseClient, lagertest.NewTestLogger("footprint"), env)
+		enforcer.now = func() time.Time { return now }
+		report, err := enforcer.Run()
+		Expect(err).NotTo(HaveOccurred())
+		return report
+	}
+
+	containers := func(pipelineName string, count int) {
+		for i := 0; i < count; i++ {
+			concourseClient.Containers["venture"] = append(concourseClient.Containers["venture"], atc.Container{PipelineName: pipelineName})
+		}
+	}
+
+	BeforeEach(func() {
+		brokerStore = store.NewMemoryStore()
+		auditLog = audit.NewStoreLog(brokerStore)
+		notifier = &fakes.Notifier{}
+		concourseClient = fakes.NewConcourseClient()
+		brokerPlans = plans.Plans{"small": {MaxContainers: 5, MaxVolumes: 10}, "large": {}}
+		env = config.Env{FootprintEnforce: true, FootprintGracePeriod: 30 * time.Minute}
+		now = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+		build = concourseClient.AddPipeline("venture", atc.Pipeline{Name: "build"}, atc.Config{})
+		deploy = concourseClient.AddPipeline("venture", atc.Pipeline{Name: "deploy"}, atc.Config{})
+		status = concourseClient.AddPipeline("venture", atc.Pipeline{Name: "status"}, atc.Config{})
+		containers("build", 2)
+		containers("deploy", 4)
+		containers("status", 1)
+		containers("", 1)
+		concourseClient.Volumes["venture"] = make([]atc.Volume, 8)
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-1", PlanID: "small", TeamName: "venture"})).To(Succeed())
+	})
+
+	It("reports teams within their limits", func() {
+		concourseClient.Containers["venture"] = nil
+		report := run()
+		Expect(report.Teams).To(HaveLen(1))
+		Expect(report.Teams[0].State).To(Equal(Within))
+		Expect(report.Teams[0].Volumes).To(Equal(8))
+		Expect(notifier.Notifications).To(BeEmpty())
+	})
+
+	It("warns teams over their limits and pauses the busiest pipelines after the grace period", func() {
+		report := run()
+		Expect(report.Teams[0].State).To(Equal(Warned))
+		Expect(report.Teams[0].Pipelines).To(Equal(map[string]int{"build": 2, "deploy": 4, "status": 1}))
+		Expect(notifier.Notifications).To(HaveLen(1))
+		Expect(notifier.Notifications[0].Event).To(Equal("footprint-exceeded"))
+		Expect(notifier.Notifications[0].Message).To(HavePrefix("8 of 5 containers, 8 of 10 volumes"))
+		Expect(deploy.Paused).To(BeFalse())
+
+		now = now.Add(30 * time.Minute)
+		report = run()
+		Expect(report.Teams[0].State).To(Equal(Enforced))
+		Expect(report.Teams[0].Paused).To(Equal([]string{"deploy"}))
+		Expect(deploy.Paused).To(BeTrue())
+		Expect(build.Paused).To(BeFalse())
+		Expect(status.Paused).To(BeFalse())
+		entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Operation).To(Equal("footprint-pause-pipelines"))
+
+		// the containers of paused pipelines are on their way out
+		Expect(run().Teams[0].Paused).To(BeEmpty())
+	})
+
+	It("only warns unless enforcing", func() {
+		env.FootprintEnforce = false
+		run()
+		now = now.Add(time.Hour)
+		Expect(run().Teams[0].State).To(Equal(Warned))
+		Expect(deploy.Paused).To(BeFalse())
+	})
+
+	It("leaves a team alone that was deprovisioned while its containers were listed", func() {
+		enforcer := New(brokerStore, &sync.Mutex{}, auditLog, notifier, brokerPlans, concourseClient, lagertest.NewTestLogger("footprint"), env)
+		Expect(store.SaveFootprint(brokerStore, store.Footprint{TeamName: "venture", ExceededAt: &now})).To(Succeed())
+		Expect(store.DeleteInstance(brokerStore, "instance-1")).To(Succeed())
+		footprint := store.Footprint{TeamName: "venture", Containers: 8, MaxContainers: 5, Pipelines: map[string]int{"deploy": 4}}
+		_, err := enforcer.apply(footprint, []atc.Pipeline{deploy.Pipeline}, &[]notify.Notification{})
+		Expect(err).To(HaveOccurred())
+		Expect(deploy.Paused).To(BeFalse())
+	})
+
+	It("gives shared teams the most generous limits", func() {
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-2", PlanID: "large", TeamName: "venture"})).To(Succeed())
+		Expect(run().Teams[0].State).To(Equal(Within))
+	})
+
+	It("writes metrics", func() {
+		run()
+		footprints, err := store.ListFootprints(brokerStore)
+		Expect(err).NotTo(HaveOccurred())
+		buf := &bytes.Buffer{}
+		Expect(WriteMetrics(buf, footprints)).To(Succeed())
+		Expect(buf.String()).To(ContainSubstring("concourse_broker_team_containers{team=\"venture\"} 8\n"))
+		Expect(buf.String()).To(ContainSubstring("concourse_broker_team_max_volumes{team=\"venture\"} 10\n"))
+		Expect(buf.String()).To(ContainSubstring("concourse_broker_team_over_limits{team=\"venture\"} 1\n"))
+	})
+})
@@ -0,0 +1,55 @@
+package footprint
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+type metric struct {
+	name  string
+	help  string
+	value func(store.Footprint) (int, bool)
+}
+
+var metrics = []metric{
+	{"concourse_broker_team_containers", "Containers of the team on the workers.",
+		func(f store.Footprint) (int, bool) { return f.Containers, true }},
+	{"concourse_broker_team_volumes", "Volumes of the team on the workers.",
+		func(f store.Footprint) (int, bool) { return f.Volumes, true }},
+	{"concourse_broker_team_max_containers", "Containers the plans of the team allow.",
+		func(f store.Footprint) (int, bool) { return f.MaxContainers, f.MaxContainers > 0 }},
+	{"concourse_broker_team_max_volumes", "Volumes the plans of the team allow.",
+		func(f store.Footprint) (int, bool) { return f.MaxVolumes, f.MaxVolumes > 0 }},
+	{"concourse_broker_team_over_limits", "1 while the team is over the limits of its plans.",
+		func(f store.Footprint) (int, bool) {
+			if f.ExceededAt != nil {
+				return 1, true
+			}
+			return 0, true
+		}},
+}
+
+// WriteMetrics writes the footprints of the last check in the Prometheus text format. Teams
+// without a limit have no max metric.
+func WriteMetrics(w io.Writer, footprints []store.Footprint) error {
+	for _, m := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		if err != nil {
+			return err
+		}
+		for _, footprint := range footprints {
+			value, ok := m.value(footprint)
+			if !ok {
+				continue
+			}
+			_, err = fmt.Fprintf(w, "%s{team=%s} %d\n", m.name, strconv.Quote(footprint.TeamName), value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
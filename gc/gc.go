@@ -0,0 +1,326 @@
+package gc
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/vchrisr/concourse-broker/archive"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/notify"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// Actions the collector takes on a team.
+const (
+	Marked    = "marked"
+	Waiting   = "waiting"
+	Destroyed = "destroyed"
+	Skipped   = "skipped"
+)
+
+// Action is what a collector run did, or would have done in dry-run mode, to a team.
+type Action struct {
+	TeamName string `json:"team"`
+	Action   string `json:"action"`
+	Detail   string `json:"detail"`
+}
+
+// Report is the outcome of a collector run.
+type Report struct {
+	Time    time.Time `json:"time"`
+	DryRun  bool      `json:"dry_run"`
+	Actions []Action  `json:"actions"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Collector destroys teams the broker created once no live service instance has referred to them
+// for a grace period. Their pipeline configs are archived first.
+type Collector struct {
+	store           store.Store
+	lock            sync.Locker
+	auditLog        audit.Log
+	notifier        notify.Notifier
+	archiver        *archive.Archiver
+	newCFClient     func() (cf.Client, error)
+	concourseClient concourse.Client
+	logger          lager.Logger
+	env             config.Env
+	now             func() time.Time
+
+	mu   sync.Mutex
+	last Report
+}
+
+// New returns a collector configured by the GC_* settings in env.
+func New(s store.Store, lock sync.Locker, auditLog audit.Log, notifier notify.Notifier, archiver *archive.Archiver,
+	newCFClient func() (cf.Client, error), concourseClient concourse.Client, logger lager.Logger, env config.Env) *Collector {
+	return &Collector{
+		store:           s,
+		lock:            lock,
+		auditLog:        auditLog,
+		notifier:        notifier,
+		archiver:        archiver,
+		newCFClient:     newCFClient,
+		concourseClient: concourseClient,
+		logger:          logger.Session("gc"),
+		env:             env,
+		now:             time.Now,
+	}
+}
+
+// Run collects once.
+func (c *Collector) Run() (Report, error) {
+	report := Report{Time: c.now().UTC(), DryRun: c.env.GCDryRun, Actions: []Action{}}
+	err := c.run(&report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	for _, action := range report.Actions {
+		c.logger.Info(action.Action, lager.Data{
+			"team-name": action.TeamName,
+			"detail":    action.Detail,
+			"dry-run":   report.DryRun,
+		})
+	}
+	c.mu.Lock()
+	c.last = report
+	c.mu.Unlock()
+	return report, err
+}
+
+// LastReport returns the report of the most recent run.
+func (c *Collector) LastReport() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+func (c *Collector) run(report *Report) error {
+	cfClient, err := c.newCFClient()
+	if err != nil {
+		return err
+	}
+	teams, instanceIDs, err := c.snapshot()
+	if err != nil {
+		return err
+	}
+	// CF is asked without the lock, instances provisioned meanwhile are not in exists and count as live
+	exists := map[string]bool{}
+	for _, instanceID := range instanceIDs {
+		exists[instanceID], err = cfClient.InstanceExists(instanceID)
+		if err != nil {
+			return err
+		}
+	}
+	var lastErr error
+	for _, team := range teams {
+		action, err := c.collect(team.Name, exists)
+		if err != nil {
+			c.logger.Error("collect-error", err, lager.Data{"team-name": team.Name})
+			lastErr = err
+			continue
+		}
+		if action != nil {
+			report.Actions = append(report.Actions, *action)
+		}
+	}
+	return lastErr
+}
+
+// snapshot returns the teams and the IDs of the instances that refer to them.
+func (c *Collector) snapshot() ([]store.Team, []string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	teams, err := store.ListTeams(c.store)
+	if err != nil {
+		return nil, nil, err
+	}
+	instanceIDs := []string{}
+	for _, team := range teams {
+		instances, err := store.TeamInstances(c.store, team.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, instance := range instances {
+			instanceIDs = append(instanceIDs, instance.ID)
+		}
+		pipelineInstances, err := store.TeamPipelineInstances(c.store, team.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, instance := range pipelineInstances {
+			instanceIDs = append(instanceIDs, instance.ID)
+		}
+	}
+	return teams, instanceIDs, nil
+}
+
+// collect reads the team and its instances again under the lock, so it only destroys a team
+// no instance was added to since CF was asked.
+func (c *Collector) collect(teamName string, exists map[string]bool) (*Action, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	team, found, err := store.GetTeam(c.store, teamName)
+	if err != nil || !found {
+		return nil, err
+	}
+	if concourse.IsProtected(c.env, team.Name) || c.skipped(team.Name) {
+		return &Action{TeamName: team.Name, Action: Skipped, Detail: "the team is protected or on GC_SKIP_TEAMS"}, nil
+	}
+	deletion, deleted, err := store.GetDeletion(c.store, team.Name)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return &Action{TeamName: team.Name, Action: Skipped,
+			Detail: fmt.Sprintf("the team is soft deleted and will be destroyed after %s",
+				deletion.DeleteAfter.Format(time.RFC3339))}, nil
+	}
+	instances, err := store.TeamInstances(c.store, team.Name)
+	if err != nil {
+		return nil, err
+	}
+	dead := []store.Instance{}
+	for _, instance := range instances {
+		if live, checked := exists[instance.ID]; checked && !live {
+			dead = append(dead, instance)
+		}
+	}
+	// deprovisioning refuses to destroy a team with pipeline instances, so a live one keeps it too
+	pipelineInstances, err := store.TeamPipelineInstances(c.store, team.Name)
+	if err != nil {
+		return nil, err
+	}
+	deadPipelines := []store.PipelineInstance{}
+	for _, instance := range pipelineInstances {
+		if live, checked := exists[instance.ID]; checked && !live {
+			deadPipelines = append(deadPipelines, instance)
+		}
+	}
+	if len(dead) < len(instances) || len(deadPipelines) < len(pipelineInstances) {
+		if team.OrphanedAt != nil && !c.env.GCDryRun {
+			team.OrphanedAt = nil
+			return nil, store.SaveTeam(c.store, team)
+		}
+		return nil, nil
+	}
+
+	now := c.now().UTC()
+	if team.OrphanedAt == nil {
+		deadline := now.Add(c.env.GCGracePeriod)
+		action := &Action{TeamName: team.Name, Action: Marked,
+			Detail: fmt.Sprintf("no live service instance, the team will be destroyed after %s", deadline.Format(time.RFC3339))}
+		if c.env.GCDryRun {
+			return action, nil
+		}
+		team.OrphanedAt = &now
+		err = store.SaveTeam(c.store, team)
+		if err != nil {
+			return nil, err
+		}
+		c.notify("team-orphaned", team.Name, action.Detail)
+		return action, nil
+	}
+
+	deadline := team.OrphanedAt.Add(c.env.GCGracePeriod)
+	if now.Before(deadline) {
+		return &Action{TeamName: team.Name, Action: Waiting,
+			Detail: fmt.Sprintf("orphaned since %s, the team will be destroyed after %s",
+				team.OrphanedAt.Format(time.RFC3339), deadline.Format(time.RFC3339))}, nil
+	}
+	action := &Action{TeamName: team.Name, Action: Destroyed,
+		Detail: fmt.Sprintf("orphaned since %s", team.OrphanedAt.Format(time.RFC3339))}
+	if c.env.GCDryRun {
+		return action, nil
+	}
+	err = c.destroy(team, dead, deadPipelines, action)
+	c.record(team.Name, err)
+	if err != nil {
+		return nil, err
+	}
+	c.notify("team-destroyed", team.Name, action.Detail)
+	return action, nil
+}
+
+func (c *Collector) destroy(team store.Team, dead []store.Instance, deadPipelines []store.PipelineInstance, action *Action) error {
+	instanceIDs := []string{}
+	for _, instance := range dead {
+		instanceIDs = append(instanceIDs, instance.ID)
+	}
+	teamConfig := c.concourseClient.TeamConfig(store.SpaceGUIDs(dead))
+	record, err := c.archiver.Archive(c.concourseClient, team.Name, teamConfig, instanceIDs, "garbage collected")
+	if err != nil {
+		return err
+	}
+	action.Detail += fmt.Sprintf(", pipelines archived as %s", record.ID)
+	err = c.concourseClient.DestroyTeam(team.Name)
+	if err != nil {
+		return err
+	}
+	for _, instance := range dead {
+		err = store.DeleteInstance(c.store, instance.ID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, instance := range deadPipelines {
+		err = store.DeletePipelineInstance(c.store, instance.ID)
+		if err != nil {
+			return err
+		}
+	}
+	err = store.DeleteClone(c.store, team.Name)
+	if err != nil {
+		return err
+	}
+	err = store.DeleteSuspension(c.store, team.Name)
+	if err != nil {
+		return err
+	}
+	return store.DeleteTeam(c.store, team.Name)
+}
+
+func (c *Collector) skipped(teamName string) bool {
+	for _, pattern := range c.env.GCSkipTeams {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, teamName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Collector) notify(event, teamName, message string) {
+	err := c.notifier.Notify(notify.Notification{Time: c.now().UTC(), Event: event, TeamName: teamName, Message: message})
+	if err != nil {
+		c.logger.Error("notify-error", err, lager.Data{"team-name": teamName})
+	}
+}
+
+func (c *Collector) record(teamName string, err error) {
+	entry := audit.Entry{
+		Time:      c.now().UTC(),
+		Operation: "gc-destroy-team",
+		TeamName:  teamName,
+		Caller:    "broker",
+		Outcome:   audit.Succeeded,
+	}
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+	}
+	auditErr := c.auditLog.Record(entry)
+	if auditErr != nil {
+		c.logger.Error("audit-error", auditErr)
+	}
+}
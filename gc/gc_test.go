@@ -0,0 +1,228 @@
+package gc
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/archive"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// provisioningCFClient provisions an instance while the collector asks CF about the others.
+type provisioningCFClient struct {
+	*fakes.CFClient
+	provision func()
+}
+
+func (c provisioningCFClient) InstanceExists(serviceGUID string) (bool, error) {
+	c.provision()
+	return c.CFClient.InstanceExists(serviceGUID)
+}
+
+var _ = Describe("Collector", func() {
+	var (
+		brokerStore     store.Store
+		auditLog        audit.Log
+		notifier        *fakes.Notifier
+		cfClient        *fakes.CFClient
+		concourseClient *fakes.ConcourseClient
+		archiveDir      string
+		env             config.Env
+		now             time.Time
+		details         = cf.Details{OrgGUID: "org-guid", OrgName: "venture", SpaceGUID: "space-a", SpaceName: "dev"}
+	)
+
+	newCollector := func() *Collector {
+		collector := New(brokerStore, &sync.Mutex{}, auditLog, notifier, archive.NewArchiver(archive.NewDir(archiveDir), brokerStore),
+			func() (cf.Client, error) { return cfClient, nil }, concourseClient, lagertest.NewTestLogger("gc"), env)
+		collector.now = func() time.Time { return now }
+		return collector
+	}
+
+	orphanedAt := func() *time.Time {
+		team, _, err := store.GetTeam(brokerStore, "venture")
+		Expect(err).NotTo(HaveOccurred())
+		return team.OrphanedAt
+	}
+
+	BeforeEach(func() {
+		var err error
+		archiveDir, err = ioutil.TempDir("", "gc")
+		Expect(err).NotTo(HaveOccurred())
+		brokerStore = store.NewMemoryStore()
+		auditLog = audit.NewStoreLog(brokerStore)
+		notifier = &fakes.Notifier{}
+		cfClient = fakes.NewCFClient()
+		concourseClient = fakes.NewConcourseClient()
+		env = config.Env{GCGracePeriod: 24 * time.Hour}
+		now = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+		Expect(concourseClient.CreateTeam(details)).To(Succeed())
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "deploy"}, atc.Config{})
+		Expect(store.SaveTeam(brokerStore, store.Team{Name: "venture", CreatedBy: "instance-1"})).To(Succeed())
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-1", OrgName: "venture",
+			SpaceGUID: "space-a", TeamName: "venture"})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(archiveDir)
+	})
+
+	It("leaves teams with a live service instance alone", func() {
+		cfClient.AddInstance("instance-1", details)
+		report, err := newCollector().Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Actions).To(BeEmpty())
+		Expect(orphanedAt()).To(BeNil())
+	})
+
+	Context("when the service instance is gone", func() {
+		It("marks the team and notifies", func() {
+			report, err := newCollector().Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Actions).To(HaveLen(1))
+			Expect(report.Actions[0].Action).To(Equal(Marked))
+			Expect(*orphanedAt()).To(Equal(now))
+			Expect(notifier.Notifications).To(HaveLen(1))
+			Expect(notifier.Notifications[0].Event).To(Equal("team-orphaned"))
+			Expect(concourseClient.Teams).To(HaveKey("venture"))
+		})
+
+		It("waits for the grace period", func() {
+			collector := newCollector()
+			_, err := collector.Run()
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(time.Hour)
+			report, err := collector.Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Actions[0].Action).To(Equal(Waiting))
+			Expect(concourseClient.Teams).To(HaveKey("venture"))
+		})
+
+		It("archives and destroys the team after the grace period", func() {
+			collector := newCollector()
+			_, err := collector.Run()
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(25 * time.Hour)
+			report, err := collector.Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Actions[0].Action).To(Equal(Destroyed))
+			Expect(concourseClient.Destroyed).To(Equal([]string{"venture"}))
+
+			_, found, err := store.GetTeam(brokerStore, "venture")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+			_, found, err = store.GetInstance(brokerStore, "instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+
+			archives, err := store.FindArchives(brokerStore, "venture", "instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(archives).To(HaveLen(1))
+			Expect(archives[0].Pipelines).To(Equal(1))
+
+			entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Operation).To(Equal("gc-destroy-team"))
+			Expect(notifier.Notifications[1].Event).To(Equal("team-destroyed"))
+		})
+
+		It("keeps the team when an instance is provisioned into it while CF is asked", func() {
+			collector := newCollector()
+			_, err := collector.Run()
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(25 * time.Hour)
+
+			lock := &sync.Mutex{}
+			collector.lock = lock
+			provisioned := false
+			collector.newCFClient = func() (cf.Client, error) {
+				return provisioningCFClient{CFClient: cfClient, provision: func() {
+					if provisioned {
+						return
+					}
+					provisioned = true
+					lock.Lock()
+					defer lock.Unlock()
+					Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-2", OrgName: "venture",
+						SpaceGUID: "space-b", TeamName: "venture"})).To(Succeed())
+				}}, nil
+			}
+			report, err := collector.Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Actions).To(BeEmpty())
+			Expect(concourseClient.Destroyed).To(BeEmpty())
+			Expect(orphanedAt()).To(BeNil())
+		})
+
+		It("keeps the team while a live pipeline instance refers to it", func() {
+			Expect(store.SavePipelineInstance(brokerStore, store.PipelineInstance{ID: "pipeline-1", TeamName: "venture",
+				PipelineName: "deploy"})).To(Succeed())
+			cfClient.AddInstance("pipeline-1", details)
+			report, err := newCollector().Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Actions).To(BeEmpty())
+			Expect(orphanedAt()).To(BeNil())
+		})
+
+		It("forgets dead pipeline instances with the team", func() {
+			Expect(store.SavePipelineInstance(brokerStore, store.PipelineInstance{ID: "pipeline-1", TeamName: "venture",
+				PipelineName: "deploy"})).To(Succeed())
+			collector := newCollector()
+			_, err := collector.Run()
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(25 * time.Hour)
+			report, err := collector.Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Actions[0].Action).To(Equal(Destroyed))
+			_, found, _ := store.GetPipelineInstance(brokerStore, "pipeline-1")
+			Expect(found).To(BeFalse())
+		})
+
+		It("clears the mark when an instance comes back", func() {
+			collector := newCollector()
+			_, err := collector.Run()
+			Expect(err).NotTo(HaveOccurred())
+			cfClient.AddInstance("instance-1", details)
+			_, err = collector.Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(orphanedAt()).To(BeNil())
+		})
+
+		It("changes nothing in dry-run mode", func() {
+			env.GCDryRun = true
+			report, err := newCollector().Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.DryRun).To(BeTrue())
+			Expect(report.Actions[0].Action).To(Equal(Marked))
+			Expect(orphanedAt()).To(BeNil())
+			Expect(notifier.Notifications).To(BeEmpty())
+		})
+
+		It("skips teams on GC_SKIP_TEAMS", func() {
+			env.GCSkipTeams = []string{"vent*"}
+			report, err := newCollector().Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Actions[0].Action).To(Equal(Skipped))
+			Expect(orphanedAt()).To(BeNil())
+		})
+
+		It("leaves soft deleted teams to the soft delete schedule", func() {
+			Expect(store.SaveDeletion(brokerStore, store.Deletion{TeamName: "venture", DeleteAfter: now.Add(time.Hour)})).To(Succeed())
+			report, err := newCollector().Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Actions[0].Action).To(Equal(Skipped))
+			Expect(orphanedAt()).To(BeNil())
+		})
+	})
+})
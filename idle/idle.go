@@ -0,0 +1,402 @@
+package idle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/notify"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// Actions applied to a team that turns idle.
+const (
+	None  = "none"
+	Pause = "pause"
+)
+
+// Team is what a detector run found for a team.
+type Team struct {
+	TeamName     string     `json:"team"`
+	LastActiveAt time.Time  `json:"last_active_at"`
+	IdleSince    *time.Time `json:"idle_since,omitempty"`
+	// Paused are the pipelines the idle action paused
+	Paused []string `json:"paused,omitempty"`
+}
+
+// Report is the outcome of a detector run.
+type Report struct {
+	Time  time.Time `json:"time"`
+	Teams []Team    `json:"teams"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Detector finds the teams the broker created that showed no activity for IDLE_AFTER: no builds,
+// no pipeline config changes and no new spaces. Concourse does not tell when somebody logs in, so
+// logins only count when they lead to one of those. The instances of an idle team are marked idle,
+// the team is notified and the IDLE_ACTION is applied. A team turns active again with its next
+// activity.
+type Detector struct {
+	store           store.Store
+	lock            sync.Locker
+	auditLog        audit.Log
+	notifier        notify.Notifier
+	concourseClient concourse.Client
+	logger          lager.Logger
+	idleAfter       time.Duration
+	action          string
+	now             func() time.Time
+
+	mu   sync.Mutex
+	last Report
+}
+
+// New returns a detector.
+func New(s store.Store, lock sync.Locker, auditLog audit.Log, notifier notify.Notifier,
+	concourseClient concourse.Client, logger lager.Logger, env config.Env) (*Detector, error) {
+	if env.IdleAction != None && env.IdleAction != Pause {
+		return nil, fmt.Errorf("Unknown idle action %s. Available idle actions are: none and pause", env.IdleAction)
+	}
+	return &Detector{
+		store:           s,
+		lock:            lock,
+		auditLog:        auditLog,
+		notifier:        notifier,
+		concourseClient: concourseClient,
+		logger:          logger.Session("idle"),
+		idleAfter:       env.IdleAfter,
+		action:          env.IdleAction,
+		now:             time.Now,
+	}, nil
+}
+
+// Run checks every team once.
+func (d *Detector) Run() (Report, error) {
+	report := Report{Time: d.now().UTC(), Teams: []Team{}}
+	err := d.run(&report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	d.mu.Lock()
+	d.last = report
+	d.mu.Unlock()
+	return report, err
+}
+
+// LastReport returns the report of the most recent run.
+func (d *Detector) LastReport() Report {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.last
+}
+
+func (d *Detector) run(report *Report) error {
+	if d.idleAfter == 0 {
+		return nil
+	}
+	teams, err := d.teams()
+	if err != nil {
+		return err
+	}
+	sort.Sort(byName(teams))
+	var lastErr error
+	for _, team := range teams {
+		result, checked, err := d.check(team)
+		if err != nil {
+			d.logger.Error("check-error", err, lager.Data{"team-name": team.Name})
+			lastErr = err
+			continue
+		}
+		if checked {
+			report.Teams = append(report.Teams, result)
+		}
+	}
+	err = d.dropActivity()
+	if err != nil {
+		return err
+	}
+	return lastErr
+}
+
+// teams returns the teams under the lock. The builds of their jobs are paged without it.
+func (d *Detector) teams() ([]store.Team, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return store.ListTeams(d.store)
+}
+
+// dropActivity deletes the activity of destroyed teams.
+func (d *Detector) dropActivity() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	activities, err := store.ListActivity(d.store)
+	if err != nil {
+		return err
+	}
+	for _, activity := range activities {
+		_, found, err := store.GetTeam(d.store, activity.TeamName)
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+		err = store.DeleteActivity(d.store, activity.TeamName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skip tells whether the team is soft deleted or suspended, its pipelines are paused on purpose.
+func (d *Detector) skip(teamName string) (bool, error) {
+	_, deleted, err := store.GetDeletion(d.store, teamName)
+	if err != nil || deleted {
+		return deleted, err
+	}
+	_, suspended, err := store.GetSuspension(d.store, teamName)
+	return suspended, err
+}
+
+// read returns the activity and instances of a team under the lock, and whether the team is to be
+// skipped.
+func (d *Detector) read(team store.Team) (store.Activity, bool, []store.Instance, bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	skip, err := d.skip(team.Name)
+	if err != nil || skip {
+		return store.Activity{}, false, nil, skip, err
+	}
+	activity, found, err := store.GetActivity(d.store, team.Name)
+	if err != nil {
+		return store.Activity{}, false, nil, false, err
+	}
+	instances, err := store.TeamInstances(d.store, team.Name)
+	return activity, found, instances, false, err
+}
+
+func (d *Detector) check(team store.Team) (Team, bool, error) {
+	now := d.now().UTC()
+	activity, found, instances, skip, err := d.read(team)
+	if err != nil || skip {
+		return Team{}, false, err
+	}
+	checkedAt := activity.CheckedAt
+	if !found {
+		activity = store.Activity{TeamName: team.Name, LastActiveAt: team.CreatedAt}
+	}
+	if activity.Builds == nil {
+		activity.Builds = map[string]int{}
+	}
+	for _, instance := range instances {
+		activity.LastActiveAt = latest(activity.LastActiveAt, instance.CreatedAt)
+	}
+	pipelines, err := d.concourseClient.ListPipelines(team.Name)
+	if err != nil {
+		return Team{}, false, err
+	}
+	versions := map[string]string{}
+	for _, pipeline := range pipelines {
+		pipelineConfig, _, version, found, err := d.concourseClient.PipelineConfig(team.Name, pipeline.Name)
+		if err != nil {
+			return Team{}, false, err
+		}
+		if !found {
+			// deleted while checking
+			continue
+		}
+		versions[pipeline.Name] = version
+		for _, job := range pipelineConfig.Jobs {
+			key := pipeline.Name + "/" + job.Name
+			builds, err := d.concourseClient.JobBuilds(team.Name, pipeline.Name, job.Name, activity.Builds[key])
+			if err != nil {
+				return Team{}, false, err
+			}
+			for _, build := range builds {
+				if build.ID > activity.Builds[key] {
+					activity.Builds[key] = build.ID
+				}
+				activity.LastActiveAt = latest(activity.LastActiveAt, time.Unix(build.StartTime, 0).UTC(), time.Unix(build.EndTime, 0).UTC())
+			}
+		}
+	}
+	// the first check only learns the versions, it cannot tell when they changed
+	if found && changed(activity.Versions, versions) {
+		activity.LastActiveAt = now
+	}
+	activity.Versions = versions
+	activity.CheckedAt = now
+
+	idle := now.Sub(activity.LastActiveAt) >= d.idleAfter
+	turned := ""
+	switch {
+	case idle && activity.IdleSince == nil:
+		activity.IdleSince = &now
+		turned = "team-idle"
+	case !idle && activity.IdleSince != nil:
+		activity.IdleSince = nil
+		activity.Paused = nil
+		turned = "team-active"
+	}
+	message, saved, err := d.save(&activity, found, checkedAt, turned, pipelines)
+	if err != nil || !saved {
+		return Team{}, false, err
+	}
+	if message != "" {
+		d.notify(turned, team.Name, message)
+	}
+	return Team{TeamName: team.Name, LastActiveAt: activity.LastActiveAt, IdleSince: activity.IdleSince, Paused: activity.Paused}, true, nil
+}
+
+// save marks the team idle or active and saves its activity under the lock, and returns the
+// message to notify the team with. A team that was destroyed, soft deleted or suspended while it was
+// checked, or that another run checked meanwhile, is left alone.
+func (d *Detector) save(activity *store.Activity, found bool, checkedAt time.Time, turned string, pipelines []atc.Pipeline) (string, bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, exists, err := store.GetTeam(d.store, activity.TeamName)
+	if err != nil || !exists {
+		return "", false, err
+	}
+	skip, err := d.skip(activity.TeamName)
+	if err != nil || skip {
+		return "", false, err
+	}
+	current, stillFound, err := store.GetActivity(d.store, activity.TeamName)
+	if err != nil {
+		return "", false, err
+	}
+	if stillFound != found || !current.CheckedAt.Equal(checkedAt) {
+		return "", false, nil
+	}
+	instances, err := store.TeamInstances(d.store, activity.TeamName)
+	if err != nil {
+		return "", false, err
+	}
+	message := ""
+	switch turned {
+	case "team-idle":
+		message, err = d.markIdle(activity, instances, pipelines)
+	case "team-active":
+		message, err = d.markActive(*activity, instances)
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return message, true, store.SaveActivity(d.store, *activity)
+}
+
+func (d *Detector) markIdle(activity *store.Activity, instances []store.Instance, pipelines []atc.Pipeline) (string, error) {
+	err := d.markInstances(instances, activity.IdleSince)
+	d.record("mark-idle", activity.TeamName, fmt.Sprintf("no activity since %s", activity.LastActiveAt.Format(time.RFC3339)), err)
+	if err != nil {
+		return "", err
+	}
+	d.logger.Info("mark-idle", lager.Data{"team-name": activity.TeamName, "last-active-at": activity.LastActiveAt})
+	message := fmt.Sprintf("the team has had no builds, pipeline changes or new spaces since %s", activity.LastActiveAt.Format("2006-01-02"))
+	if d.action == Pause {
+		activity.Paused = []string{}
+		for _, pipeline := range pipelines {
+			if pipeline.Paused {
+				continue
+			}
+			err = d.concourseClient.PausePipeline(activity.TeamName, pipeline.Name)
+			if err != nil {
+				break
+			}
+			activity.Paused = append(activity.Paused, pipeline.Name)
+		}
+		d.record("idle-pause-pipelines", activity.TeamName, strings.Join(activity.Paused, ", "), err)
+		if err != nil {
+			return "", err
+		}
+		if len(activity.Paused) > 0 {
+			message += fmt.Sprintf(", paused pipelines %s", strings.Join(activity.Paused, ", "))
+		}
+	}
+	return message, nil
+}
+
+func (d *Detector) markActive(activity store.Activity, instances []store.Instance) (string, error) {
+	err := d.markInstances(instances, nil)
+	d.record("mark-active", activity.TeamName, "", err)
+	if err != nil {
+		return "", err
+	}
+	d.logger.Info("mark-active", lager.Data{"team-name": activity.TeamName})
+	return "the team is active again", nil
+}
+
+// markInstances sets when the instances of a team turned idle. It is called under the lock.
+func (d *Detector) markInstances(instances []store.Instance, idleSince *time.Time) error {
+	for _, instance := range instances {
+		instance.IdleSince = idleSince
+		err := store.SaveInstance(d.store, instance)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Detector) notify(event, teamName, message string) {
+	err := d.notifier.Notify(notify.Notification{Time: d.now().UTC(), Event: event, TeamName: teamName, Message: message})
+	if err != nil {
+		d.logger.Error("notify-error", err, lager.Data{"team-name": teamName})
+	}
+}
+
+func (d *Detector) record(operation, teamName, detail string, err error) {
+	entry := audit.Entry{
+		Time:      d.now().UTC(),
+		Operation: operation,
+		TeamName:  teamName,
+		Caller:    "broker",
+		Outcome:   audit.Succeeded,
+		Detail:    detail,
+	}
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+	}
+	auditErr := d.auditLog.Record(entry)
+	if auditErr != nil {
+		d.logger.Error("audit-error", auditErr)
+	}
+}
+
+// changed tells whether a pipeline was added, removed or had its config changed.
+func changed(before, after map[string]string) bool {
+	if len(before) != len(after) {
+		return true
+	}
+	for name, version := range after {
+		if before[name] != version {
+			return true
+		}
+	}
+	return false
+}
+
+func latest(t time.Time, others ...time.Time) time.Time {
+	for _, other := range others {
+		if other.After(t) {
+			t = other
+		}
+	}
+	return t
+}
+
+type byName []store.Team
+
+func (t byName) Len() int           { return len(t) }
+func (t byName) Less(i, j int) bool { return t[i].Name < t[j].Name }
+func (t byName) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
@@ -0,0 +1,13 @@
+package idle
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestIdle(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Idle Suite")
+}
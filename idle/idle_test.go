@@ -0,0 +1,129 @@
+package idle
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Detector", func() {
+	var (
+		brokerStore     store.Store
+		auditLog        audit.Log
+		notifier        *fakes.Notifier
+		concourseClient *fakes.ConcourseClient
+		env             config.Env
+		now, created    time.Time
+		deploy          *fakes.Pipeline
+	)
+
+	run := func() Report {
+		detector, err := New(brokerStore, &sync.Mutex{}, auditLog, notifier, concourseClient, lagertest.NewTestLogger("idle"), env)
+		Expect(err).NotTo(HaveOccurred())
+		detector.now = func() time.Time { return now }
+		report, err := detector.Run()
+		Expect(err).NotTo(HaveOccurred())
+		return report
+	}
+
+	idleSince := func() *time.Time {
+		instance, _, err := store.GetInstance(brokerStore, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		return instance.IdleSince
+	}
+
+	BeforeEach(func() {
+		brokerStore = store.NewMemoryStore()
+		auditLog = audit.NewStoreLog(brokerStore)
+		notifier = &fakes.Notifier{}
+		concourseClient = fakes.NewConcourseClient()
+		env = config.Env{IdleAfter: 30 * 24 * time.Hour, IdleAction: Pause}
+		created = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+		now = created.Add(10 * 24 * time.Hour)
+		deploy = concourseClient.AddPipeline("venture", atc.Pipeline{Name: "deploy"}, atc.Config{Jobs: atc.JobConfigs{{Name: "push"}}})
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "old", Paused: true}, atc.Config{})
+		concourseClient.AllBuilds = []atc.Build{{ID: 1, TeamName: "venture", PipelineName: "deploy", JobName: "push",
+			StartTime: created.Add(time.Hour).Unix(), EndTime: created.Add(2 * time.Hour).Unix()}}
+		Expect(store.SaveTeam(brokerStore, store.Team{Name: "venture", CreatedAt: created})).To(Succeed())
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-1", TeamName: "venture", CreatedAt: created})).To(Succeed())
+	})
+
+	It("marks teams without activity idle and pauses their pipelines", func() {
+		report := run()
+		Expect(report.Teams).To(Equal([]Team{{TeamName: "venture", LastActiveAt: created.Add(2 * time.Hour)}}))
+		Expect(idleSince()).To(BeNil())
+
+		now = created.Add(31 * 24 * time.Hour)
+		report = run()
+		Expect(report.Teams[0].IdleSince).To(Equal(&now))
+		Expect(report.Teams[0].Paused).To(Equal([]string{"deploy"}))
+		Expect(deploy.Paused).To(BeTrue())
+		Expect(idleSince()).To(Equal(&now))
+		Expect(notifier.Notifications).To(HaveLen(1))
+		Expect(notifier.Notifications[0].Event).To(Equal("team-idle"))
+		Expect(notifier.Notifications[0].Message).To(Equal("the team has had no builds, pipeline changes or new spaces since 2017-03-01, paused pipelines deploy"))
+		entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Operation).To(Equal("mark-idle"))
+		Expect(entries[1].Operation).To(Equal("idle-pause-pipelines"))
+
+		run()
+		Expect(notifier.Notifications).To(HaveLen(1))
+	})
+
+	It("marks idle teams active again with their next build", func() {
+		now = created.Add(31 * 24 * time.Hour)
+		run()
+		Expect(idleSince()).NotTo(BeNil())
+
+		now = now.Add(time.Hour)
+		concourseClient.AllBuilds = append([]atc.Build{{ID: 2, TeamName: "venture", PipelineName: "deploy", JobName: "push",
+			StartTime: now.Add(-time.Minute).Unix()}}, concourseClient.AllBuilds...)
+		report := run()
+		Expect(report.Teams[0].IdleSince).To(BeNil())
+		Expect(idleSince()).To(BeNil())
+		Expect(notifier.Notifications[1].Event).To(Equal("team-active"))
+	})
+
+	It("counts pipeline config changes as activity", func() {
+		run()
+		now = created.Add(31 * 24 * time.Hour)
+		deploy.Version = "2"
+		report := run()
+		Expect(report.Teams[0].LastActiveAt).To(Equal(now))
+		Expect(idleSince()).To(BeNil())
+	})
+
+	It("does not check suspended teams", func() {
+		Expect(store.SaveSuspension(brokerStore, store.Suspension{TeamName: "venture"})).To(Succeed())
+		now = created.Add(31 * 24 * time.Hour)
+		Expect(run().Teams).To(BeEmpty())
+		Expect(idleSince()).To(BeNil())
+	})
+
+	It("leaves a team alone that was suspended while it was checked", func() {
+		detector, err := New(brokerStore, &sync.Mutex{}, auditLog, notifier, concourseClient, lagertest.NewTestLogger("idle"), env)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.SaveSuspension(brokerStore, store.Suspension{TeamName: "venture"})).To(Succeed())
+		activity := store.Activity{TeamName: "venture", LastActiveAt: created, CheckedAt: now, IdleSince: &now}
+		_, saved, err := detector.save(&activity, false, time.Time{}, "team-idle", []atc.Pipeline{deploy.Pipeline})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(saved).To(BeFalse())
+		Expect(deploy.Paused).To(BeFalse())
+		Expect(idleSince()).To(BeNil())
+	})
+
+	It("refuses unknown actions", func() {
+		env.IdleAction = "delete"
+		_, err := New(brokerStore, &sync.Mutex{}, auditLog, notifier, concourseClient, lagertest.NewTestLogger("idle"), env)
+		Expect(err).To(MatchError(ContainSubstring("Unknown idle action delete")))
+	})
+})
@@ -0,0 +1,28 @@
+package jobs
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// Every runs job in the background every interval, starting one interval from now.
+// A zero interval disables the job. Errors are logged and the job keeps its schedule. A job that
+// works through every team goes on past a team that fails and returns the last error.
+func Every(interval time.Duration, logger lager.Logger, job func() error) {
+	if interval <= 0 {
+		logger.Info("disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			logger.Debug("run")
+			err := job()
+			if err != nil {
+				logger.Error("run-error", err)
+			}
+		}
+	}()
+}
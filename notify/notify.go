@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/vchrisr/concourse-broker/config"
+)
+
+// Notification tells operators or team owners about something the broker did or is about to do.
+type Notification struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	TeamName string    `json:"team"`
+	Message  string    `json:"message"`
+}
+
+// Notifier delivers notifications.
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// New returns a notifier that posts to NOTIFY_WEBHOOK_URL, or one that only logs when it is not set.
+func New(env config.Env, logger lager.Logger) Notifier {
+	if env.NotifyWebhookURL == "" {
+		return NewLogNotifier(logger)
+	}
+	return NewWebhookNotifier(env.NotifyWebhookURL, logger)
+}
+
+// NewLogNotifier returns a notifier that writes notifications to the log.
+func NewLogNotifier(logger lager.Logger) Notifier {
+	return &logNotifier{logger: logger.Session("notify")}
+}
+
+type logNotifier struct {
+	logger lager.Logger
+}
+
+func (n *logNotifier) Notify(notification Notification) error {
+	n.logger.Info(notification.Event, lager.Data{
+		"team-name": notification.TeamName,
+		"message":   notification.Message,
+	})
+	return nil
+}
+
+// NewWebhookNotifier returns a notifier that posts every notification as JSON to url.
+func NewWebhookNotifier(url string, logger lager.Logger) Notifier {
+	return &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger.Session("notify"),
+	}
+}
+
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+	logger lager.Logger
+}
+
+func (n *webhookNotifier) Notify(notification Notification) error {
+	if notification.Time.IsZero() {
+		notification.Time = time.Now().UTC()
+	}
+	buf, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		n.logger.Error("webhook-error", err, lager.Data{"event": notification.Event})
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("Error posting notification: %s", resp.Status)
+		n.logger.Error("webhook-error", err, lager.Data{"event": notification.Event})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package plans
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// Pipeline names a pipeline config to install in a team, read from a file bundled with the broker
+// or from a URL.
+type Pipeline struct {
+	Name    string `json:"name"`
+	File    string `json:"file,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Unpause bool   `json:"unpause,omitempty"`
+}
+
+// Plan holds what the broker does for instances of a plan on top of creating the team.
+type Plan struct {
+	// TemplateTeam is a team whose pipelines are copied into new teams
+	TemplateTeam string                 `json:"template_team,omitempty"`
+	Pipelines    []Pipeline             `json:"pipelines,omitempty"`
+	Vars         map[string]interface{} `json:"vars,omitempty"`
+	// Suspend pauses every pipeline of the team while an instance is on the plan
+	Suspend bool `json:"suspend,omitempty"`
+	// MaxPipelines and MaxJobs limit the pipelines of the team and their jobs, 0 is unlimited
+	MaxPipelines int `json:"max_pipelines,omitempty"`
+	MaxJobs      int `json:"max_jobs,omitempty"`
+	// MaxContainers and MaxVolumes limit the containers and volumes of the team on the workers, 0 is unlimited
+	MaxContainers int `json:"max_containers,omitempty"`
+	MaxVolumes    int `json:"max_volumes,omitempty"`
+	// Exposure lists the states the pipelines of the team may be in, hidden and exposed. Empty allows both.
+	Exposure []string `json:"exposure,omitempty"`
+	// ExposedPipelines are names or glob patterns of pipelines that must stay exposed
+	ExposedPipelines []string `json:"exposed_pipelines,omitempty"`
+	// WorkerRegistration makes bindings hand out the keys to register workers for the team
+	WorkerRegistration bool `json:"worker_registration,omitempty"`
+}
+
+// Exposure states of a pipeline.
+const (
+	Hidden  = "hidden"
+	Exposed = "exposed"
+)
+
+// Plans are the plan options keyed by plan ID.
+type Plans map[string]Plan
+
+// Get returns the options of a plan, plans without options get the zero Plan.
+func (p Plans) Get(planID string) Plan {
+	return p[planID]
+}
+
+// Load reads the plan options from a JSON file. A missing file means no plan has options.
+func Load(file string) (Plans, error) {
+	buf, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return Plans{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	plans := Plans{}
+	err = json.Unmarshal(buf, &plans)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid plans file %s: %s", file, err)
+	}
+	for planID, plan := range plans {
+		for _, pipeline := range plan.Pipelines {
+			err = pipeline.Validate()
+			if err != nil {
+				return nil, fmt.Errorf("Invalid pipeline in plan %s: %s", planID, err)
+			}
+		}
+		for _, state := range plan.Exposure {
+			if state != Hidden && state != Exposed {
+				return nil, fmt.Errorf("Invalid exposure %s in plan %s. Available exposures are: %s and %s",
+					state, planID, Hidden, Exposed)
+			}
+		}
+		for _, pattern := range plan.ExposedPipelines {
+			_, err = path.Match(pattern, "")
+			if err != nil {
+				return nil, fmt.Errorf("Invalid exposed pipeline %s in plan %s: %s", pattern, planID, err)
+			}
+		}
+	}
+	return plans, nil
+}
+
+// Validate checks that a pipeline names exactly one source.
+func (p Pipeline) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("a pipeline needs a name")
+	}
+	if (p.File == "") == (p.URL == "") {
+		return fmt.Errorf("pipeline %s needs either a file or a url", p.Name)
+	}
+	return nil
+}
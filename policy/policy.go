@@ -0,0 +1,184 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/config"
+)
+
+// Rules a pipeline config can violate.
+const (
+	Privileged   = "privileged"
+	ResourceType = "resource-type"
+	Registry     = "registry"
+	Serial       = "serial"
+)
+
+// dockerHub is the registry of image repositories that do not name one.
+const dockerHub = "docker.io"
+
+// Violation is a part of a pipeline config that breaks a rule.
+type Violation struct {
+	Rule   string `json:"rule"`
+	Detail string `json:"detail"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Rule, v.Detail)
+}
+
+// Rules are the POLICY_* settings pipeline configs are checked against. The zero Rules allow every config.
+type Rules struct {
+	denyPrivileged bool
+	resourceTypes  []string
+	registries     []string
+	serialJobs     []string
+}
+
+// NewRules builds the rules configured in the environment.
+func NewRules(env config.Env) (Rules, error) {
+	rules := Rules{
+		denyPrivileged: env.PolicyDenyPrivileged,
+		resourceTypes:  trimmed(env.PolicyResourceTypes),
+		registries:     trimmed(env.PolicyRegistries),
+		serialJobs:     trimmed(env.PolicySerialJobs),
+	}
+	for _, pattern := range rules.serialJobs {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return Rules{}, fmt.Errorf("Invalid POLICY_SERIAL_JOBS pattern %s: %s", pattern, err)
+		}
+	}
+	return rules, nil
+}
+
+// Enabled tells whether any rule is configured.
+func (r Rules) Enabled() bool {
+	return r.denyPrivileged || len(r.resourceTypes) > 0 || len(r.registries) > 0 || len(r.serialJobs) > 0
+}
+
+// Check returns the violations of a pipeline config.
+func (r Rules) Check(pipelineConfig atc.Config) []Violation {
+	violations := []Violation{}
+	if len(r.resourceTypes) > 0 {
+		for _, resource := range pipelineConfig.Resources {
+			if !contains(r.resourceTypes, resource.Type) {
+				violations = append(violations, Violation{Rule: ResourceType,
+					Detail: fmt.Sprintf("resource %s has type %s, which is not approved", resource.Name, resource.Type)})
+			}
+		}
+		for _, resourceType := range pipelineConfig.ResourceTypes {
+			if !contains(r.resourceTypes, resourceType.Name) {
+				violations = append(violations, Violation{Rule: ResourceType,
+					Detail: fmt.Sprintf("resource type %s is not approved", resourceType.Name)})
+			}
+		}
+	}
+	for _, resourceType := range pipelineConfig.ResourceTypes {
+		violations = append(violations, r.checkImage("resource type "+resourceType.Name, resourceType.Type, resourceType.Source)...)
+	}
+	for _, job := range pipelineConfig.Jobs {
+		if r.requiresSerial(job.Name) && job.MaxInFlight() != 1 {
+			violations = append(violations, Violation{Rule: Serial,
+				Detail: fmt.Sprintf("job %s must be serial", job.Name)})
+		}
+		steps := append(atc.PlanSequence{}, job.Plan...)
+		for _, hook := range []*atc.PlanConfig{job.Failure, job.Ensure, job.Success} {
+			if hook != nil {
+				steps = append(steps, *hook)
+			}
+		}
+		for _, step := range steps {
+			violations = append(violations, r.checkStep(job.Name, step)...)
+		}
+	}
+	return violations
+}
+
+// checkStep checks a step of a job and the steps nested in it. Task configs read from a file at
+// build time are not known to the broker and are not checked.
+func (r Rules) checkStep(jobName string, step atc.PlanConfig) []Violation {
+	violations := []Violation{}
+	if step.Task != "" {
+		if step.Privileged && r.denyPrivileged {
+			violations = append(violations, Violation{Rule: Privileged,
+				Detail: fmt.Sprintf("task %s of job %s runs privileged", step.Task, jobName)})
+		}
+		if step.TaskConfig != nil && step.TaskConfig.ImageResource != nil {
+			image := step.TaskConfig.ImageResource
+			violations = append(violations, r.checkImage(
+				fmt.Sprintf("task %s of job %s", step.Task, jobName), image.Type, image.Source)...)
+		}
+	}
+	nested := []atc.PlanConfig{}
+	for _, sequence := range []*atc.PlanSequence{step.Do, step.Aggregate} {
+		if sequence != nil {
+			nested = append(nested, *sequence...)
+		}
+	}
+	for _, hook := range []*atc.PlanConfig{step.Failure, step.Ensure, step.Success, step.Try} {
+		if hook != nil {
+			nested = append(nested, *hook)
+		}
+	}
+	for _, s := range nested {
+		violations = append(violations, r.checkStep(jobName, s)...)
+	}
+	return violations
+}
+
+// checkImage checks the registry of a container image that is given as a docker image resource.
+func (r Rules) checkImage(what, imageType string, source atc.Source) []Violation {
+	if len(r.registries) == 0 || (imageType != "docker-image" && imageType != "registry-image") {
+		return nil
+	}
+	repository, _ := source["repository"].(string)
+	registry := registryOf(repository)
+	if contains(r.registries, registry) {
+		return nil
+	}
+	return []Violation{{Rule: Registry,
+		Detail: fmt.Sprintf("%s uses image %s from registry %s, which is not allowed", what, repository, registry)}}
+}
+
+func (r Rules) requiresSerial(jobName string) bool {
+	for _, pattern := range r.serialJobs {
+		if ok, _ := path.Match(pattern, jobName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// registryOf returns the registry host of an image repository the way docker reads it: the first
+// part is a host if it has a dot or a port or is localhost.
+func registryOf(repository string) string {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0]
+	}
+	return dockerHub
+}
+
+func trimmed(list []string) []string {
+	result := []string{}
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
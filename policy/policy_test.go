@@ -0,0 +1,207 @@
+package policy
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+	"gopkg.in/yaml.v2"
+)
+
+const pipelineConfig = `
+resource_types:
+- name: slack
+  type: docker-image
+  source: {repository: cfcommunity/slack-notification-resource}
+resources:
+- name: source
+  type: git
+- name: notify
+  type: slack
+jobs:
+- name: build
+  plan:
+  - get: source
+  - task: compile
+    privileged: true
+    config:
+      platform: linux
+      image_resource:
+        type: docker-image
+        source: {repository: registry.example.com/golang}
+- name: deploy-prod
+  plan:
+  - aggregate:
+    - get: source
+  - do:
+    - task: push
+      config:
+        platform: linux
+        image_resource:
+          type: docker-image
+          source: {repository: evil.example.org/cf-cli}
+`
+
+var _ = Describe("Rules", func() {
+	var pipeline atc.Config
+
+	BeforeEach(func() {
+		pipeline = atc.Config{}
+		Expect(yaml.Unmarshal([]byte(pipelineConfig), &pipeline)).To(Succeed())
+	})
+
+	check := func(env config.Env) []Violation {
+		rules, err := NewRules(env)
+		Expect(err).NotTo(HaveOccurred())
+		return rules.Check(pipeline)
+	}
+
+	It("allows everything without rules", func() {
+		Expect(check(config.Env{})).To(BeEmpty())
+	})
+
+	It("finds privileged tasks", func() {
+		Expect(check(config.Env{PolicyDenyPrivileged: true})).To(Equal([]Violation{
+			{Rule: Privileged, Detail: "task compile of job build runs privileged"},
+		}))
+	})
+
+	It("finds resource types that are not approved", func() {
+		Expect(check(config.Env{PolicyResourceTypes: []string{"git", "time"}})).To(Equal([]Violation{
+			{Rule: ResourceType, Detail: "resource notify has type slack, which is not approved"},
+			{Rule: ResourceType, Detail: "resource type slack is not approved"},
+		}))
+	})
+
+	It("finds images from registries that are not allowed", func() {
+		Expect(check(config.Env{PolicyRegistries: []string{"registry.example.com"}})).To(Equal([]Violation{
+			{Rule: Registry, Detail: "resource type slack uses image cfcommunity/slack-notification-resource from registry docker.io, which is not allowed"},
+			{Rule: Registry, Detail: "task push of job deploy-prod uses image evil.example.org/cf-cli from registry evil.example.org, which is not allowed"},
+		}))
+	})
+
+	It("finds jobs that must be serial", func() {
+		Expect(check(config.Env{PolicySerialJobs: []string{"deploy*"}})).To(Equal([]Violation{
+			{Rule: Serial, Detail: "job deploy-prod must be serial"},
+		}))
+		pipeline.Jobs[1].SerialGroups = []string{"deploys"}
+		Expect(check(config.Env{PolicySerialJobs: []string{"deploy*"}})).To(BeEmpty())
+	})
+
+	It("refuses invalid job patterns", func() {
+		_, err := NewRules(config.Env{PolicySerialJobs: []string{"deploy["}})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Worker tags", func() {
+	var pipeline atc.Config
+
+	BeforeEach(func() {
+		pipeline = atc.Config{}
+		Expect(yaml.Unmarshal([]byte(pipelineConfig), &pipeline)).To(Succeed())
+	})
+
+	It("finds everything that is not tagged", func() {
+		pipeline.Resources[0].Tags = atc.Tags{"segment-a"}
+		Expect(CheckTag(pipeline, "segment-a")).To(Equal([]Violation{
+			{Rule: WorkerTag, Detail: "resource notify is not tagged segment-a"},
+			{Rule: WorkerTag, Detail: "resource type slack is not tagged segment-a"},
+			{Rule: WorkerTag, Detail: "step source of job build is not tagged segment-a"},
+			{Rule: WorkerTag, Detail: "step compile of job build is not tagged segment-a"},
+			{Rule: WorkerTag, Detail: "step source of job deploy-prod is not tagged segment-a"},
+			{Rule: WorkerTag, Detail: "step push of job deploy-prod is not tagged segment-a"},
+		}))
+		Expect(CheckTag(pipeline, "")).To(BeEmpty())
+	})
+
+	It("tags everything, nested steps too", func() {
+		Tag(&pipeline, "segment-a")
+		Tag(&pipeline, "segment-a")
+		Expect(CheckTag(pipeline, "segment-a")).To(BeEmpty())
+		Expect(pipeline.Resources[0].Tags).To(Equal(atc.Tags{"segment-a"}))
+		Expect((*pipeline.Jobs[1].Plan[1].Do)[0].Tags).To(Equal(atc.Tags{"segment-a"}))
+	})
+})
+
+var _ = Describe("Scanner", func() {
+	var (
+		brokerStore     store.Store
+		auditLog        audit.Log
+		notifier        *fakes.Notifier
+		concourseClient *fakes.ConcourseClient
+		rules           Rules
+	)
+
+	BeforeEach(func() {
+		brokerStore = store.NewMemoryStore()
+		auditLog = audit.NewStoreLog(brokerStore)
+		notifier = &fakes.Notifier{}
+		concourseClient = fakes.NewConcourseClient()
+		var err error
+		rules, err = NewRules(config.Env{PolicyDenyPrivileged: true})
+		Expect(err).NotTo(HaveOccurred())
+
+		var privileged atc.Config
+		Expect(yaml.Unmarshal([]byte(pipelineConfig), &privileged)).To(Succeed())
+		Expect(concourseClient.CreateTeam(cf.Details{OrgName: "venture", SpaceGUID: "space-a"})).To(Succeed())
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "build"}, privileged)
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "clean"}, atc.Config{})
+		Expect(store.SaveTeam(brokerStore, store.Team{Name: "venture", CreatedBy: "instance-1"})).To(Succeed())
+	})
+
+	It("reports the violations per team", func() {
+		scanner := NewScanner(brokerStore, &sync.Mutex{}, auditLog, notifier, rules, false, concourseClient, lagertest.NewTestLogger("policy"))
+		report, err := scanner.Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Teams).To(HaveLen(1))
+		Expect(report.Teams[0].TeamName).To(Equal("venture"))
+		Expect(report.Teams[0].Pipelines).To(HaveLen(1))
+		Expect(report.Teams[0].Pipelines[0].Pipeline).To(Equal("build"))
+		Expect(report.Teams[0].Pipelines[0].Paused).To(BeFalse())
+		Expect(concourseClient.Pipelines["venture"][0].Paused).To(BeFalse())
+		Expect(notifier.Notifications).To(BeEmpty())
+	})
+
+	It("pauses violating pipelines with auto-pause", func() {
+		scanner := NewScanner(brokerStore, &sync.Mutex{}, auditLog, notifier, rules, true, concourseClient, lagertest.NewTestLogger("policy"))
+		report, err := scanner.Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Teams[0].Pipelines[0].Paused).To(BeTrue())
+		Expect(concourseClient.Pipelines["venture"][0].Paused).To(BeTrue())
+		Expect(concourseClient.Pipelines["venture"][1].Paused).To(BeFalse())
+
+		entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Operation).To(Equal("policy-pause-pipeline"))
+		Expect(entries[0].Detail).To(Equal("build: privileged: task compile of job build runs privileged"))
+		Expect(notifier.Notifications).To(HaveLen(1))
+	})
+
+	It("does not pause pipelines of a team deprovisioned during the scan", func() {
+		scanner := NewScanner(brokerStore, &sync.Mutex{}, auditLog, notifier, rules, true, concourseClient, lagertest.NewTestLogger("policy"))
+		Expect(store.DeleteTeam(brokerStore, "venture")).To(Succeed())
+		paused, err := scanner.pause("venture", "build", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(paused).To(BeFalse())
+		Expect(concourseClient.Pipelines["venture"][0].Paused).To(BeFalse())
+	})
+
+	It("reports untagged pipelines of teams with a worker tag without rules", func() {
+		Expect(store.SaveTeam(brokerStore, store.Team{Name: "venture", CreatedBy: "instance-1", WorkerTag: "segment-a"})).To(Succeed())
+		scanner := NewScanner(brokerStore, &sync.Mutex{}, auditLog, notifier, Rules{}, false, concourseClient, lagertest.NewTestLogger("policy"))
+		report, err := scanner.Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Teams).To(HaveLen(1))
+		Expect(report.Teams[0].Pipelines).To(HaveLen(1))
+		Expect(report.Teams[0].Pipelines[0].Pipeline).To(Equal("build"))
+		Expect(report.Teams[0].Pipelines[0].Violations[0].Rule).To(Equal(WorkerTag))
+	})
+})
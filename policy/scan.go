@@ -0,0 +1,208 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/notify"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// PipelineViolations are the violations of one pipeline.
+type PipelineViolations struct {
+	Pipeline   string      `json:"pipeline"`
+	Violations []Violation `json:"violations"`
+	// Paused is set when the scan paused the pipeline
+	Paused bool `json:"paused,omitempty"`
+}
+
+// TeamViolations are the pipelines of a team that violate the rules.
+type TeamViolations struct {
+	TeamName  string               `json:"team"`
+	Pipelines []PipelineViolations `json:"pipelines"`
+}
+
+// Report is the outcome of a scan.
+type Report struct {
+	Time      time.Time        `json:"time"`
+	AutoPause bool             `json:"auto_pause"`
+	Teams     []TeamViolations `json:"teams"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// Scanner checks the pipelines of every team the broker created against the rules and the worker
+// tag of the team, and pauses the pipelines that violate them when POLICY_AUTO_PAUSE is set.
+type Scanner struct {
+	store           store.Store
+	lock            sync.Locker
+	auditLog        audit.Log
+	notifier        notify.Notifier
+	rules           Rules
+	autoPause       bool
+	concourseClient concourse.Client
+	logger          lager.Logger
+
+	mu   sync.Mutex
+	last Report
+}
+
+// NewScanner returns a scanner.
+func NewScanner(s store.Store, lock sync.Locker, auditLog audit.Log, notifier notify.Notifier, rules Rules,
+	autoPause bool, concourseClient concourse.Client, logger lager.Logger) *Scanner {
+	return &Scanner{
+		store:           s,
+		lock:            lock,
+		auditLog:        auditLog,
+		notifier:        notifier,
+		rules:           rules,
+		autoPause:       autoPause,
+		concourseClient: concourseClient,
+		logger:          logger.Session("policy-scan"),
+	}
+}
+
+// Run scans once.
+func (s *Scanner) Run() (Report, error) {
+	report := Report{Time: time.Now().UTC(), AutoPause: s.autoPause, Teams: []TeamViolations{}}
+	err := s.run(&report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	s.mu.Lock()
+	s.last = report
+	s.mu.Unlock()
+	return report, err
+}
+
+// LastReport returns the report of the most recent scan.
+func (s *Scanner) LastReport() Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+func (s *Scanner) run(report *Report) error {
+	teams, err := s.teams()
+	if err != nil {
+		return err
+	}
+	sort.Sort(byName(teams))
+	var lastErr error
+	for _, team := range teams {
+		if !s.rules.Enabled() && team.WorkerTag == "" {
+			continue
+		}
+		violations, err := s.scan(team)
+		if err != nil {
+			s.logger.Error("scan-error", err, lager.Data{"team-name": team.Name})
+			lastErr = err
+		}
+		if len(violations.Pipelines) > 0 {
+			report.Teams = append(report.Teams, violations)
+		}
+	}
+	return lastErr
+}
+
+// teams returns the teams under the lock. Their pipeline configs are read without it.
+func (s *Scanner) teams() ([]store.Team, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return store.ListTeams(s.store)
+}
+
+func (s *Scanner) scan(team store.Team) (TeamViolations, error) {
+	teamName := team.Name
+	result := TeamViolations{TeamName: teamName, Pipelines: []PipelineViolations{}}
+	pipelines, err := s.concourseClient.ListPipelines(teamName)
+	if err != nil {
+		return result, err
+	}
+	paused := []string{}
+	for _, pipeline := range pipelines {
+		pipelineConfig, _, _, found, err := s.concourseClient.PipelineConfig(teamName, pipeline.Name)
+		if err != nil {
+			return result, err
+		}
+		if !found {
+			// deleted while scanning
+			continue
+		}
+		violations := append(s.rules.Check(pipelineConfig), CheckTag(pipelineConfig, team.WorkerTag)...)
+		if len(violations) == 0 {
+			continue
+		}
+		s.logger.Info("violations", lager.Data{"team-name": teamName, "pipeline-name": pipeline.Name, "violations": violations})
+		pipelineViolations := PipelineViolations{Pipeline: pipeline.Name, Violations: violations}
+		if s.autoPause && !pipeline.Paused {
+			pipelineViolations.Paused, err = s.pause(teamName, pipeline.Name, violations)
+			if err != nil {
+				return result, err
+			}
+			if pipelineViolations.Paused {
+				paused = append(paused, pipeline.Name)
+			}
+		}
+		result.Pipelines = append(result.Pipelines, pipelineViolations)
+	}
+	if len(paused) > 0 {
+		s.notify(teamName, fmt.Sprintf("paused pipelines that violate the pipeline policy: %s", strings.Join(paused, ", ")))
+	}
+	return result, nil
+}
+
+// pause pauses a pipeline in violation under the lock, unless its team was deprovisioned while
+// it was scanned.
+func (s *Scanner) pause(teamName, pipelineName string, violations []Violation) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, found, err := store.GetTeam(s.store, teamName)
+	if err != nil || !found {
+		return false, err
+	}
+	err = s.concourseClient.PausePipeline(teamName, pipelineName)
+	s.record(teamName, pipelineName, violations, err)
+	return err == nil, err
+}
+
+func (s *Scanner) notify(teamName, message string) {
+	err := s.notifier.Notify(notify.Notification{Time: time.Now().UTC(), Event: "policy-violation", TeamName: teamName, Message: message})
+	if err != nil {
+		s.logger.Error("notify-error", err, lager.Data{"team-name": teamName})
+	}
+}
+
+func (s *Scanner) record(teamName, pipelineName string, violations []Violation, err error) {
+	details := []string{}
+	for _, violation := range violations {
+		details = append(details, violation.String())
+	}
+	entry := audit.Entry{
+		Time:      time.Now().UTC(),
+		Operation: "policy-pause-pipeline",
+		TeamName:  teamName,
+		Caller:    "broker",
+		Outcome:   audit.Succeeded,
+		Detail:    fmt.Sprintf("%s: %s", pipelineName, strings.Join(details, "; ")),
+	}
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+	}
+	auditErr := s.auditLog.Record(entry)
+	if auditErr != nil {
+		s.logger.Error("audit-error", auditErr)
+	}
+}
+
+type byName []store.Team
+
+func (t byName) Len() int           { return len(t) }
+func (t byName) Less(i, j int) bool { return t[i].Name < t[j].Name }
+func (t byName) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
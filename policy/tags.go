@@ -0,0 +1,116 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/concourse/atc"
+)
+
+// WorkerTag is the rule that keeps the pipelines of a team on the workers of its isolation segment.
+const WorkerTag = "worker-tag"
+
+// Tag adds the worker tag to every resource, resource type and get, put and task step of a
+// pipeline config, so they run on the workers with the tag only.
+func Tag(pipelineConfig *atc.Config, tag string) {
+	if tag == "" {
+		return
+	}
+	for i := range pipelineConfig.Resources {
+		pipelineConfig.Resources[i].Tags = addTag(pipelineConfig.Resources[i].Tags, tag)
+	}
+	for i := range pipelineConfig.ResourceTypes {
+		pipelineConfig.ResourceTypes[i].Tags = addTag(pipelineConfig.ResourceTypes[i].Tags, tag)
+	}
+	for i := range pipelineConfig.Jobs {
+		job := &pipelineConfig.Jobs[i]
+		for j := range job.Plan {
+			tagStep(&job.Plan[j], tag)
+		}
+		for _, hook := range []*atc.PlanConfig{job.Failure, job.Ensure, job.Success} {
+			if hook != nil {
+				tagStep(hook, tag)
+			}
+		}
+	}
+}
+
+func tagStep(step *atc.PlanConfig, tag string) {
+	if step.Get != "" || step.Put != "" || step.Task != "" {
+		step.Tags = addTag(step.Tags, tag)
+	}
+	for _, sequence := range []*atc.PlanSequence{step.Do, step.Aggregate} {
+		if sequence != nil {
+			for i := range *sequence {
+				tagStep(&(*sequence)[i], tag)
+			}
+		}
+	}
+	for _, hook := range []*atc.PlanConfig{step.Failure, step.Ensure, step.Success, step.Try} {
+		if hook != nil {
+			tagStep(hook, tag)
+		}
+	}
+}
+
+func addTag(tags atc.Tags, tag string) atc.Tags {
+	if contains(tags, tag) {
+		return tags
+	}
+	return append(tags, tag)
+}
+
+// CheckTag returns a violation for every resource, resource type and get, put and task step of a
+// pipeline config that may run on workers without the worker tag.
+func CheckTag(pipelineConfig atc.Config, tag string) []Violation {
+	violations := []Violation{}
+	if tag == "" {
+		return violations
+	}
+	for _, resource := range pipelineConfig.Resources {
+		if !contains(resource.Tags, tag) {
+			violations = append(violations, Violation{Rule: WorkerTag,
+				Detail: fmt.Sprintf("resource %s is not tagged %s", resource.Name, tag)})
+		}
+	}
+	for _, resourceType := range pipelineConfig.ResourceTypes {
+		if !contains(resourceType.Tags, tag) {
+			violations = append(violations, Violation{Rule: WorkerTag,
+				Detail: fmt.Sprintf("resource type %s is not tagged %s", resourceType.Name, tag)})
+		}
+	}
+	for _, job := range pipelineConfig.Jobs {
+		steps := append(atc.PlanSequence{}, job.Plan...)
+		for _, hook := range []*atc.PlanConfig{job.Failure, job.Ensure, job.Success} {
+			if hook != nil {
+				steps = append(steps, *hook)
+			}
+		}
+		for _, step := range steps {
+			violations = append(violations, checkStepTag(job.Name, step, tag)...)
+		}
+	}
+	return violations
+}
+
+func checkStepTag(jobName string, step atc.PlanConfig, tag string) []Violation {
+	violations := []Violation{}
+	if (step.Get != "" || step.Put != "" || step.Task != "") && !contains(step.Tags, tag) {
+		violations = append(violations, Violation{Rule: WorkerTag,
+			Detail: fmt.Sprintf("step %s of job %s is not tagged %s", step.Name(), jobName, tag)})
+	}
+	nested := []atc.PlanConfig{}
+	for _, sequence := range []*atc.PlanSequence{step.Do, step.Aggregate} {
+		if sequence != nil {
+			nested = append(nested, *sequence...)
+		}
+	}
+	for _, hook := range []*atc.PlanConfig{step.Failure, step.Ensure, step.Success, step.Try} {
+		if hook != nil {
+			nested = append(nested, *hook)
+		}
+	}
+	for _, s := range nested {
+		violations = append(violations, checkStepTag(jobName, s, tag)...)
+	}
+	return violations
+}
@@ -0,0 +1,378 @@
+package quota
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/notify"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// States of a team against its limits.
+const (
+	Within   = "within"
+	Warned   = "warned"
+	Enforced = "enforced"
+)
+
+// Usage is what an enforcer run found for a team.
+type Usage struct {
+	store.Quota
+	State string `json:"state"`
+	// Paused are the pipelines beyond the limits that the run paused
+	Paused []string `json:"paused,omitempty"`
+}
+
+// Report is the outcome of an enforcer run.
+type Report struct {
+	Time  time.Time `json:"time"`
+	Teams []Usage   `json:"teams"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Enforcer counts the pipelines and jobs of every team against the limits of its plans. A team
+// over its limits is warned and, after QUOTA_GRACE_PERIOD, its newest pipelines beyond the limits
+// are kept paused.
+type Enforcer struct {
+	store           store.Store
+	lock            sync.Locker
+	auditLog        audit.Log
+	notifier        notify.Notifier
+	plans           plans.Plans
+	concourseClient concourse.Client
+	logger          lager.Logger
+	gracePeriod     time.Duration
+	now             func() time.Time
+
+	mu   sync.Mutex
+	last Report
+}
+
+// New returns an enforcer.
+func New(s store.Store, lock sync.Locker, auditLog audit.Log, notifier notify.Notifier, brokerPlans plans.Plans,
+	concourseClient concourse.Client, logger lager.Logger, env config.Env) *Enforcer {
+	return &Enforcer{
+		store:           s,
+		lock:            lock,
+		auditLog:        auditLog,
+		notifier:        notifier,
+		plans:           brokerPlans,
+		concourseClient: concourseClient,
+		logger:          logger.Session("quota"),
+		gracePeriod:     env.QuotaGracePeriod,
+		now:             time.Now,
+	}
+}
+
+// Run checks every team once.
+func (e *Enforcer) Run() (Report, error) {
+	report := Report{Time: e.now().UTC(), Teams: []Usage{}}
+	err := e.run(&report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	e.mu.Lock()
+	e.last = report
+	e.mu.Unlock()
+	return report, err
+}
+
+// LastReport returns the report of the most recent run.
+func (e *Enforcer) LastReport() Report {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.last
+}
+
+func (e *Enforcer) run(report *Report) error {
+	instances, err := e.instances()
+	if err != nil {
+		return err
+	}
+	teamInstances := map[string][]store.Instance{}
+	for _, instance := range instances {
+		teamInstances[instance.TeamName] = append(teamInstances[instance.TeamName], instance)
+	}
+	var lastErr error
+	for teamName, instances := range teamInstances {
+		maxPipelines, maxJobs := Limits(e.plans, instances)
+		if maxPipelines == 0 && maxJobs == 0 {
+			continue
+		}
+		usage, err := e.check(teamName, maxPipelines, maxJobs)
+		if err != nil {
+			e.logger.Error("check-error", err, lager.Data{"team-name": teamName})
+			lastErr = err
+			continue
+		}
+		report.Teams = append(report.Teams, usage)
+	}
+	sort.Sort(byTeam(report.Teams))
+	err = e.dropQuotas()
+	if err != nil {
+		return err
+	}
+	return lastErr
+}
+
+// instances returns the instances under the lock. The pipelines are counted without it.
+func (e *Enforcer) instances() ([]store.Instance, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return store.ListInstances(e.store)
+}
+
+// dropQuotas forgets the usage of teams that are gone or lost their limits, so it is not shown any more.
+func (e *Enforcer) dropQuotas() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	instances, err := store.ListInstances(e.store)
+	if err != nil {
+		return err
+	}
+	teamInstances := map[string][]store.Instance{}
+	for _, instance := range instances {
+		teamInstances[instance.TeamName] = append(teamInstances[instance.TeamName], instance)
+	}
+	quotas, err := store.ListQuotas(e.store)
+	if err != nil {
+		return err
+	}
+	for _, quota := range quotas {
+		maxPipelines, maxJobs := Limits(e.plans, teamInstances[quota.TeamName])
+		if maxPipelines == 0 && maxJobs == 0 {
+			err = store.DeleteQuota(e.store, quota.TeamName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Limits returns the limits of a team. A team shared by instances of several plans gets the most
+// generous limits among them.
+func Limits(brokerPlans plans.Plans, instances []store.Instance) (int, int) {
+	maxPipelines, maxJobs := 0, 0
+	for i, instance := range instances {
+		plan := brokerPlans.Get(instance.PlanID)
+		if i == 0 || (maxPipelines != 0 && (plan.MaxPipelines == 0 || plan.MaxPipelines > maxPipelines)) {
+			maxPipelines = plan.MaxPipelines
+		}
+		if i == 0 || (maxJobs != 0 && (plan.MaxJobs == 0 || plan.MaxJobs > maxJobs)) {
+			maxJobs = plan.MaxJobs
+		}
+	}
+	return maxPipelines, maxJobs
+}
+
+func (e *Enforcer) check(teamName string, maxPipelines, maxJobs int) (Usage, error) {
+	listed, err := e.pipelines(teamName)
+	if err != nil {
+		return Usage{}, err
+	}
+	var notifications []notify.Notification
+	usage, err := e.apply(teamName, listed, maxPipelines, maxJobs, &notifications)
+	for _, notification := range notifications {
+		e.notify(notification.Event, teamName, notification.Message)
+	}
+	return usage, err
+}
+
+// apply saves the usage of a team and pauses the pipelines beyond its limits once the grace period
+// is over. It holds the lock, and a team that was deprovisioned while its pipelines were counted
+// fails its check.
+func (e *Enforcer) apply(teamName string, listed []pipeline, maxPipelines, maxJobs int,
+	notifications *[]notify.Notification) (Usage, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	instances, err := store.TeamInstances(e.store, teamName)
+	if err != nil {
+		return Usage{}, err
+	}
+	if len(instances) == 0 {
+		return Usage{}, fmt.Errorf("Team %s was deprovisioned while its pipelines were counted", teamName)
+	}
+	now := e.now().UTC()
+	quota, _, err := store.GetQuota(e.store, teamName)
+	if err != nil {
+		return Usage{}, err
+	}
+	pipelines := seen(listed, &quota, now)
+	quota.TeamName = teamName
+	quota.MaxPipelines = maxPipelines
+	quota.MaxJobs = maxJobs
+	quota.CheckedAt = now
+	quota.Pipelines = len(pipelines)
+	quota.Jobs = 0
+	for _, pipeline := range pipelines {
+		quota.Jobs += pipeline.jobs
+	}
+	usage := Usage{State: Within}
+	if !exceeds(quota.Pipelines, maxPipelines) && !exceeds(quota.Jobs, maxJobs) {
+		quota.ExceededAt = nil
+		usage.Quota = quota
+		return usage, store.SaveQuota(e.store, quota)
+	}
+
+	usage.State = Warned
+	if quota.ExceededAt == nil {
+		quota.ExceededAt = &now
+		*notifications = append(*notifications, notify.Notification{Event: "quota-exceeded",
+			Message: fmt.Sprintf("%s, the newest pipelines beyond the limits will be paused after %s",
+				Summary(quota), now.Add(e.gracePeriod).Format(time.RFC3339))})
+	}
+	if !now.Before(quota.ExceededAt.Add(e.gracePeriod)) {
+		usage.State = Enforced
+		usage.Paused, err = e.enforce(teamName, pipelines, maxPipelines, maxJobs)
+		e.record(teamName, usage.Paused, err)
+		if err != nil {
+			return Usage{}, err
+		}
+		if len(usage.Paused) > 0 {
+			*notifications = append(*notifications, notify.Notification{Event: "quota-enforced",
+				Message: fmt.Sprintf("%s, paused %v", Summary(quota), usage.Paused)})
+		}
+	}
+	usage.Quota = quota
+	return usage, store.SaveQuota(e.store, quota)
+}
+
+type pipeline struct {
+	name   string
+	paused bool
+	jobs   int
+	seen   time.Time
+}
+
+// pipelines returns the pipelines of a team in the order Concourse lists them.
+func (e *Enforcer) pipelines(teamName string) ([]pipeline, error) {
+	listed, err := e.concourseClient.ListPipelines(teamName)
+	if err != nil {
+		return nil, err
+	}
+	result := []pipeline{}
+	for _, p := range listed {
+		config, _, _, found, err := e.concourseClient.PipelineConfig(teamName, p.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			// deleted while counting
+			continue
+		}
+		result = append(result, pipeline{name: p.Name, paused: p.Paused, jobs: len(config.Jobs)})
+	}
+	return result, nil
+}
+
+// seen returns the pipelines from the oldest to the newest by when they were first counted, and
+// records that in the quota.
+func seen(pipelines []pipeline, quota *store.Quota, now time.Time) []pipeline {
+	firstSeen := map[string]time.Time{}
+	result := []pipeline{}
+	for _, p := range pipelines {
+		first, ok := quota.Seen[p.name]
+		if !ok {
+			first = now
+		}
+		firstSeen[p.name] = first
+		p.seen = first
+		result = append(result, p)
+	}
+	quota.Seen = firstSeen
+	// pipelines first seen in the same run keep the order Concourse lists them in
+	sort.Stable(oldestFirst(result))
+	return result
+}
+
+// enforce pauses the running pipelines that do not fit within the limits, keeping the oldest ones.
+func (e *Enforcer) enforce(teamName string, pipelines []pipeline, maxPipelines, maxJobs int) ([]string, error) {
+	paused := []string{}
+	count, jobs := 0, 0
+	for _, p := range pipelines {
+		count++
+		jobs += p.jobs
+		if !exceeds(count, maxPipelines) && !exceeds(jobs, maxJobs) {
+			continue
+		}
+		// a pipeline beyond the limits does not use up any of them
+		count--
+		jobs -= p.jobs
+		if p.paused {
+			continue
+		}
+		err := e.concourseClient.PausePipeline(teamName, p.name)
+		if err != nil {
+			return paused, err
+		}
+		paused = append(paused, p.name)
+	}
+	return paused, nil
+}
+
+// Summary returns the usage of a team in words.
+func Summary(quota store.Quota) string {
+	return fmt.Sprintf("%s, %s", amount(quota.Pipelines, quota.MaxPipelines, "pipelines"),
+		amount(quota.Jobs, quota.MaxJobs, "jobs"))
+}
+
+func amount(used, limit int, what string) string {
+	if limit == 0 {
+		return fmt.Sprintf("%d %s", used, what)
+	}
+	return fmt.Sprintf("%d of %d %s", used, limit, what)
+}
+
+func exceeds(used, limit int) bool {
+	return limit > 0 && used > limit
+}
+
+func (e *Enforcer) notify(event, teamName, message string) {
+	err := e.notifier.Notify(notify.Notification{Time: e.now().UTC(), Event: event, TeamName: teamName, Message: message})
+	if err != nil {
+		e.logger.Error("notify-error", err, lager.Data{"team-name": teamName})
+	}
+}
+
+// record audits the pipelines an enforcement paused. Runs that paused nothing are not recorded.
+func (e *Enforcer) record(teamName string, paused []string, err error) {
+	if len(paused) == 0 && err == nil {
+		return
+	}
+	e.logger.Info("enforced", lager.Data{"team-name": teamName, "paused": paused})
+	entry := audit.Entry{
+		Time:      e.now().UTC(),
+		Operation: "quota-pause-pipelines",
+		TeamName:  teamName,
+		Caller:    "broker",
+		Outcome:   audit.Succeeded,
+	}
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+	}
+	auditErr := e.auditLog.Record(entry)
+	if auditErr != nil {
+		e.logger.Error("audit-error", auditErr)
+	}
+}
+
+type oldestFirst []pipeline
+
+func (p oldestFirst) Len() int           { return len(p) }
+func (p oldestFirst) Less(i, j int) bool { return p[i].seen.Before(p[j].seen) }
+func (p oldestFirst) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type byTeam []Usage
+
+func (u byTeam) Len() int           { return len(u) }
+func (u byTeam) Less(i, j int) bool { return u[i].TeamName < u[j].TeamName }
+func (u byTeam) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
@@ -0,0 +1,158 @@
+package quota
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/notify"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Enforcer", func() {
+	var (
+		brokerStore     store.Store
+		auditLog        audit.Log
+		notifier        *fakes.Notifier
+		concourseClient *fakes.ConcourseClient
+		brokerPlans     plans.Plans
+		now             time.Time
+	)
+
+	twoJobs := atc.Config{Jobs: atc.JobConfigs{{Name: "build"}, {Name: "test"}}}
+
+	newEnforcer := func() *Enforcer {
+		enforcer := New(brokerStore, &sync.Mutex{}, auditLog, notifier, brokerPlans, concourseClient,
+			lagertest.NewTestLogger("quota"), config.Env{QuotaGracePeriod: 24 * time.Hour})
+		enforcer.now = func() time.Time { return now }
+		return enforcer
+	}
+
+	paused := func(name string) bool {
+		pipelines, err := concourseClient.ListPipelines("venture")
+		Expect(err).NotTo(HaveOccurred())
+		for _, pipeline := range pipelines {
+			if pipeline.Name == name {
+				return pipeline.Paused
+			}
+		}
+		Fail("no pipeline " + name)
+		return false
+	}
+
+	BeforeEach(func() {
+		brokerStore = store.NewMemoryStore()
+		auditLog = audit.NewStoreLog(brokerStore)
+		notifier = &fakes.Notifier{}
+		concourseClient = fakes.NewConcourseClient()
+		brokerPlans = plans.Plans{"small": {MaxPipelines: 2, MaxJobs: 5}, "large": {MaxPipelines: 10}}
+		now = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+		Expect(concourseClient.CreateTeam(cf.Details{OrgName: "venture", SpaceGUID: "space-a"})).To(Succeed())
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "build"}, twoJobs)
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "deploy"}, twoJobs)
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-1", PlanID: "small", TeamName: "venture"})).To(Succeed())
+	})
+
+	It("reports usage within the limits", func() {
+		report, err := newEnforcer().Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Teams).To(HaveLen(1))
+		Expect(report.Teams[0].State).To(Equal(Within))
+		Expect(report.Teams[0].Pipelines).To(Equal(2))
+		Expect(report.Teams[0].Jobs).To(Equal(4))
+		quota, found, err := store.GetQuota(brokerStore, "venture")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(Summary(quota)).To(Equal("2 of 2 pipelines, 4 of 5 jobs"))
+	})
+
+	It("leaves a team alone that was deprovisioned while its pipelines were counted", func() {
+		enforcer := newEnforcer()
+		listed, err := enforcer.pipelines("venture")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.DeleteInstance(brokerStore, "instance-1")).To(Succeed())
+		_, err = enforcer.apply("venture", listed, 1, 0, &[]notify.Notification{})
+		Expect(err).To(HaveOccurred())
+		_, found, _ := store.GetQuota(brokerStore, "venture")
+		Expect(found).To(BeFalse())
+		Expect(paused("deploy")).To(BeFalse())
+	})
+
+	It("uses the most generous limits of a shared team", func() {
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-2", PlanID: "large", TeamName: "venture"})).To(Succeed())
+		instances, _ := store.TeamInstances(brokerStore, "venture")
+		maxPipelines, maxJobs := Limits(brokerPlans, instances)
+		Expect(maxPipelines).To(Equal(10))
+		Expect(maxJobs).To(Equal(0))
+	})
+
+	Context("when a team goes over its limits", func() {
+		var enforcer *Enforcer
+
+		BeforeEach(func() {
+			enforcer = newEnforcer()
+			_, err := enforcer.Run()
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(time.Minute)
+			concourseClient.AddPipeline("venture", atc.Pipeline{Name: "nightly"}, atc.Config{})
+		})
+
+		It("warns first", func() {
+			report, err := enforcer.Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Teams[0].State).To(Equal(Warned))
+			Expect(paused("nightly")).To(BeFalse())
+			Expect(notifier.Notifications).To(HaveLen(1))
+			Expect(notifier.Notifications[0].Event).To(Equal("quota-exceeded"))
+		})
+
+		It("pauses the newest pipelines beyond the limits after the grace period", func() {
+			_, err := enforcer.Run()
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(25 * time.Hour)
+			report, err := enforcer.Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Teams[0].State).To(Equal(Enforced))
+			Expect(report.Teams[0].Paused).To(Equal([]string{"nightly"}))
+			Expect(paused("nightly")).To(BeTrue())
+			Expect(paused("build")).To(BeFalse())
+			Expect(paused("deploy")).To(BeFalse())
+
+			entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Operation).To(Equal("quota-pause-pipelines"))
+			Expect(notifier.Notifications[1].Event).To(Equal("quota-enforced"))
+		})
+
+		It("clears the warning when the team is back within its limits", func() {
+			_, err := enforcer.Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(concourseClient.DeletePipeline("venture", "nightly")).To(Succeed())
+			report, err := enforcer.Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Teams[0].State).To(Equal(Within))
+			quota, _, _ := store.GetQuota(brokerStore, "venture")
+			Expect(quota.ExceededAt).To(BeNil())
+		})
+	})
+
+	It("forgets the usage of teams without limits", func() {
+		_, err := newEnforcer().Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.DeleteInstance(brokerStore, "instance-1")).To(Succeed())
+		_, err = newEnforcer().Run()
+		Expect(err).NotTo(HaveOccurred())
+		_, found, err := store.GetQuota(brokerStore, "venture")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+	})
+})
@@ -0,0 +1,370 @@
+package reconcile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// Kinds of drift between the broker's records, CF and Concourse.
+const (
+	MissingTeam    = "missing-team"
+	AuthDrift      = "auth-drift"
+	PurgedInstance = "purged-instance"
+	DeletedSpace   = "deleted-space"
+	RenamedOrg     = "renamed-org"
+	OrphanedTeam   = "orphaned-team"
+)
+
+// uaaDisplayName is how Concourse lists the UAA auth method the broker configures.
+const uaaDisplayName = "UAA"
+
+// minInstanceAge is how long an instance CF does not know about is kept. CF records a synchronous
+// provision only once the broker answered it, so a younger instance may just not be recorded yet.
+const minInstanceAge = 10 * time.Minute
+
+// Drift is a single difference found by a reconcile run.
+type Drift struct {
+	Kind       string `json:"kind"`
+	TeamName   string `json:"team"`
+	InstanceID string `json:"instance_id,omitempty"`
+	Detail     string `json:"detail"`
+	Repaired   bool   `json:"repaired"`
+}
+
+// Report is the outcome of a reconcile run.
+type Report struct {
+	Time   time.Time `json:"time"`
+	Repair bool      `json:"repair"`
+	Drifts []Drift   `json:"drifts"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Reconciler compares the broker's records with CF and Concourse and optionally repairs the differences.
+type Reconciler struct {
+	store           store.Store
+	lock            sync.Locker
+	auditLog        audit.Log
+	newCFClient     func() (cf.Client, error)
+	concourseClient concourse.Client
+	logger          lager.Logger
+	repair          bool
+
+	mu   sync.Mutex
+	last Report
+}
+
+// New returns a reconciler. With repair it changes the teams, otherwise it only reports.
+func New(s store.Store, lock sync.Locker, auditLog audit.Log, newCFClient func() (cf.Client, error),
+	concourseClient concourse.Client, logger lager.Logger, repair bool) *Reconciler {
+	return &Reconciler{
+		store:           s,
+		lock:            lock,
+		auditLog:        auditLog,
+		newCFClient:     newCFClient,
+		concourseClient: concourseClient,
+		logger:          logger.Session("reconcile"),
+		repair:          repair,
+	}
+}
+
+// LastReport returns the report of the most recent run.
+func (r *Reconciler) LastReport() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// Run reconciles once and keeps the report for LastReport.
+func (r *Reconciler) Run() (Report, error) {
+	report := Report{Time: time.Now().UTC(), Repair: r.repair, Drifts: []Drift{}}
+	err := r.run(&report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	for _, drift := range report.Drifts {
+		r.logger.Info("drift", lager.Data{
+			"kind":        drift.Kind,
+			"team-name":   drift.TeamName,
+			"instance-id": drift.InstanceID,
+			"detail":      drift.Detail,
+			"repaired":    drift.Repaired,
+		})
+	}
+	r.mu.Lock()
+	r.last = report
+	r.mu.Unlock()
+	return report, err
+}
+
+func (r *Reconciler) run(report *Report) error {
+	cfClient, err := r.newCFClient()
+	if err != nil {
+		return err
+	}
+	instances, teams, err := r.snapshot()
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		drift, orgName, err := r.checkInstance(cfClient, instance)
+		if err != nil {
+			return err
+		}
+		if drift == nil {
+			continue
+		}
+		err = r.repairInstance(instance.ID, drift, orgName, report)
+		if err != nil {
+			return err
+		}
+	}
+
+	concourseTeams, err := r.concourseClient.ListTeams()
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, team := range concourseTeams {
+		existing[team.Name] = true
+	}
+	for _, team := range teams {
+		var authMethods []atc.AuthMethod
+		if existing[team.Name] {
+			authMethods, err = r.concourseClient.ListAuthMethods(team.Name)
+			if err != nil {
+				return err
+			}
+		}
+		err = r.checkTeam(team.Name, existing[team.Name], authMethods, report)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshot returns the instances and teams under the lock. CF and Concourse are read without it, so
+// a slow one does not hold up provisions, and the records are read again before they are changed.
+func (r *Reconciler) snapshot() ([]store.Instance, []store.Team, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	instances, err := store.ListInstances(r.store)
+	if err != nil {
+		return nil, nil, err
+	}
+	teams, err := store.ListTeams(r.store)
+	return instances, teams, err
+}
+
+// checkInstance compares an instance with CF. For a renamed org it also returns the new name.
+func (r *Reconciler) checkInstance(cfClient cf.Client, instance store.Instance) (*Drift, string, error) {
+	exists, err := cfClient.InstanceExists(instance.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !exists {
+		if time.Since(instance.CreatedAt) < minInstanceAge {
+			return nil, "", nil
+		}
+		return &Drift{Kind: PurgedInstance, TeamName: instance.TeamName, InstanceID: instance.ID,
+			Detail: "the service instance no longer exists in CF"}, "", nil
+	}
+	details, found, err := cfClient.GetSpaceDetails(instance.SpaceGUID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !found {
+		return &Drift{Kind: DeletedSpace, TeamName: instance.TeamName, InstanceID: instance.ID,
+			Detail: fmt.Sprintf("space %s no longer exists in CF", instance.SpaceGUID)}, "", nil
+	}
+	if details.OrgName != instance.OrgName {
+		drift := &Drift{Kind: RenamedOrg, TeamName: instance.TeamName, InstanceID: instance.ID,
+			Detail: fmt.Sprintf("org %s was renamed to %s, the team keeps its name", instance.OrgName, details.OrgName)}
+		return drift, details.OrgName, nil
+	}
+	return nil, "", nil
+}
+
+// repairInstance reports the drift of an instance and, with repair, forgets an instance CF no
+// longer knows about or updates the name of its org. The team config is brought in line when the
+// team is checked, a team left without instances is flagged as orphaned. An instance that was
+// deprovisioned meanwhile is left alone.
+func (r *Reconciler) repairInstance(instanceID string, drift *Drift, orgName string, report *Report) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	instance, found, err := store.GetInstance(r.store, instanceID)
+	if err != nil || !found {
+		return err
+	}
+	if r.repair {
+		if drift.Kind == RenamedOrg {
+			instance.OrgName = orgName
+			err = store.SaveInstance(r.store, instance)
+		} else {
+			err = store.DeleteInstance(r.store, instance.ID)
+		}
+		if err != nil {
+			return err
+		}
+		drift.Repaired = true
+		if drift.Kind != RenamedOrg {
+			r.record(*drift, nil)
+		}
+	}
+	report.Drifts = append(report.Drifts, *drift)
+	return nil
+}
+
+// checkTeam compares a team with its records and with what Concourse was found to have. It holds the
+// lock, so the team config it sets cannot undo the change of a provision that came in meanwhile.
+func (r *Reconciler) checkTeam(teamName string, exists bool, authMethods []atc.AuthMethod, report *Report) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	team, found, err := store.GetTeam(r.store, teamName)
+	if err != nil || !found {
+		return err
+	}
+	instances, err := store.TeamInstances(r.store, team.Name)
+	if err != nil {
+		return err
+	}
+	pipelineInstances, err := store.TeamPipelineInstances(r.store, team.Name)
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 && len(pipelineInstances) > 0 {
+		// deprovisioning refuses to destroy the team, so it is not flagged for garbage collection either
+		drift := Drift{Kind: OrphanedTeam, TeamName: team.Name,
+			Detail: fmt.Sprintf("no service instance refers to the team, but %d pipeline instances do", len(pipelineInstances))}
+		if r.repair && team.OrphanedAt != nil {
+			team.OrphanedAt = nil
+			err = store.SaveTeam(r.store, team)
+			if err != nil {
+				return err
+			}
+		}
+		report.Drifts = append(report.Drifts, drift)
+		return nil
+	}
+	if len(instances) == 0 {
+		drift := Drift{Kind: OrphanedTeam, TeamName: team.Name, Detail: "no service instance refers to the team"}
+		if r.repair {
+			if team.OrphanedAt == nil {
+				now := time.Now().UTC()
+				team.OrphanedAt = &now
+				err = store.SaveTeam(r.store, team)
+				if err != nil {
+					return err
+				}
+			}
+			drift.Repaired = true
+		}
+		report.Drifts = append(report.Drifts, drift)
+		return nil
+	}
+	if team.OrphanedAt != nil && r.repair {
+		team.OrphanedAt = nil
+		err = store.SaveTeam(r.store, team)
+		if err != nil {
+			return err
+		}
+	}
+
+	expected := r.concourseClient.TeamConfig(store.SpaceGUIDs(instances))
+	var drift *Drift
+	if !exists {
+		drift = &Drift{Kind: MissingTeam, TeamName: team.Name, Detail: "the team no longer exists in Concourse"}
+	} else if !expectedAuthMethods(authMethods) {
+		drift = &Drift{Kind: AuthDrift, TeamName: team.Name,
+			Detail: fmt.Sprintf("the team has auth methods %s instead of only %s", describe(authMethods), uaaDisplayName)}
+	}
+	if !r.repair {
+		if drift != nil {
+			report.Drifts = append(report.Drifts, *drift)
+		}
+		return nil
+	}
+	// Concourse does not return the config of a team, so it is set again when it drifted or differs
+	// from the config that was set last
+	configDigest, err := digest(expected)
+	if err != nil {
+		return err
+	}
+	if drift == nil && team.ConfigDigest == configDigest {
+		return nil
+	}
+	err = r.concourseClient.SetTeam(team.Name, expected)
+	if drift != nil {
+		drift.Repaired = err == nil
+		r.record(*drift, err)
+		report.Drifts = append(report.Drifts, *drift)
+	}
+	if err != nil {
+		return err
+	}
+	team.ConfigDigest = configDigest
+	return store.SaveTeam(r.store, team)
+}
+
+func (r *Reconciler) record(drift Drift, err error) {
+	entry := audit.Entry{
+		Time:       time.Now().UTC(),
+		Operation:  "reconcile-" + drift.Kind,
+		InstanceID: drift.InstanceID,
+		TeamName:   drift.TeamName,
+		Caller:     "broker",
+		Outcome:    audit.Succeeded,
+	}
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+	}
+	auditErr := r.auditLog.Record(entry)
+	if auditErr != nil {
+		r.logger.Error("audit-error", auditErr)
+	}
+}
+
+func expectedAuthMethods(authMethods []atc.AuthMethod) bool {
+	return len(authMethods) == 1 &&
+		authMethods[0].Type == atc.AuthTypeOAuth &&
+		authMethods[0].DisplayName == uaaDisplayName
+}
+
+func digest(team atc.Team) (string, error) {
+	buf, err := json.Marshal(team)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func describe(authMethods []atc.AuthMethod) string {
+	if len(authMethods) == 0 {
+		return "none"
+	}
+	result := ""
+	for i, method := range authMethods {
+		if i > 0 {
+			result += ", "
+		}
+		name := method.DisplayName
+		if name == "" {
+			name = string(method.Type)
+		}
+		result += name
+	}
+	return result
+}
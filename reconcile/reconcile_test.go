@@ -0,0 +1,185 @@
+package reconcile
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Reconciler", func() {
+	var (
+		brokerStore     store.Store
+		auditLog        audit.Log
+		cfClient        *fakes.CFClient
+		concourseClient *fakes.ConcourseClient
+		details         = cf.Details{OrgGUID: "org-guid", OrgName: "venture", SpaceGUID: "space-a", SpaceName: "dev"}
+	)
+
+	newReconciler := func(repair bool) *Reconciler {
+		return New(brokerStore, &sync.Mutex{}, auditLog, func() (cf.Client, error) { return cfClient, nil },
+			concourseClient, lagertest.NewTestLogger("reconcile"), repair)
+	}
+
+	BeforeEach(func() {
+		brokerStore = store.NewMemoryStore()
+		auditLog = audit.NewStoreLog(brokerStore)
+		cfClient = fakes.NewCFClient()
+		concourseClient = fakes.NewConcourseClient()
+
+		cfClient.AddInstance("instance-1", details)
+		Expect(concourseClient.CreateTeam(details)).To(Succeed())
+		Expect(store.SaveTeam(brokerStore, store.Team{Name: "venture", CreatedBy: "instance-1"})).To(Succeed())
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-1", OrgName: "venture",
+			SpaceGUID: "space-a", TeamName: "venture"})).To(Succeed())
+	})
+
+	It("reports nothing when everything matches", func() {
+		report, err := newReconciler(false).Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Drifts).To(BeEmpty())
+	})
+
+	Context("when the auth of the team was changed by hand", func() {
+		BeforeEach(func() {
+			concourseClient.AuthMethods["venture"] = []atc.AuthMethod{{Type: atc.AuthTypeBasic, DisplayName: "Basic Auth"}}
+		})
+		It("only reports the drift without repair", func() {
+			reconciler := newReconciler(false)
+			report, err := reconciler.Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Drifts).To(Equal([]Drift{{Kind: AuthDrift, TeamName: "venture",
+				Detail: "the team has auth methods Basic Auth instead of only UAA"}}))
+			Expect(reconciler.LastReport()).To(Equal(report))
+			Expect(concourseClient.AuthMethods["venture"][0].Type).To(Equal(atc.AuthTypeBasic))
+		})
+		It("re-applies the expected team config with repair", func() {
+			report, err := newReconciler(true).Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Drifts).To(HaveLen(1))
+			Expect(report.Drifts[0].Repaired).To(BeTrue())
+			Expect(concourseClient.AuthMethods["venture"]).To(Equal(fakes.UAAAuthMethods))
+			entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Operation).To(Equal("reconcile-auth-drift"))
+		})
+	})
+
+	It("sets the team config with repair only when it differs from the one set last", func() {
+		reconciler := newReconciler(true)
+		_, err := reconciler.Run()
+		Expect(err).NotTo(HaveOccurred())
+		concourseClient.Teams["venture"] = atc.Team{Name: "venture"}
+
+		_, err = reconciler.Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(concourseClient.Teams["venture"].UAAAuth).To(BeNil())
+
+		cfClient.AddInstance("instance-2", cf.Details{OrgName: "venture", SpaceGUID: "space-b"})
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-2", OrgName: "venture",
+			SpaceGUID: "space-b", TeamName: "venture"})).To(Succeed())
+		_, err = reconciler.Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(concourseClient.Teams["venture"].UAAAuth.CFSpaces).To(ConsistOf("space-a", "space-b"))
+	})
+
+	Context("when the team was destroyed in Concourse", func() {
+		BeforeEach(func() {
+			delete(concourseClient.Teams, "venture")
+		})
+		It("recreates it with repair", func() {
+			report, err := newReconciler(true).Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Drifts[0].Kind).To(Equal(MissingTeam))
+			Expect(concourseClient.Teams["venture"].UAAAuth.CFSpaces).To(Equal([]string{"space-a"}))
+		})
+	})
+
+	Context("when a shared instance was purged in CF", func() {
+		BeforeEach(func() {
+			cfClient.AddInstance("instance-2", cf.Details{OrgName: "venture", SpaceGUID: "space-b"})
+			Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-2", OrgName: "venture",
+				SpaceGUID: "space-b", TeamName: "venture"})).To(Succeed())
+			delete(cfClient.Instances, "instance-2")
+		})
+		It("drops the instance and its space with repair", func() {
+			report, err := newReconciler(true).Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Drifts).To(HaveLen(1))
+			Expect(report.Drifts[0].Kind).To(Equal(PurgedInstance))
+			_, found, _ := store.GetInstance(brokerStore, "instance-2")
+			Expect(found).To(BeFalse())
+			Expect(concourseClient.Teams["venture"].UAAAuth.CFSpaces).To(Equal([]string{"space-a"}))
+		})
+	})
+
+	Context("when CF has not recorded a new instance yet", func() {
+		BeforeEach(func() {
+			Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-2", OrgName: "venture",
+				SpaceGUID: "space-b", TeamName: "venture", CreatedAt: time.Now().UTC()})).To(Succeed())
+		})
+		It("keeps the instance with repair", func() {
+			report, err := newReconciler(true).Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Drifts).To(BeEmpty())
+			_, found, _ := store.GetInstance(brokerStore, "instance-2")
+			Expect(found).To(BeTrue())
+		})
+	})
+
+	Context("when the space of the last instance was deleted", func() {
+		BeforeEach(func() {
+			delete(cfClient.Spaces, "space-a")
+		})
+		It("flags the team as orphaned with repair", func() {
+			report, err := newReconciler(true).Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Drifts).To(HaveLen(2))
+			Expect(report.Drifts[0].Kind).To(Equal(DeletedSpace))
+			Expect(report.Drifts[1].Kind).To(Equal(OrphanedTeam))
+			team, _, _ := store.GetTeam(brokerStore, "venture")
+			Expect(team.OrphanedAt).NotTo(BeNil())
+			Expect(concourseClient.Destroyed).To(BeEmpty())
+		})
+	})
+
+	Context("when only pipeline instances refer to the team", func() {
+		BeforeEach(func() {
+			Expect(store.DeleteInstance(brokerStore, "instance-1")).To(Succeed())
+			Expect(store.SavePipelineInstance(brokerStore, store.PipelineInstance{ID: "pipeline-1", TeamName: "venture",
+				PipelineName: "app"})).To(Succeed())
+		})
+		It("does not flag the team as orphaned with repair", func() {
+			report, err := newReconciler(true).Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Drifts).To(HaveLen(1))
+			Expect(report.Drifts[0].Kind).To(Equal(OrphanedTeam))
+			Expect(report.Drifts[0].Repaired).To(BeFalse())
+			team, _, _ := store.GetTeam(brokerStore, "venture")
+			Expect(team.OrphanedAt).To(BeNil())
+		})
+	})
+
+	Context("when the org was renamed", func() {
+		BeforeEach(func() {
+			renamed := details
+			renamed.OrgName = "venture-industries"
+			cfClient.Spaces["space-a"] = renamed
+		})
+		It("reports the rename and updates the record with repair", func() {
+			report, err := newReconciler(true).Run()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Drifts[0].Kind).To(Equal(RenamedOrg))
+			instance, _, _ := store.GetInstance(brokerStore, "instance-1")
+			Expect(instance.OrgName).To(Equal("venture-industries"))
+			Expect(instance.TeamName).To(Equal("venture"))
+		})
+	})
+})
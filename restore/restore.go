@@ -0,0 +1,293 @@
+package restore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/archive"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/store"
+	"gopkg.in/yaml.v2"
+)
+
+// Options change how an archive is restored.
+type Options struct {
+	// InstanceID attaches the restored team to a service instance that CF knows about
+	InstanceID string `json:"instance_id"`
+}
+
+// Result is what a restore did.
+type Result struct {
+	ArchiveID  string   `json:"archive_id"`
+	TeamName   string   `json:"team"`
+	InstanceID string   `json:"instance_id,omitempty"`
+	Pipelines  []string `json:"pipelines"`
+	Warnings   []string `json:"warnings"`
+}
+
+// Restorer recreates teams from their archives.
+type Restorer struct {
+	store           store.Store
+	lock            sync.Locker
+	auditLog        audit.Log
+	archiver        *archive.Archiver
+	newCFClient     func() (cf.Client, error)
+	concourseClient concourse.Client
+	logger          lager.Logger
+	env             config.Env
+}
+
+// New returns a restorer.
+func New(s store.Store, lock sync.Locker, auditLog audit.Log, archiver *archive.Archiver,
+	newCFClient func() (cf.Client, error), concourseClient concourse.Client, logger lager.Logger, env config.Env) *Restorer {
+	return &Restorer{
+		store:           s,
+		lock:            lock,
+		auditLog:        auditLog,
+		archiver:        archiver,
+		newCFClient:     newCFClient,
+		concourseClient: concourseClient,
+		logger:          logger.Session("restore"),
+		env:             env,
+	}
+}
+
+// Restore recreates the team of an archive with its auth config and pipelines. A team that still
+// exists is only restored into when the broker created it, pipelines it already has are kept.
+func (r *Restorer) Restore(archiveID string, options Options) (Result, error) {
+	result, err := r.restore(archiveID, options)
+	r.record(archiveID, result, err)
+	if err != nil {
+		r.logger.Error("restore-error", err, lager.Data{"archive-id": archiveID})
+		return result, err
+	}
+	r.logger.Info("restored", lager.Data{
+		"archive-id":  archiveID,
+		"team-name":   result.TeamName,
+		"instance-id": result.InstanceID,
+		"pipelines":   result.Pipelines,
+	})
+	return result, nil
+}
+
+func (r *Restorer) restore(archiveID string, options Options) (Result, error) {
+	exported, err := r.archiver.Load(archiveID)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		ArchiveID:  archiveID,
+		TeamName:   exported.TeamName,
+		InstanceID: options.InstanceID,
+		Pipelines:  []string{},
+		Warnings:   []string{},
+	}
+	if concourse.IsProtected(r.env, exported.TeamName) {
+		return result, fmt.Errorf("Team %s is protected and cannot be restored", exported.TeamName)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var instance *store.Instance
+	if options.InstanceID != "" {
+		instance, err = r.attachedInstance(options.InstanceID, exported.TeamName)
+		if err != nil {
+			return result, err
+		}
+	}
+	team, owned, err := store.GetTeam(r.store, exported.TeamName)
+	if err != nil {
+		return result, err
+	}
+	exists, err := r.teamExists(exported.TeamName)
+	if err != nil {
+		return result, err
+	}
+	if exists && !owned {
+		return result, fmt.Errorf("Refusing to restore into team %s: it was not created by this broker", exported.TeamName)
+	}
+
+	teamConfig, err := r.teamConfig(exported, instance, &result)
+	if err != nil {
+		return result, err
+	}
+	err = r.concourseClient.SetTeam(exported.TeamName, teamConfig)
+	if err != nil {
+		return result, err
+	}
+	for _, pipeline := range exported.Pipelines {
+		restored, err := r.restorePipeline(exported.TeamName, pipeline, &result)
+		if err != nil {
+			return result, fmt.Errorf("Restoring pipeline %s failed: %s", pipeline.Name, err)
+		}
+		if restored {
+			result.Pipelines = append(result.Pipelines, pipeline.Name)
+		}
+	}
+
+	if !owned {
+		team = store.Team{Name: exported.TeamName, CreatedBy: "archive:" + archiveID, CreatedAt: time.Now().UTC()}
+	}
+	if instance != nil {
+		team.OrphanedAt = nil
+		err = store.SaveInstance(r.store, *instance)
+		if err != nil {
+			return result, err
+		}
+	} else {
+		instances, err := store.TeamInstances(r.store, exported.TeamName)
+		if err != nil {
+			return result, err
+		}
+		if len(instances) == 0 {
+			result.Warnings = append(result.Warnings,
+				"no service instance refers to the team, garbage collection treats it as orphaned")
+		}
+	}
+	return result, store.SaveTeam(r.store, team)
+}
+
+// attachedInstance returns the record of a service instance the restored team is attached to.
+// An instance the broker has no record of is looked up in CF.
+func (r *Restorer) attachedInstance(instanceID, teamName string) (*store.Instance, error) {
+	instance, found, err := store.GetInstance(r.store, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if instance.TeamName != teamName {
+			return nil, fmt.Errorf("Instance %s belongs to team %s, not to %s", instanceID, instance.TeamName, teamName)
+		}
+		return &instance, nil
+	}
+	cfClient, err := r.newCFClient()
+	if err != nil {
+		return nil, err
+	}
+	exists, err := cfClient.InstanceExists(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("Instance %s does not exist in CF", instanceID)
+	}
+	details, err := cfClient.GetDeprovisionDetails(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return &store.Instance{
+		ID:        instanceID,
+		OrgGUID:   details.OrgGUID,
+		OrgName:   details.OrgName,
+		SpaceGUID: details.SpaceGUID,
+		SpaceName: details.SpaceName,
+		TeamName:  teamName,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+func (r *Restorer) teamExists(teamName string) (bool, error) {
+	teams, err := r.concourseClient.ListTeams()
+	if err != nil {
+		return false, err
+	}
+	for _, team := range teams {
+		if team.Name == teamName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// teamConfig returns the archived auth config. The UAA auth the broker configures is rebuilt with
+// the current client credentials for the archived spaces and those of the team's instances.
+func (r *Restorer) teamConfig(exported archive.Archive, instance *store.Instance, result *Result) (atc.Team, error) {
+	if exported.Team.UAAAuth == nil {
+		result.Warnings = append(result.Warnings, "the archive has no UAA auth, its auth config was restored without secrets")
+		return exported.Team, nil
+	}
+	instances, err := store.TeamInstances(r.store, exported.TeamName)
+	if err != nil {
+		return atc.Team{}, err
+	}
+	if instance != nil {
+		instances = append(instances, *instance)
+	}
+	spaces := exported.Team.UAAAuth.CFSpaces
+	for _, space := range store.SpaceGUIDs(instances) {
+		if !contains(spaces, space) {
+			spaces = append(spaces, space)
+		}
+	}
+	return r.concourseClient.TeamConfig(spaces), nil
+}
+
+func (r *Restorer) restorePipeline(teamName string, pipeline archive.Pipeline, result *Result) (bool, error) {
+	_, _, _, found, err := r.concourseClient.PipelineConfig(teamName, pipeline.Name)
+	if err != nil {
+		return false, err
+	}
+	if found {
+		result.Warnings = append(result.Warnings,
+			fmt.Sprintf("%s: the pipeline already exists and was not restored", pipeline.Name))
+		return false, nil
+	}
+	var pipelineConfig atc.Config
+	err = yaml.Unmarshal([]byte(pipeline.Config), &pipelineConfig)
+	if err != nil {
+		return false, err
+	}
+	_, warnings, err := r.concourseClient.SetPipelineConfig(teamName, pipeline.Name, "", pipelineConfig)
+	if err != nil {
+		return false, err
+	}
+	for _, warning := range warnings {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %s", pipeline.Name, warning))
+	}
+	if pipeline.Paused {
+		err = r.concourseClient.PausePipeline(teamName, pipeline.Name)
+	} else {
+		err = r.concourseClient.UnpausePipeline(teamName, pipeline.Name)
+	}
+	if err != nil {
+		return true, err
+	}
+	if pipeline.Public {
+		err = r.concourseClient.ExposePipeline(teamName, pipeline.Name)
+	}
+	return true, err
+}
+
+func (r *Restorer) record(archiveID string, result Result, err error) {
+	entry := audit.Entry{
+		Time:       time.Now().UTC(),
+		Operation:  "restore",
+		InstanceID: result.InstanceID,
+		TeamName:   result.TeamName,
+		Caller:     "admin",
+		Outcome:    audit.Succeeded,
+	}
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+	}
+	auditErr := r.auditLog.Record(entry)
+	if auditErr != nil {
+		r.logger.Error("audit-error", auditErr)
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package restore
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/archive"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Restorer", func() {
+	var (
+		restorer        *Restorer
+		brokerStore     store.Store
+		auditLog        audit.Log
+		cfClient        *fakes.CFClient
+		concourseClient *fakes.ConcourseClient
+		dir             string
+		archiveID       string
+		details         = cf.Details{OrgGUID: "org-guid", OrgName: "venture", SpaceGUID: "space-b", SpaceName: "prod"}
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "archives")
+		Expect(err).NotTo(HaveOccurred())
+		brokerStore = store.NewMemoryStore()
+		auditLog = audit.NewStoreLog(brokerStore)
+		cfClient = fakes.NewCFClient()
+		concourseClient = fakes.NewConcourseClient()
+		archiver := archive.NewArchiver(archive.NewDir(dir), brokerStore)
+		restorer = New(brokerStore, &sync.Mutex{}, auditLog, archiver, func() (cf.Client, error) { return cfClient, nil },
+			concourseClient, lagertest.NewTestLogger("restore"), config.Env{})
+
+		team := concourseClient.TeamConfig([]string{"space-a"})
+		Expect(concourseClient.SetTeam("venture", team)).To(Succeed())
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "deploy", Public: true}, atc.Config{
+			Jobs: atc.JobConfigs{{Name: "deploy"}},
+		})
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "scan", Paused: true}, atc.Config{})
+		record, err := archiver.Archive(concourseClient, "venture", team, []string{"instance-1"}, "deprovisioned")
+		Expect(err).NotTo(HaveOccurred())
+		archiveID = record.ID
+		Expect(concourseClient.DestroyTeam("venture")).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("recreates the team with its auth, pipelines and their state", func() {
+		result, err := restorer.Restore(archiveID, Options{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Pipelines).To(Equal([]string{"deploy", "scan"}))
+		Expect(result.Warnings).To(HaveLen(1))
+
+		Expect(concourseClient.Teams["venture"].UAAAuth.CFSpaces).To(Equal([]string{"space-a"}))
+		pipelines := concourseClient.Pipelines["venture"]
+		Expect(pipelines[0].Config.Jobs[0].Name).To(Equal("deploy"))
+		Expect(pipelines[0].Paused).To(BeFalse())
+		Expect(pipelines[0].Public).To(BeTrue())
+		Expect(pipelines[1].Paused).To(BeTrue())
+		Expect(pipelines[1].Public).To(BeFalse())
+
+		team, found, _ := store.GetTeam(brokerStore, "venture")
+		Expect(found).To(BeTrue())
+		Expect(team.CreatedBy).To(Equal("archive:" + archiveID))
+		entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+		Expect(entries[0].Operation).To(Equal("restore"))
+	})
+
+	It("attaches the team to a new service instance", func() {
+		cfClient.AddInstance("instance-2", details)
+		result, err := restorer.Restore(archiveID, Options{InstanceID: "instance-2"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Warnings).To(BeEmpty())
+		Expect(concourseClient.Teams["venture"].UAAAuth.CFSpaces).To(Equal([]string{"space-a", "space-b"}))
+		instance, found, _ := store.GetInstance(brokerStore, "instance-2")
+		Expect(found).To(BeTrue())
+		Expect(instance.TeamName).To(Equal("venture"))
+		Expect(instance.SpaceGUID).To(Equal("space-b"))
+	})
+
+	It("refuses instances CF does not know", func() {
+		_, err := restorer.Restore(archiveID, Options{InstanceID: "instance-2"})
+		Expect(err).To(MatchError("Instance instance-2 does not exist in CF"))
+	})
+
+	It("refuses to restore into a team it did not create", func() {
+		Expect(concourseClient.SetTeam("venture", atc.Team{})).To(Succeed())
+		_, err := restorer.Restore(archiveID, Options{})
+		Expect(err).To(MatchError(ContainSubstring("it was not created by this broker")))
+	})
+
+	It("keeps pipelines a team it created already has", func() {
+		Expect(concourseClient.SetTeam("venture", atc.Team{})).To(Succeed())
+		Expect(store.SaveTeam(brokerStore, store.Team{Name: "venture", CreatedBy: "instance-2"})).To(Succeed())
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "deploy"}, atc.Config{})
+		result, err := restorer.Restore(archiveID, Options{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Pipelines).To(Equal([]string{"scan"}))
+		team, _, _ := store.GetTeam(brokerStore, "venture")
+		Expect(team.CreatedBy).To(Equal("instance-2"))
+	})
+})
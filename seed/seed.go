@@ -0,0 +1,300 @@
+package seed
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/policy"
+	"gopkg.in/yaml.v2"
+)
+
+// maxConfigSize limits how much of a pipeline config is read from a URL.
+const maxConfigSize = 1 << 20
+
+var varPattern = regexp.MustCompile(`\(\(([-\w\p{L}.]+)\)\)`)
+
+// Params are the provision parameters that add pipelines and variables to those of the plan.
+type Params struct {
+	Pipelines []plans.Pipeline       `json:"pipelines"`
+	Vars      map[string]interface{} `json:"vars"`
+	// SyncTemplate opts in to re-copying template pipelines when they change
+	SyncTemplate bool `json:"sync_template"`
+}
+
+// ParseParams reads the seeding parameters from the raw provision parameters.
+func ParseParams(raw json.RawMessage) (Params, error) {
+	params := Params{}
+	if len(raw) == 0 {
+		return params, nil
+	}
+	err := json.Unmarshal(raw, &params)
+	return params, err
+}
+
+// Result lists the pipelines a seed created and the warnings Concourse gave for them.
+type Result struct {
+	Created  []string
+	Warnings []string
+	// Vars are the merged vars the pipelines were interpolated with
+	Vars map[string]interface{}
+	// TemplateVersions are the config versions of the pipelines copied from the template team
+	TemplateVersions map[string]string
+}
+
+// Seeder installs pipeline configs into teams.
+type Seeder struct {
+	dir            string
+	allowURLParams bool
+	rules          policy.Rules
+	httpClient     *http.Client
+}
+
+// New returns a seeder that reads bundled configs from PIPELINES_DIR. Pipelines that violate the
+// rules are not seeded.
+func New(env config.Env, rules policy.Rules) *Seeder {
+	return &Seeder{
+		dir:            env.PipelinesDir,
+		allowURLParams: env.SeedParamURLs,
+		rules:          rules,
+		httpClient:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Validate checks the pipelines of the provision parameters before anything is provisioned.
+func (s *Seeder) Validate(params Params) error {
+	for _, pipeline := range params.Pipelines {
+		err := pipeline.Validate()
+		if err != nil {
+			return err
+		}
+		if pipeline.URL != "" && !s.allowURLParams {
+			return fmt.Errorf("Pipeline %s: pipelines cannot be read from a URL given in the parameters", pipeline.Name)
+		}
+		if pipeline.File != "" {
+			_, err = s.path(pipeline.File)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Seed copies the pipelines of the template team of the plan and installs the pipelines of the plan
+// and the parameters into a team. Variables are taken from vars, then the plan, then the parameters,
+// later ones win. Every step gets the worker tag of the team, if it has one. Pipelines that already
+// exist are left alone with a warning. When a pipeline fails, the ones created before it are deleted again.
+func (s *Seeder) Seed(client concourse.Client, teamName, workerTag string, plan plans.Plan, params Params,
+	vars map[string]interface{}) (Result, error) {
+	result := Result{Created: []string{}, Warnings: []string{}, TemplateVersions: map[string]string{}}
+	merged := map[string]interface{}{}
+	for _, source := range []map[string]interface{}{vars, plan.Vars, params.Vars} {
+		for name, value := range source {
+			merged[name] = value
+		}
+	}
+	result.Vars = merged
+	if plan.TemplateTeam != "" {
+		err := s.cloneTemplate(client, plan.TemplateTeam, teamName, workerTag, merged, &result)
+		if err != nil {
+			deleteAll(client, teamName, result.Created)
+			return Result{}, err
+		}
+	}
+	for _, pipeline := range pipelines(plan, params) {
+		warnings, created, err := s.install(client, teamName, workerTag, pipeline, merged)
+		if created {
+			result.Created = append(result.Created, pipeline.Name)
+		}
+		if err != nil {
+			deleteAll(client, teamName, result.Created)
+			return Result{}, fmt.Errorf("Seeding pipeline %s failed: %s", pipeline.Name, err)
+		}
+		result.Warnings = append(result.Warnings, warnings...)
+	}
+	return result, nil
+}
+
+// deleteAll deletes pipelines on a best effort basis, the error that made them go is what matters.
+func deleteAll(client concourse.Client, teamName string, pipelineNames []string) {
+	for _, name := range pipelineNames {
+		client.DeletePipeline(teamName, name)
+	}
+}
+
+func (s *Seeder) install(client concourse.Client, teamName, workerTag string, pipeline plans.Pipeline,
+	vars map[string]interface{}) ([]string, bool, error) {
+	_, _, _, found, err := client.PipelineConfig(teamName, pipeline.Name)
+	if err != nil {
+		return nil, false, err
+	}
+	if found {
+		return []string{fmt.Sprintf("%s: the pipeline already exists and was not seeded", pipeline.Name)}, false, nil
+	}
+	raw, err := s.load(pipeline)
+	if err != nil {
+		return nil, false, err
+	}
+	warnings, err := setPipeline(client, s.rules, teamName, workerTag, pipeline.Name, "", raw, vars)
+	if err != nil {
+		return nil, false, err
+	}
+	if pipeline.Unpause {
+		err = client.UnpausePipeline(teamName, pipeline.Name)
+		if err != nil {
+			return nil, true, err
+		}
+	}
+	return warnings, true, nil
+}
+
+// SetPipeline sets a single pipeline config the way seeded pipelines are set: interpolated with
+// vars, tagged with the worker tag of the team and checked against the rules. version is the config
+// version of the pipeline being updated, or empty for a new pipeline.
+func (s *Seeder) SetPipeline(client concourse.Client, teamName, workerTag, pipelineName, version string, raw []byte,
+	vars map[string]interface{}) ([]string, error) {
+	return setPipeline(client, s.rules, teamName, workerTag, pipelineName, version, raw, vars)
+}
+
+// setPipeline interpolates a raw pipeline config, tags it with the worker tag of the team, checks it
+// against the rules and sets it. version is the config version of the pipeline being updated, or
+// empty for a new pipeline.
+func setPipeline(client concourse.Client, rules policy.Rules, teamName, workerTag, pipelineName, version string, raw []byte,
+	vars map[string]interface{}) ([]string, error) {
+	raw, err := Interpolate(raw, vars)
+	if err != nil {
+		return nil, err
+	}
+	var pipelineConfig atc.Config
+	err = yaml.Unmarshal(raw, &pipelineConfig)
+	if err != nil {
+		return nil, err
+	}
+	policy.Tag(&pipelineConfig, workerTag)
+	violations := rules.Check(pipelineConfig)
+	if len(violations) > 0 {
+		details := []string{}
+		for _, violation := range violations {
+			details = append(details, violation.String())
+		}
+		return nil, fmt.Errorf("the pipeline violates the pipeline policy: %s", strings.Join(details, "; "))
+	}
+	_, configWarnings, err := client.SetPipelineConfig(teamName, pipelineName, version, pipelineConfig)
+	if err != nil {
+		return nil, err
+	}
+	warnings := []string{}
+	for _, warning := range configWarnings {
+		warnings = append(warnings, fmt.Sprintf("%s: %s", pipelineName, warning))
+	}
+	return warnings, nil
+}
+
+// pipelines returns the pipelines of the plan followed by those of the parameters. A parameter
+// pipeline replaces a plan pipeline of the same name.
+func pipelines(plan plans.Plan, params Params) []plans.Pipeline {
+	overridden := map[string]bool{}
+	for _, pipeline := range params.Pipelines {
+		overridden[pipeline.Name] = true
+	}
+	result := []plans.Pipeline{}
+	for _, pipeline := range plan.Pipelines {
+		if !overridden[pipeline.Name] {
+			result = append(result, pipeline)
+		}
+	}
+	return append(result, params.Pipelines...)
+}
+
+func (s *Seeder) load(pipeline plans.Pipeline) ([]byte, error) {
+	if pipeline.File != "" {
+		path, err := s.path(pipeline.File)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(path)
+	}
+	return s.fetch(pipeline.URL)
+}
+
+// path resolves a bundled file and refuses anything outside PIPELINES_DIR.
+func (s *Seeder) path(file string) (string, error) {
+	clean := filepath.Clean(file)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Pipeline file %s is not in the pipelines directory", file)
+	}
+	return filepath.Join(s.dir, clean), nil
+}
+
+func (s *Seeder) fetch(rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported pipeline URL %s", rawURL)
+	}
+	resp, err := s.httpClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching %s: %s", rawURL, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxConfigSize))
+}
+
+// Interpolate replaces ((var)) placeholders with their value. A placeholder that makes up a whole
+// YAML value gets the JSON encoding of the value, like fly does, one inside a longer string gets
+// the plain text of a string, number or boolean. That text cannot be escaped without knowing how the string is
+// quoted, so lists, maps and values with a line break, quote or backslash are refused there. Placeholders without a
+// value are kept for the credential manager of Concourse to resolve.
+func Interpolate(raw []byte, vars map[string]interface{}) ([]byte, error) {
+	result := []byte{}
+	last := 0
+	for _, match := range varPattern.FindAllSubmatchIndex(raw, -1) {
+		name := string(raw[match[2]:match[3]])
+		value, ok := vars[name]
+		if !ok {
+			continue
+		}
+		whole := wholeValue(raw, match[0], match[1])
+		text, isString := value.(string)
+		if !isString || whole {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot interpolate %s: %s", name, err)
+			}
+			text = string(encoded)
+		}
+		if !whole && !isString && strings.ContainsAny(text[:1], "[{") {
+			return nil, fmt.Errorf("Cannot interpolate %s: a list or map cannot be part of a longer string", name)
+		}
+		if !whole && strings.ContainsAny(text, "\r\n\"'\\") {
+			return nil, fmt.Errorf("Cannot interpolate %s: a value inside a longer string cannot hold line breaks, quotes or backslashes", name)
+		}
+		result = append(result, raw[last:match[0]]...)
+		result = append(result, text...)
+		last = match[1]
+	}
+	return append(result, raw[last:]...), nil
+}
+
+func wholeValue(raw []byte, start, end int) bool {
+	before := start == 0 || strings.ContainsRune(" \t\n[{,", rune(raw[start-1]))
+	after := end == len(raw) || strings.ContainsRune(" \t\r\n]},#", rune(raw[end]))
+	return before && after
+}
@@ -0,0 +1,192 @@
+package seed
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/policy"
+)
+
+const deployConfig = `
+resources:
+- name: source
+  type: git
+  source:
+    uri: ((repo))
+    branch: ((branch))
+jobs:
+- name: deploy-((space_name))
+  plan:
+  - get: source
+`
+
+var _ = Describe("Seeder", func() {
+	var (
+		seeder          *Seeder
+		concourseClient *fakes.ConcourseClient
+		dir             string
+		vars            = map[string]interface{}{"space_name": "dev"}
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "pipelines")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "deploy.yml"), []byte(deployConfig), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "broken.yml"), []byte("jobs: [[["), 0600)).To(Succeed())
+		seeder = New(config.Env{PipelinesDir: dir}, policy.Rules{})
+		concourseClient = fakes.NewConcourseClient()
+		concourseClient.Teams["venture"] = concourseClient.TeamConfig(nil)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("Interpolate", func() {
+		It("replaces known vars with their JSON encoding and keeps the others", func() {
+			raw, err := Interpolate([]byte("uri: ((repo))\nport: ((port))\nsecret: ((vault-secret))"),
+				map[string]interface{}{"repo": "git@example.com:app", "port": 8080})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(raw)).To(Equal("uri: \"git@example.com:app\"\nport: 8080\nsecret: ((vault-secret))"))
+		})
+
+		It("splices string vars into longer strings and refuses ones that would break out of them", func() {
+			raw, err := Interpolate([]byte("uri: https://((host))/app.git"), map[string]interface{}{"host": "example.com"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(raw)).To(Equal("uri: https://example.com/app.git"))
+
+			for _, host := range []string{"example.com\nprivileged: true", "example.com\"", "example.com'", "example.com\\"} {
+				_, err = Interpolate([]byte("uri: \"https://((host))/app.git\""), map[string]interface{}{"host": host})
+				Expect(err).To(MatchError(ContainSubstring("Cannot interpolate host")))
+			}
+		})
+
+		It("splices numbers into longer strings and refuses lists and maps", func() {
+			raw, err := Interpolate([]byte("tag: v((major))"), map[string]interface{}{"major": 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(raw)).To(Equal("tag: v2"))
+
+			_, err = Interpolate([]byte("repository: 'repo/((v)):latest'"), map[string]interface{}{"v": []interface{}{"x'y"}})
+			Expect(err).To(MatchError(ContainSubstring("Cannot interpolate v")))
+			_, err = Interpolate([]byte(`name: "a-((w))-b"`), map[string]interface{}{"w": map[string]interface{}{"k": "q"}})
+			Expect(err).To(MatchError(ContainSubstring("Cannot interpolate w")))
+			_, err = Interpolate([]byte("tags: [a-((n))]"), map[string]interface{}{"n": []interface{}{1, 2}})
+			Expect(err).To(MatchError(ContainSubstring("a list or map")))
+		})
+	})
+
+	It("installs plan pipelines with vars from the plan and the parameters", func() {
+		plan := plans.Plan{
+			Pipelines: []plans.Pipeline{{Name: "deploy", File: "deploy.yml", Unpause: true}},
+			Vars:      map[string]interface{}{"repo": "https://example.com/plan", "branch": "master"},
+		}
+		params := Params{Vars: map[string]interface{}{"branch": "develop"}}
+		result, err := seeder.Seed(concourseClient, "venture", "", plan, params, vars)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Created).To(Equal([]string{"deploy"}))
+
+		pipeline := concourseClient.Pipelines["venture"][0]
+		Expect(pipeline.Paused).To(BeFalse())
+		Expect(pipeline.Config.Jobs[0].Name).To(Equal("deploy-dev"))
+		Expect(pipeline.Config.Resources[0].Source["uri"]).To(Equal("https://example.com/plan"))
+		Expect(pipeline.Config.Resources[0].Source["branch"]).To(Equal("develop"))
+	})
+
+	It("tags the pipelines for the workers of the team", func() {
+		params := Params{Pipelines: []plans.Pipeline{{Name: "deploy", File: "deploy.yml"}}}
+		_, err := seeder.Seed(concourseClient, "venture", "segment-a", plans.Plan{}, params, vars)
+		Expect(err).NotTo(HaveOccurred())
+		pipeline := concourseClient.Pipelines["venture"][0]
+		Expect(pipeline.Config.Resources[0].Tags).To(Equal(atc.Tags{"segment-a"}))
+		Expect(pipeline.Config.Jobs[0].Plan[0].Tags).To(Equal(atc.Tags{"segment-a"}))
+	})
+
+	It("leaves new pipelines paused unless asked", func() {
+		params := Params{Pipelines: []plans.Pipeline{{Name: "deploy", File: "deploy.yml"}}}
+		_, err := seeder.Seed(concourseClient, "venture", "", plans.Plan{}, params, vars)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(concourseClient.Pipelines["venture"][0].Paused).To(BeTrue())
+	})
+
+	It("passes the config warnings on", func() {
+		concourseClient.ConfigWarnings = []string{"pipeline: no groups"}
+		params := Params{Pipelines: []plans.Pipeline{{Name: "deploy", File: "deploy.yml"}}}
+		result, err := seeder.Seed(concourseClient, "venture", "", plans.Plan{}, params, vars)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Warnings).To(Equal([]string{"deploy: pipeline: no groups"}))
+	})
+
+	It("refuses pipelines that violate the pipeline policy", func() {
+		rules, err := policy.NewRules(config.Env{PolicySerialJobs: []string{"deploy*"}})
+		Expect(err).NotTo(HaveOccurred())
+		seeder = New(config.Env{PipelinesDir: dir}, rules)
+		params := Params{Pipelines: []plans.Pipeline{{Name: "deploy", File: "deploy.yml"}}}
+		_, err = seeder.Seed(concourseClient, "venture", "", plans.Plan{}, params, vars)
+		Expect(err).To(MatchError(ContainSubstring("violates the pipeline policy: serial: job deploy-dev must be serial")))
+		Expect(concourseClient.Pipelines["venture"]).To(BeEmpty())
+	})
+
+	It("does not overwrite existing pipelines", func() {
+		existing := concourseClient.AddPipeline("venture", atc.Pipeline{Name: "deploy"}, atc.Config{})
+		params := Params{Pipelines: []plans.Pipeline{{Name: "deploy", File: "deploy.yml"}}}
+		result, err := seeder.Seed(concourseClient, "venture", "", plans.Plan{}, params, vars)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Created).To(BeEmpty())
+		Expect(result.Warnings).To(Equal([]string{"deploy: the pipeline already exists and was not seeded"}))
+		Expect(existing.Version).To(Equal("1"))
+	})
+
+	It("deletes the pipelines it created when a later one fails", func() {
+		params := Params{Pipelines: []plans.Pipeline{
+			{Name: "deploy", File: "deploy.yml"},
+			{Name: "broken", File: "broken.yml"},
+		}}
+		_, err := seeder.Seed(concourseClient, "venture", "", plans.Plan{}, params, vars)
+		Expect(err).To(MatchError(ContainSubstring("Seeding pipeline broken failed")))
+		Expect(concourseClient.Pipelines["venture"]).To(BeEmpty())
+	})
+
+	It("reads pipelines from a URL", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(w, deployConfig)
+		}))
+		defer server.Close()
+		plan := plans.Plan{Pipelines: []plans.Pipeline{{Name: "deploy", URL: server.URL}}}
+		result, err := seeder.Seed(concourseClient, "venture", "", plan, Params{}, vars)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Created).To(Equal([]string{"deploy"}))
+	})
+
+	Describe("Validate", func() {
+		It("refuses files outside the pipelines directory", func() {
+			err := seeder.Validate(Params{Pipelines: []plans.Pipeline{{Name: "passwd", File: "../../etc/passwd"}}})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("refuses URLs in the parameters unless allowed", func() {
+			params := Params{Pipelines: []plans.Pipeline{{Name: "deploy", URL: "http://10.0.0.1/deploy.yml"}}}
+			Expect(seeder.Validate(params)).To(HaveOccurred())
+			seeder = New(config.Env{PipelinesDir: dir, SeedParamURLs: true}, policy.Rules{})
+			Expect(seeder.Validate(params)).To(Succeed())
+		})
+	})
+
+	It("fails when Concourse does", func() {
+		concourseClient.Err = errors.New("concourse is down")
+		params := Params{Pipelines: []plans.Pipeline{{Name: "deploy", File: "deploy.yml"}}}
+		_, err := seeder.Seed(concourseClient, "venture", "", plans.Plan{}, params, vars)
+		Expect(err).To(HaveOccurred())
+	})
+})
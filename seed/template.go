@@ -0,0 +1,156 @@
+package seed
+
+import (
+	"fmt"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/policy"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// cloneTemplate copies every pipeline of a template team into a team. Copies of pipelines that
+// are running in the template are unpaused.
+func (s *Seeder) cloneTemplate(client concourse.Client, sourceTeam, teamName, workerTag string,
+	vars map[string]interface{}, result *Result) error {
+	pipelines, err := client.ListPipelines(sourceTeam)
+	if err != nil {
+		return fmt.Errorf("Reading template team %s failed: %s", sourceTeam, err)
+	}
+	for _, pipeline := range pipelines {
+		_, _, _, found, err := client.PipelineConfig(teamName, pipeline.Name)
+		if err != nil {
+			return err
+		}
+		if found {
+			result.Warnings = append(result.Warnings,
+				fmt.Sprintf("%s: the pipeline already exists and was not copied from %s", pipeline.Name, sourceTeam))
+			continue
+		}
+		_, rawConfig, version, found, err := client.PipelineConfig(sourceTeam, pipeline.Name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			// deleted from the template while copying
+			continue
+		}
+		warnings, err := setPipeline(client, s.rules, teamName, workerTag, pipeline.Name, "", []byte(rawConfig), vars)
+		if err != nil {
+			return fmt.Errorf("Copying pipeline %s from %s failed: %s", pipeline.Name, sourceTeam, err)
+		}
+		result.Created = append(result.Created, pipeline.Name)
+		result.Warnings = append(result.Warnings, warnings...)
+		result.TemplateVersions[pipeline.Name] = version
+		if !pipeline.Paused {
+			err = client.UnpausePipeline(teamName, pipeline.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Syncer re-copies template pipelines into the teams that opted in when the template changes.
+type Syncer struct {
+	store  store.Store
+	lock   sync.Locker
+	client concourse.Client
+	rules  policy.Rules
+	logger lager.Logger
+}
+
+// NewSyncer returns a syncer. Template changes that violate the rules are not synced.
+func NewSyncer(s store.Store, lock sync.Locker, client concourse.Client, rules policy.Rules, logger lager.Logger) *Syncer {
+	return &Syncer{store: s, lock: lock, client: client, rules: rules, logger: logger.Session("template-sync")}
+}
+
+// Run syncs every team that opted in once.
+func (s *Syncer) Run() error {
+	clones, err := s.clones()
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, clone := range clones {
+		if !clone.Sync {
+			continue
+		}
+		err = s.sync(clone)
+		if err != nil {
+			s.logger.Error("sync-error", err, lager.Data{"team-name": clone.TeamName, "source-team": clone.SourceTeam})
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// clones returns the clones under the lock. The template is read without it.
+func (s *Syncer) clones() ([]store.Clone, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return store.ListClones(s.store)
+}
+
+// copy sets a template pipeline into a team and records the version it copied. It holds the lock
+// and reads the clone again, so a team deprovisioned while the template was read gets nothing.
+func (s *Syncer) copy(teamName, pipelineName, version, targetVersion string, rawConfig []byte) ([]string, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	clone, found, err := store.GetClone(s.store, teamName)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	team, _, err := store.GetTeam(s.store, teamName)
+	if err != nil {
+		return nil, true, err
+	}
+	warnings, err := setPipeline(s.client, s.rules, teamName, team.WorkerTag, pipelineName, targetVersion, rawConfig, clone.Vars)
+	if err != nil {
+		return nil, true, fmt.Errorf("Syncing pipeline %s from %s failed: %s", pipelineName, clone.SourceTeam, err)
+	}
+	if clone.Versions == nil {
+		clone.Versions = map[string]string{}
+	}
+	clone.Versions[pipelineName] = version
+	return warnings, true, store.SaveClone(s.store, clone)
+}
+
+func (s *Syncer) sync(clone store.Clone) error {
+	pipelines, err := s.client.ListPipelines(clone.SourceTeam)
+	if err != nil {
+		return err
+	}
+	for _, pipeline := range pipelines {
+		_, rawConfig, version, found, err := s.client.PipelineConfig(clone.SourceTeam, pipeline.Name)
+		if err != nil {
+			return err
+		}
+		copied, known := clone.Versions[pipeline.Name]
+		if !found || (known && copied == version) {
+			continue
+		}
+		_, _, targetVersion, exists, err := s.client.PipelineConfig(clone.TeamName, pipeline.Name)
+		if err != nil {
+			return err
+		}
+		if exists && !known {
+			// a pipeline of the team itself that happens to have the same name
+			continue
+		}
+		// recorded after every pipeline, so a later failure does not make the next run copy it again
+		warnings, cloned, err := s.copy(clone.TeamName, pipeline.Name, version, targetVersion, []byte(rawConfig))
+		if err != nil || !cloned {
+			return err
+		}
+		s.logger.Info("synced", lager.Data{
+			"team-name":     clone.TeamName,
+			"pipeline-name": pipeline.Name,
+			"version":       version,
+			"warnings":      warnings,
+		})
+	}
+	return nil
+}
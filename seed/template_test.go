@@ -0,0 +1,105 @@
+package seed
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/plans"
+	"github.com/vchrisr/concourse-broker/policy"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Template teams", func() {
+	var (
+		seeder          *Seeder
+		concourseClient *fakes.ConcourseClient
+		template        *fakes.Pipeline
+		plan            = plans.Plan{TemplateTeam: "templates"}
+		vars            = map[string]interface{}{"team_name": "venture"}
+	)
+
+	setTemplate := func(raw string) {
+		template.RawConfig = atc.RawConfig(raw)
+	}
+
+	BeforeEach(func() {
+		seeder = New(config.Env{}, policy.Rules{})
+		concourseClient = fakes.NewConcourseClient()
+		concourseClient.Teams["templates"] = concourseClient.TeamConfig(nil)
+		concourseClient.Teams["venture"] = concourseClient.TeamConfig(nil)
+		template = concourseClient.AddPipeline("templates", atc.Pipeline{Name: "cf-push"}, atc.Config{})
+		setTemplate(`{"jobs": [{"name": "push-((team_name))"}]}`)
+		concourseClient.AddPipeline("templates", atc.Pipeline{Name: "scan", Paused: true}, atc.Config{})
+	})
+
+	It("copies every pipeline of the template team with the vars of the team", func() {
+		result, err := seeder.Seed(concourseClient, "venture", "", plan, Params{}, vars)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Created).To(Equal([]string{"cf-push", "scan"}))
+		Expect(result.TemplateVersions).To(Equal(map[string]string{"cf-push": "1", "scan": "1"}))
+
+		copies := concourseClient.Pipelines["venture"]
+		Expect(copies[0].Config.Jobs[0].Name).To(Equal("push-venture"))
+		Expect(copies[0].Paused).To(BeFalse())
+		Expect(copies[1].Paused).To(BeTrue())
+	})
+
+	Describe("Syncer", func() {
+		var (
+			brokerStore store.Store
+			syncer      *Syncer
+		)
+
+		BeforeEach(func() {
+			brokerStore = store.NewMemoryStore()
+			syncer = NewSyncer(brokerStore, &sync.Mutex{}, concourseClient, policy.Rules{}, lagertest.NewTestLogger("sync"))
+			result, err := seeder.Seed(concourseClient, "venture", "", plan, Params{}, vars)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.SaveClone(brokerStore, store.Clone{TeamName: "venture", SourceTeam: "templates",
+				Sync: true, Vars: result.Vars, Versions: result.TemplateVersions})).To(Succeed())
+		})
+
+		It("re-copies pipelines whose template changed", func() {
+			setTemplate(`{"jobs": [{"name": "deploy-((team_name))"}]}`)
+			template.Version = "2"
+			Expect(syncer.Run()).To(Succeed())
+			Expect(concourseClient.Pipelines["venture"][0].Config.Jobs[0].Name).To(Equal("deploy-venture"))
+			clone, _, _ := store.GetClone(brokerStore, "venture")
+			Expect(clone.Versions["cf-push"]).To(Equal("2"))
+		})
+
+		It("leaves unchanged pipelines alone", func() {
+			Expect(syncer.Run()).To(Succeed())
+			Expect(concourseClient.Pipelines["venture"][0].Version).To(Equal("1"))
+		})
+
+		It("leaves teams that did not opt in alone", func() {
+			Expect(store.SaveClone(brokerStore, store.Clone{TeamName: "venture", SourceTeam: "templates",
+				Versions: map[string]string{"cf-push": "1"}})).To(Succeed())
+			template.Version = "2"
+			Expect(syncer.Run()).To(Succeed())
+			Expect(concourseClient.Pipelines["venture"][0].Version).To(Equal("1"))
+		})
+
+		It("does not bring back the clone of a team deprovisioned during the sync", func() {
+			Expect(store.DeleteClone(brokerStore, "venture")).To(Succeed())
+			_, cloned, err := syncer.copy("venture", "cf-push", "2", "1", []byte(`{"jobs": [{"name": "deploy"}]}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cloned).To(BeFalse())
+			Expect(concourseClient.Pipelines["venture"][0].Version).To(Equal("1"))
+			_, found, _ := store.GetClone(brokerStore, "venture")
+			Expect(found).To(BeFalse())
+		})
+
+		It("copies pipelines added to the template", func() {
+			concourseClient.AddPipeline("templates", atc.Pipeline{Name: "updates"}, atc.Config{})
+			Expect(syncer.Run()).To(Succeed())
+			Expect(concourseClient.Pipelines["venture"]).To(HaveLen(3))
+		})
+	})
+})
@@ -0,0 +1,275 @@
+package softdelete
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/notify"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// lockedUsername is the basic auth user of a deleted team. Its password is random and never kept.
+const lockedUsername = "soft-deleted"
+
+// ErrNotDeleted is returned when a team that is not soft deleted is undeleted.
+var ErrNotDeleted = errors.New("The team is not soft deleted")
+
+// Result is what an undelete did.
+type Result struct {
+	TeamName string   `json:"team"`
+	Unpaused []string `json:"unpaused"`
+	Warnings []string `json:"warnings"`
+}
+
+// Deleter locks everybody out of the teams of deprovisioned instances instead of destroying them
+// right away, and destroys them once SOFT_DELETE_WINDOW has passed without them being undeleted.
+type Deleter struct {
+	store           store.Store
+	lock            sync.Locker
+	auditLog        audit.Log
+	notifier        notify.Notifier
+	concourseClient concourse.Client
+	logger          lager.Logger
+	window          time.Duration
+	now             func() time.Time
+}
+
+// New returns a deleter.
+func New(s store.Store, lock sync.Locker, auditLog audit.Log, notifier notify.Notifier,
+	concourseClient concourse.Client, logger lager.Logger, env config.Env) *Deleter {
+	return &Deleter{
+		store:           s,
+		lock:            lock,
+		auditLog:        auditLog,
+		notifier:        notifier,
+		concourseClient: concourseClient,
+		logger:          logger.Session("soft-delete"),
+		window:          env.SoftDeleteWindow,
+		now:             time.Now,
+	}
+}
+
+// Enabled tells whether deprovisioned teams are soft deleted at all.
+func (d *Deleter) Enabled() bool {
+	return d != nil && d.window > 0
+}
+
+// Delete pauses every pipeline of a team, replaces its auth so nobody can log in and schedules it
+// for destruction. spaces are the spaces that had access, an undelete gives it back to them. The
+// caller holds the teams lock.
+func (d *Deleter) Delete(teamName string, spaces, instanceIDs []string, archiveID string) (store.Deletion, error) {
+	now := d.now().UTC()
+	deletion, found, err := store.GetDeletion(d.store, teamName)
+	if err != nil {
+		return deletion, err
+	}
+	if !found {
+		// a deletion that failed half way keeps what it recorded, the pipelines it paused are not running any more
+		deletion = store.Deletion{TeamName: teamName, DeletedAt: now, Running: []string{}}
+	}
+	deletion.DeleteAfter = now.Add(d.window)
+	deletion.Spaces = spaces
+	deletion.InstanceIDs = instanceIDs
+	deletion.ArchiveID = archiveID
+	pipelines, err := d.concourseClient.ListPipelines(teamName)
+	if err != nil {
+		return deletion, err
+	}
+	for _, pipeline := range pipelines {
+		if !pipeline.Paused && !contains(deletion.Running, pipeline.Name) {
+			deletion.Running = append(deletion.Running, pipeline.Name)
+		}
+	}
+	// saved before anything is paused, so the pipelines to unpause are known even if pausing fails
+	err = store.SaveDeletion(d.store, deletion)
+	if err != nil {
+		return deletion, err
+	}
+	for _, pipeline := range pipelines {
+		if pipeline.Paused {
+			continue
+		}
+		err = d.concourseClient.PausePipeline(teamName, pipeline.Name)
+		if err != nil {
+			return deletion, err
+		}
+	}
+	locked, err := lockedTeam()
+	if err != nil {
+		return deletion, err
+	}
+	err = d.concourseClient.SetTeam(teamName, locked)
+	if err != nil {
+		return deletion, err
+	}
+	d.logger.Info("deleted", lager.Data{
+		"team-name":    teamName,
+		"delete-after": deletion.DeleteAfter,
+		"paused":       deletion.Running,
+	})
+	d.notify("team-soft-deleted", teamName,
+		fmt.Sprintf("the team was deprovisioned and will be destroyed after %s unless it is undeleted",
+			deletion.DeleteAfter.Format(time.RFC3339)))
+	return deletion, nil
+}
+
+// lockedTeam returns auth nobody knows the credentials of. A team without any auth method would
+// be open to everybody instead.
+func lockedTeam() (atc.Team, error) {
+	password := make([]byte, 32)
+	_, err := rand.Read(password)
+	if err != nil {
+		return atc.Team{}, err
+	}
+	return atc.Team{BasicAuth: &atc.BasicAuth{
+		BasicAuthUsername: lockedUsername,
+		BasicAuthPassword: hex.EncodeToString(password),
+	}}, nil
+}
+
+// Undelete gives the spaces of a soft deleted team their access back and unpauses the pipelines
+// that were running when it was deleted.
+func (d *Deleter) Undelete(teamName string) (Result, error) {
+	result, err := d.undelete(teamName)
+	d.record("undelete", "admin", teamName, err)
+	if err != nil {
+		d.logger.Error("undelete-error", err, lager.Data{"team-name": teamName})
+		return result, err
+	}
+	d.logger.Info("undeleted", lager.Data{"team-name": teamName, "unpaused": result.Unpaused})
+	d.notify("team-undeleted", teamName, "the team was undeleted")
+	return result, nil
+}
+
+func (d *Deleter) undelete(teamName string) (Result, error) {
+	result := Result{TeamName: teamName, Unpaused: []string{}, Warnings: []string{}}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	deletion, found, err := store.GetDeletion(d.store, teamName)
+	if err != nil {
+		return result, err
+	}
+	if !found {
+		return result, ErrNotDeleted
+	}
+	err = d.concourseClient.SetTeam(teamName, d.concourseClient.TeamConfig(deletion.Spaces))
+	if err != nil {
+		return result, err
+	}
+	pipelines, err := d.concourseClient.ListPipelines(teamName)
+	if err != nil {
+		return result, err
+	}
+	existing := []string{}
+	for _, pipeline := range pipelines {
+		existing = append(existing, pipeline.Name)
+	}
+	for _, name := range deletion.Running {
+		if !contains(existing, name) {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: the pipeline no longer exists", name))
+			continue
+		}
+		err = d.concourseClient.UnpausePipeline(teamName, name)
+		if err != nil {
+			return result, err
+		}
+		result.Unpaused = append(result.Unpaused, name)
+	}
+	instances, err := store.TeamInstances(d.store, teamName)
+	if err != nil {
+		return result, err
+	}
+	if len(instances) == 0 {
+		result.Warnings = append(result.Warnings,
+			"no service instance refers to the team, garbage collection treats it as orphaned")
+	}
+	return result, store.DeleteDeletion(d.store, teamName)
+}
+
+// Run destroys the soft deleted teams whose recovery window has passed.
+func (d *Deleter) Run() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	deletions, err := store.ListDeletions(d.store)
+	if err != nil {
+		return err
+	}
+	now := d.now().UTC()
+	var lastErr error
+	for _, deletion := range deletions {
+		if now.Before(deletion.DeleteAfter) {
+			continue
+		}
+		err = d.destroy(deletion)
+		d.record("soft-delete-destroy-team", "broker", deletion.TeamName, err)
+		if err != nil {
+			d.logger.Error("destroy-error", err, lager.Data{"team-name": deletion.TeamName})
+			lastErr = err
+			continue
+		}
+		d.logger.Info("destroyed", lager.Data{"team-name": deletion.TeamName, "archive-id": deletion.ArchiveID})
+		d.notify("team-destroyed", deletion.TeamName,
+			fmt.Sprintf("soft deleted since %s, its pipelines were archived as %s",
+				deletion.DeletedAt.Format(time.RFC3339), deletion.ArchiveID))
+	}
+	return lastErr
+}
+
+func (d *Deleter) destroy(deletion store.Deletion) error {
+	err := d.concourseClient.DestroyTeam(deletion.TeamName)
+	if err != nil {
+		return err
+	}
+	err = store.DeleteClone(d.store, deletion.TeamName)
+	if err != nil {
+		return err
+	}
+	err = store.DeleteTeam(d.store, deletion.TeamName)
+	if err != nil {
+		return err
+	}
+	return store.DeleteDeletion(d.store, deletion.TeamName)
+}
+
+func (d *Deleter) notify(event, teamName, message string) {
+	err := d.notifier.Notify(notify.Notification{Time: d.now().UTC(), Event: event, TeamName: teamName, Message: message})
+	if err != nil {
+		d.logger.Error("notify-error", err, lager.Data{"team-name": teamName})
+	}
+}
+
+func (d *Deleter) record(operation, caller, teamName string, err error) {
+	entry := audit.Entry{
+		Time:      d.now().UTC(),
+		Operation: operation,
+		TeamName:  teamName,
+		Caller:    caller,
+		Outcome:   audit.Succeeded,
+	}
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+	}
+	auditErr := d.auditLog.Record(entry)
+	if auditErr != nil {
+		d.logger.Error("audit-error", auditErr)
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+package softdelete
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSoftDelete(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SoftDelete Suite")
+}
@@ -0,0 +1,132 @@
+package softdelete
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/cf"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Deleter", func() {
+	var (
+		brokerStore     store.Store
+		auditLog        audit.Log
+		notifier        *fakes.Notifier
+		concourseClient *fakes.ConcourseClient
+		deleter         *Deleter
+		now             time.Time
+	)
+
+	BeforeEach(func() {
+		brokerStore = store.NewMemoryStore()
+		auditLog = audit.NewStoreLog(brokerStore)
+		notifier = &fakes.Notifier{}
+		concourseClient = fakes.NewConcourseClient()
+		now = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+		deleter = New(brokerStore, &sync.Mutex{}, auditLog, notifier, concourseClient,
+			lagertest.NewTestLogger("soft-delete"), config.Env{SoftDeleteWindow: 24 * time.Hour})
+		deleter.now = func() time.Time { return now }
+
+		Expect(concourseClient.CreateTeam(cf.Details{OrgName: "venture", SpaceGUID: "space-a"})).To(Succeed())
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "deploy"}, atc.Config{})
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "nightly", Paused: true}, atc.Config{})
+		Expect(store.SaveTeam(brokerStore, store.Team{Name: "venture", CreatedBy: "instance-1"})).To(Succeed())
+	})
+
+	paused := func(name string) bool {
+		pipelines, err := concourseClient.ListPipelines("venture")
+		Expect(err).NotTo(HaveOccurred())
+		for _, pipeline := range pipelines {
+			if pipeline.Name == name {
+				return pipeline.Paused
+			}
+		}
+		Fail("no pipeline " + name)
+		return false
+	}
+
+	It("is disabled without a window", func() {
+		Expect(deleter.Enabled()).To(BeTrue())
+		Expect(New(brokerStore, &sync.Mutex{}, auditLog, notifier, concourseClient,
+			lagertest.NewTestLogger("soft-delete"), config.Env{}).Enabled()).To(BeFalse())
+	})
+
+	Context("when a team is deleted", func() {
+		BeforeEach(func() {
+			_, err := deleter.Delete("venture", []string{"space-a"}, []string{"instance-1"}, "venture-archive")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("pauses the pipelines and locks everybody out", func() {
+			Expect(paused("deploy")).To(BeTrue())
+			team := concourseClient.Teams["venture"]
+			Expect(team.UAAAuth).To(BeNil())
+			Expect(team.BasicAuth.BasicAuthUsername).To(Equal(lockedUsername))
+			Expect(team.BasicAuth.BasicAuthPassword).To(HaveLen(64))
+
+			deletion, found, err := store.GetDeletion(brokerStore, "venture")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(deletion.Running).To(Equal([]string{"deploy"}))
+			Expect(deletion.DeleteAfter).To(Equal(now.Add(24 * time.Hour)))
+			Expect(notifier.Notifications[0].Event).To(Equal("team-soft-deleted"))
+		})
+
+		It("remembers the running pipelines when a deletion is retried", func() {
+			_, err := deleter.Delete("venture", []string{"space-a"}, []string{"instance-1"}, "venture-archive")
+			Expect(err).NotTo(HaveOccurred())
+			deletion, _, err := store.GetDeletion(brokerStore, "venture")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deletion.Running).To(Equal([]string{"deploy"}))
+		})
+
+		It("undeletes the team", func() {
+			result, err := deleter.Undelete("venture")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Unpaused).To(Equal([]string{"deploy"}))
+			Expect(paused("deploy")).To(BeFalse())
+			Expect(paused("nightly")).To(BeTrue())
+			Expect(concourseClient.Teams["venture"].UAAAuth.CFSpaces).To(Equal([]string{"space-a"}))
+			Expect(result.Warnings).To(HaveLen(1))
+
+			_, found, err := store.GetDeletion(brokerStore, "venture")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+			entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+			Expect(entries[0].Operation).To(Equal("undelete"))
+		})
+
+		It("refuses to undelete a team that is not deleted", func() {
+			_, err := deleter.Undelete("other")
+			Expect(err).To(Equal(ErrNotDeleted))
+		})
+
+		It("leaves the team alone during the window", func() {
+			now = now.Add(time.Hour)
+			Expect(deleter.Run()).To(Succeed())
+			Expect(concourseClient.Destroyed).To(BeEmpty())
+		})
+
+		It("destroys the team after the window", func() {
+			now = now.Add(25 * time.Hour)
+			Expect(deleter.Run()).To(Succeed())
+			Expect(concourseClient.Destroyed).To(Equal([]string{"venture"}))
+			_, found, err := store.GetTeam(brokerStore, "venture")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+			_, found, err = store.GetDeletion(brokerStore, "venture")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+			entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+			Expect(entries[0].Operation).To(Equal("soft-delete-destroy-team"))
+		})
+	})
+})
@@ -0,0 +1,50 @@
+package store
+
+import "time"
+
+const activityCollection = "activity"
+
+// Activity is what the idle detector last saw of a team.
+type Activity struct {
+	TeamName     string    `json:"team"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	CheckedAt    time.Time `json:"checked_at"`
+	// Builds are the ID of the newest build of each job, by pipeline/job
+	Builds map[string]int `json:"builds"`
+	// Versions are the config version of each pipeline
+	Versions map[string]string `json:"versions"`
+	// IdleSince is set when the team was found idle and cleared when it is active again
+	IdleSince *time.Time `json:"idle_since,omitempty"`
+	// Paused are the pipelines the idle action paused
+	Paused []string `json:"paused,omitempty"`
+}
+
+func GetActivity(s Store, teamName string) (Activity, bool, error) {
+	var activity Activity
+	found, err := s.Get(activityCollection, teamName, &activity)
+	return activity, found, err
+}
+
+func SaveActivity(s Store, activity Activity) error {
+	return s.Put(activityCollection, activity.TeamName, activity)
+}
+
+func DeleteActivity(s Store, teamName string) error {
+	return s.Delete(activityCollection, teamName)
+}
+
+func ListActivity(s Store) ([]Activity, error) {
+	keys, err := s.Keys(activityCollection)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Activity, 0, len(keys))
+	for _, key := range keys {
+		activity, _, err := GetActivity(s, key)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, activity)
+	}
+	return result, nil
+}
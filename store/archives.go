@@ -0,0 +1,69 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+const archivesCollection = "archives"
+
+// ArchiveRecord indexes an archive of a destroyed team by team and instance.
+type ArchiveRecord struct {
+	ID          string    `json:"id"`
+	TeamName    string    `json:"team"`
+	InstanceIDs []string  `json:"instance_ids"`
+	Reason      string    `json:"reason"`
+	CreatedAt   time.Time `json:"created_at"`
+	Location    string    `json:"location"`
+	Pipelines   int       `json:"pipelines"`
+}
+
+func GetArchive(s Store, id string) (ArchiveRecord, bool, error) {
+	var record ArchiveRecord
+	found, err := s.Get(archivesCollection, id, &record)
+	return record, found, err
+}
+
+func SaveArchive(s Store, record ArchiveRecord) error {
+	return s.Put(archivesCollection, record.ID, record)
+}
+
+// FindArchives returns the archives of a team, of an instance, or all of them when both are
+// empty, newest first.
+func FindArchives(s Store, teamName, instanceID string) ([]ArchiveRecord, error) {
+	keys, err := s.Keys(archivesCollection)
+	if err != nil {
+		return nil, err
+	}
+	records := []ArchiveRecord{}
+	for _, key := range keys {
+		record, _, err := GetArchive(s, key)
+		if err != nil {
+			return nil, err
+		}
+		if teamName != "" && record.TeamName != teamName {
+			continue
+		}
+		if instanceID != "" && !contains(record.InstanceIDs, instanceID) {
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Sort(newestFirst(records))
+	return records, nil
+}
+
+type newestFirst []ArchiveRecord
+
+func (a newestFirst) Len() int           { return len(a) }
+func (a newestFirst) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a newestFirst) Less(i, j int) bool { return a[i].CreatedAt.After(a[j].CreatedAt) }
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
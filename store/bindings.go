@@ -0,0 +1,33 @@
+package store
+
+import "time"
+
+const bindingsCollection = "bindings"
+
+// Binding is what the build API knows of a binding. Only hashes of its secrets are kept.
+type Binding struct {
+	ID           string `json:"id"`
+	InstanceID   string `json:"instance_id"`
+	TeamName     string `json:"team_name"`
+	PasswordHash string `json:"password_hash"`
+	// TriggerTokenHash is the hash of the secret in the trigger URL
+	TriggerTokenHash string `json:"trigger_token_hash"`
+	// Pipeline and Job are the only job the trigger URL starts, when the binding named one
+	Pipeline  string    `json:"pipeline,omitempty"`
+	Job       string    `json:"job,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func GetBinding(s Store, id string) (Binding, bool, error) {
+	var binding Binding
+	found, err := s.Get(bindingsCollection, id, &binding)
+	return binding, found, err
+}
+
+func SaveBinding(s Store, binding Binding) error {
+	return s.Put(bindingsCollection, binding.ID, binding)
+}
+
+func DeleteBinding(s Store, id string) error {
+	return s.Delete(bindingsCollection, id)
+}
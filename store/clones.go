@@ -0,0 +1,45 @@
+package store
+
+const clonesCollection = "clones"
+
+// Clone records the pipelines a team copied from a template team.
+type Clone struct {
+	TeamName   string `json:"team_name"`
+	SourceTeam string `json:"source_team"`
+	InstanceID string `json:"instance_id"`
+	// Sync re-copies template pipelines whose config version changed
+	Sync bool                   `json:"sync"`
+	Vars map[string]interface{} `json:"vars"`
+	// Versions are the template config versions of the copied pipelines, by pipeline name
+	Versions map[string]string `json:"versions"`
+}
+
+func GetClone(s Store, teamName string) (Clone, bool, error) {
+	var clone Clone
+	found, err := s.Get(clonesCollection, teamName, &clone)
+	return clone, found, err
+}
+
+func SaveClone(s Store, clone Clone) error {
+	return s.Put(clonesCollection, clone.TeamName, clone)
+}
+
+func DeleteClone(s Store, teamName string) error {
+	return s.Delete(clonesCollection, teamName)
+}
+
+func ListClones(s Store) ([]Clone, error) {
+	keys, err := s.Keys(clonesCollection)
+	if err != nil {
+		return nil, err
+	}
+	clones := make([]Clone, 0, len(keys))
+	for _, key := range keys {
+		clone, _, err := GetClone(s, key)
+		if err != nil {
+			return nil, err
+		}
+		clones = append(clones, clone)
+	}
+	return clones, nil
+}
@@ -0,0 +1,49 @@
+package store
+
+import "time"
+
+const deletionsCollection = "deletions"
+
+// Deletion records a soft deleted team that is destroyed after DeleteAfter unless it is undeleted.
+type Deletion struct {
+	TeamName    string    `json:"team_name"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	DeleteAfter time.Time `json:"delete_after"`
+	// Spaces had access to the team before it was deleted
+	Spaces      []string `json:"spaces"`
+	InstanceIDs []string `json:"instance_ids"`
+	// ArchiveID is the archive taken when the team was deleted
+	ArchiveID string `json:"archive_id"`
+	// Running are the pipelines that were unpaused before the team was deleted
+	Running []string `json:"running"`
+}
+
+func GetDeletion(s Store, teamName string) (Deletion, bool, error) {
+	var deletion Deletion
+	found, err := s.Get(deletionsCollection, teamName, &deletion)
+	return deletion, found, err
+}
+
+func SaveDeletion(s Store, deletion Deletion) error {
+	return s.Put(deletionsCollection, deletion.TeamName, deletion)
+}
+
+func DeleteDeletion(s Store, teamName string) error {
+	return s.Delete(deletionsCollection, teamName)
+}
+
+func ListDeletions(s Store) ([]Deletion, error) {
+	keys, err := s.Keys(deletionsCollection)
+	if err != nil {
+		return nil, err
+	}
+	deletions := make([]Deletion, 0, len(keys))
+	for _, key := range keys {
+		deletion, _, err := GetDeletion(s, key)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, deletion)
+	}
+	return deletions, nil
+}
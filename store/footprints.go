@@ -0,0 +1,49 @@
+package store
+
+import "time"
+
+const footprintsCollection = "footprints"
+
+// Footprint is what a team used on the workers against the limits of its plan when it was last checked.
+type Footprint struct {
+	TeamName      string `json:"team"`
+	Containers    int    `json:"containers"`
+	Volumes       int    `json:"volumes"`
+	MaxContainers int    `json:"max_containers,omitempty"`
+	MaxVolumes    int    `json:"max_volumes,omitempty"`
+	// Pipelines are the containers of each pipeline
+	Pipelines map[string]int `json:"pipelines"`
+	// ExceededAt is set when the team went over its limits and cleared when it is back within them
+	ExceededAt *time.Time `json:"exceeded_at,omitempty"`
+	CheckedAt  time.Time  `json:"checked_at"`
+}
+
+func GetFootprint(s Store, teamName string) (Footprint, bool, error) {
+	var footprint Footprint
+	found, err := s.Get(footprintsCollection, teamName, &footprint)
+	return footprint, found, err
+}
+
+func SaveFootprint(s Store, footprint Footprint) error {
+	return s.Put(footprintsCollection, footprint.TeamName, footprint)
+}
+
+func DeleteFootprint(s Store, teamName string) error {
+	return s.Delete(footprintsCollection, teamName)
+}
+
+func ListFootprints(s Store) ([]Footprint, error) {
+	keys, err := s.Keys(footprintsCollection)
+	if err != nil {
+		return nil, err
+	}
+	footprints := make([]Footprint, 0, len(keys))
+	for _, key := range keys {
+		footprint, _, err := GetFootprint(s, key)
+		if err != nil {
+			return nil, err
+		}
+		footprints = append(footprints, footprint)
+	}
+	return footprints, nil
+}
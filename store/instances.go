@@ -0,0 +1,91 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+const instancesCollection = "instances"
+
+// Instance is the broker's record of a provisioned service instance.
+type Instance struct {
+	ID        string    `json:"id"`
+	ServiceID string    `json:"service_id"`
+	PlanID    string    `json:"plan_id"`
+	OrgGUID   string    `json:"org_guid"`
+	OrgName   string    `json:"org_name"`
+	SpaceGUID string    `json:"space_guid"`
+	SpaceName string    `json:"space_name"`
+	TeamName  string    `json:"team_name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Warnings are what Concourse warned about the pipelines seeded into the team
+	Warnings []string `json:"warnings,omitempty"`
+	// IdleSince is set while the team shows no activity
+	IdleSince *time.Time `json:"idle_since,omitempty"`
+}
+
+func GetInstance(s Store, id string) (Instance, bool, error) {
+	var instance Instance
+	found, err := s.Get(instancesCollection, id, &instance)
+	return instance, found, err
+}
+
+func SaveInstance(s Store, instance Instance) error {
+	return s.Put(instancesCollection, instance.ID, instance)
+}
+
+func DeleteInstance(s Store, id string) error {
+	return s.Delete(instancesCollection, id)
+}
+
+func ListInstances(s Store) ([]Instance, error) {
+	keys, err := s.Keys(instancesCollection)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]Instance, 0, len(keys))
+	for _, key := range keys {
+		instance, _, err := GetInstance(s, key)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// TeamInstances returns the instances that refer to the team.
+func TeamInstances(s Store, teamName string) ([]Instance, error) {
+	instances, err := ListInstances(s)
+	if err != nil {
+		return nil, err
+	}
+	result := []Instance{}
+	for _, instance := range instances {
+		if instance.TeamName == teamName {
+			result = append(result, instance)
+		}
+	}
+	sort.Sort(byCreatedAt(result))
+	return result, nil
+}
+
+type byCreatedAt []Instance
+
+func (i byCreatedAt) Len() int           { return len(i) }
+func (i byCreatedAt) Swap(a, b int)      { i[a], i[b] = i[b], i[a] }
+func (i byCreatedAt) Less(a, b int) bool { return i[a].CreatedAt.Before(i[b].CreatedAt) }
+
+// SpaceGUIDs returns the distinct spaces of the instances in the order they were first seen.
+func SpaceGUIDs(instances []Instance) []string {
+	spaces := []string{}
+	seen := map[string]bool{}
+	for _, instance := range instances {
+		if instance.SpaceGUID == "" || seen[instance.SpaceGUID] {
+			continue
+		}
+		seen[instance.SpaceGUID] = true
+		spaces = append(spaces, instance.SpaceGUID)
+	}
+	return spaces
+}
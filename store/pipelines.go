@@ -0,0 +1,67 @@
+package store
+
+import "time"
+
+const pipelineInstancesCollection = "pipeline_instances"
+
+// PipelineInstance is the broker's record of an instance of the pipeline service, which manages a
+// single pipeline in the team of its org.
+type PipelineInstance struct {
+	ID           string    `json:"id"`
+	ServiceID    string    `json:"service_id"`
+	PlanID       string    `json:"plan_id"`
+	OrgGUID      string    `json:"org_guid"`
+	OrgName      string    `json:"org_name"`
+	SpaceGUID    string    `json:"space_guid"`
+	SpaceName    string    `json:"space_name"`
+	TeamName     string    `json:"team_name"`
+	PipelineName string    `json:"pipeline_name"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Warnings are what Concourse warned about the config last set
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func GetPipelineInstance(s Store, id string) (PipelineInstance, bool, error) {
+	var instance PipelineInstance
+	found, err := s.Get(pipelineInstancesCollection, id, &instance)
+	return instance, found, err
+}
+
+func SavePipelineInstance(s Store, instance PipelineInstance) error {
+	return s.Put(pipelineInstancesCollection, instance.ID, instance)
+}
+
+func DeletePipelineInstance(s Store, id string) error {
+	return s.Delete(pipelineInstancesCollection, id)
+}
+
+func ListPipelineInstances(s Store) ([]PipelineInstance, error) {
+	keys, err := s.Keys(pipelineInstancesCollection)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]PipelineInstance, 0, len(keys))
+	for _, key := range keys {
+		instance, _, err := GetPipelineInstance(s, key)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// TeamPipelineInstances returns the pipeline instances that manage a pipeline of the team.
+func TeamPipelineInstances(s Store, teamName string) ([]PipelineInstance, error) {
+	instances, err := ListPipelineInstances(s)
+	if err != nil {
+		return nil, err
+	}
+	result := []PipelineInstance{}
+	for _, instance := range instances {
+		if instance.TeamName == teamName {
+			result = append(result, instance)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,31 @@
+package store
+
+import "time"
+
+const provisionsCollection = "provisions"
+
+// Provision is the journal of a provision that has not finished. It lists the steps that were
+// completed so they can be undone when the provision fails or the platform gives up on it.
+type Provision struct {
+	InstanceID string   `json:"instance_id"`
+	TeamName   string   `json:"team_name"`
+	SpaceGUID  string   `json:"space_guid"`
+	Steps      []string `json:"steps"`
+	// Pipelines are the pipelines the provision seeded
+	Pipelines []string  `json:"pipelines,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func GetProvision(s Store, instanceID string) (Provision, bool, error) {
+	var provision Provision
+	found, err := s.Get(provisionsCollection, instanceID, &provision)
+	return provision, found, err
+}
+
+func SaveProvision(s Store, provision Provision) error {
+	return s.Put(provisionsCollection, provision.InstanceID, provision)
+}
+
+func DeleteProvision(s Store, instanceID string) error {
+	return s.Delete(provisionsCollection, instanceID)
+}
@@ -0,0 +1,49 @@
+package store
+
+import "time"
+
+const quotasCollection = "quotas"
+
+// Quota is the pipeline usage of a team against the limits of its plan when it was last checked.
+type Quota struct {
+	TeamName     string `json:"team"`
+	Pipelines    int    `json:"pipelines"`
+	Jobs         int    `json:"jobs"`
+	MaxPipelines int    `json:"max_pipelines,omitempty"`
+	MaxJobs      int    `json:"max_jobs,omitempty"`
+	// ExceededAt is set when the team went over its limits and cleared when it is back within them
+	ExceededAt *time.Time `json:"exceeded_at,omitempty"`
+	CheckedAt  time.Time  `json:"checked_at"`
+	// Seen is when each pipeline was first counted, it tells which pipelines are the newest
+	Seen map[string]time.Time `json:"seen"`
+}
+
+func GetQuota(s Store, teamName string) (Quota, bool, error) {
+	var quota Quota
+	found, err := s.Get(quotasCollection, teamName, &quota)
+	return quota, found, err
+}
+
+func SaveQuota(s Store, quota Quota) error {
+	return s.Put(quotasCollection, quota.TeamName, quota)
+}
+
+func DeleteQuota(s Store, teamName string) error {
+	return s.Delete(quotasCollection, teamName)
+}
+
+func ListQuotas(s Store) ([]Quota, error) {
+	keys, err := s.Keys(quotasCollection)
+	if err != nil {
+		return nil, err
+	}
+	quotas := make([]Quota, 0, len(keys))
+	for _, key := range keys {
+		quota, _, err := GetQuota(s, key)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+	return quotas, nil
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store keeps the broker's own records. Records are JSON documents grouped into collections.
+type Store interface {
+	Get(collection, key string, value interface{}) (bool, error)
+	Put(collection, key string, value interface{}) error
+	Delete(collection, key string) error
+	Keys(collection string) ([]string, error)
+	// Append adds a record to a log, a collection that only grows. Logs are kept apart from the
+	// other records, so appending does not rewrite them.
+	Append(log string, value interface{}) error
+	// Records returns the records of a log in the order they were appended.
+	Records(log string) ([]json.RawMessage, error)
+}
+
+// NewFileStore returns a store that persists all records to a single JSON file at path. Every log
+// goes to a JSON lines file next to it, e.g. store-audit.jsonl for the audit log of store.json.
+func NewFileStore(path string) (Store, error) {
+	s := &fileStore{path: path, data: map[string]map[string]json.RawMessage{}}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return s, nil
+	}
+	err = json.Unmarshal(buf, &s.data)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewMemoryStore returns a store that only keeps its records in memory.
+func NewMemoryStore() Store {
+	return &fileStore{data: map[string]map[string]json.RawMessage{}, logs: map[string][]json.RawMessage{}}
+}
+
+type fileStore struct {
+	path string
+	data map[string]map[string]json.RawMessage
+	// logs are only kept in memory by a store without a path
+	logs map[string][]json.RawMessage
+	mu   sync.Mutex
+}
+
+func (s *fileStore) Get(collection, key string, value interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw, ok := s.data[collection][key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, value)
+}
+
+func (s *fileStore) Put(collection, key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data[collection] == nil {
+		s.data[collection] = map[string]json.RawMessage{}
+	}
+	s.data[collection][key] = raw
+	return s.save()
+}
+
+func (s *fileStore) Delete(collection, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[collection][key]; !ok {
+		return nil
+	}
+	delete(s.data[collection], key)
+	return s.save()
+}
+
+func (s *fileStore) Keys(collection string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.data[collection]))
+	for key := range s.data[collection] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// save writes the records to a temporary file first so a crash never leaves a truncated store behind.
+func (s *fileStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	buf, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *fileStore) Append(log string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		s.logs[log] = append(s.logs[log], raw)
+		return nil
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.logPath(log), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(raw, '\n'))
+	return err
+}
+
+func (s *fileStore) Records(log string) ([]json.RawMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := []json.RawMessage{}
+	if s.path == "" {
+		return append(records, s.logs[log]...), nil
+	}
+	f, err := os.Open(s.logPath(log))
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		records = append(records, json.RawMessage(append([]byte{}, line...)))
+	}
+	return records, scanner.Err()
+}
+
+func (s *fileStore) logPath(log string) string {
+	return strings.TrimSuffix(s.path, filepath.Ext(s.path)) + "-" + log + ".jsonl"
+}
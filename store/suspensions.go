@@ -0,0 +1,27 @@
+package store
+
+import "time"
+
+const suspensionsCollection = "suspensions"
+
+// Suspension records a team whose pipelines were paused because an instance moved to a suspending plan.
+type Suspension struct {
+	TeamName    string    `json:"team_name"`
+	SuspendedAt time.Time `json:"suspended_at"`
+	// Running are the pipelines that were unpaused before the team was suspended
+	Running []string `json:"running"`
+}
+
+func GetSuspension(s Store, teamName string) (Suspension, bool, error) {
+	var suspension Suspension
+	found, err := s.Get(suspensionsCollection, teamName, &suspension)
+	return suspension, found, err
+}
+
+func SaveSuspension(s Store, suspension Suspension) error {
+	return s.Put(suspensionsCollection, suspension.TeamName, suspension)
+}
+
+func DeleteSuspension(s Store, teamName string) error {
+	return s.Delete(suspensionsCollection, teamName)
+}
@@ -0,0 +1,48 @@
+package store
+
+import "time"
+
+const teamsCollection = "teams"
+
+// Team is the broker's proof that it created a Concourse team.
+type Team struct {
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// OrphanedAt is set when no service instance refers to the team any more.
+	OrphanedAt *time.Time `json:"orphaned_at,omitempty"`
+	// WorkerTag is the tag of the workers of the isolation segment of the team's spaces
+	WorkerTag string `json:"worker_tag,omitempty"`
+	// ConfigDigest is the digest of the team config the reconciler set last
+	ConfigDigest string `json:"config_digest,omitempty"`
+}
+
+func GetTeam(s Store, name string) (Team, bool, error) {
+	var team Team
+	found, err := s.Get(teamsCollection, name, &team)
+	return team, found, err
+}
+
+func SaveTeam(s Store, team Team) error {
+	return s.Put(teamsCollection, team.Name, team)
+}
+
+func DeleteTeam(s Store, name string) error {
+	return s.Delete(teamsCollection, name)
+}
+
+func ListTeams(s Store) ([]Team, error) {
+	keys, err := s.Keys(teamsCollection)
+	if err != nil {
+		return nil, err
+	}
+	teams := make([]Team, 0, len(keys))
+	for _, key := range keys {
+		team, _, err := GetTeam(s, key)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+	return teams, nil
+}
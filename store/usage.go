@@ -0,0 +1,79 @@
+package store
+
+const (
+	usageCollection   = "usage"
+	cursorsCollection = "build-cursors"
+)
+
+// JobUsage is the builds of a job on a day. One-off builds have no pipeline and job.
+type JobUsage struct {
+	Pipeline string `json:"pipeline,omitempty"`
+	Job      string `json:"job,omitempty"`
+	Builds   int    `json:"builds"`
+	// Seconds is the total time the builds ran
+	Seconds int64 `json:"seconds"`
+}
+
+// Usage is the daily rollup of the builds of a team, by the day they finished in UTC.
+type Usage struct {
+	Date       string     `json:"date"`
+	TeamName   string     `json:"team"`
+	OrgGUID    string     `json:"org_guid"`
+	OrgName    string     `json:"org_name"`
+	SpaceGUIDs []string   `json:"space_guids"`
+	Jobs       []JobUsage `json:"jobs"`
+}
+
+// BuildCursor remembers which builds of a job have been counted: every build up to After, and
+// the builds in Counted, which finished while an older build was still running.
+type BuildCursor struct {
+	Key     string `json:"key"`
+	After   int    `json:"after"`
+	Counted []int  `json:"counted,omitempty"`
+}
+
+func usageKey(date, teamName string) string {
+	return date + "/" + teamName
+}
+
+func GetUsage(s Store, date, teamName string) (Usage, bool, error) {
+	var usage Usage
+	found, err := s.Get(usageCollection, usageKey(date, teamName), &usage)
+	return usage, found, err
+}
+
+func SaveUsage(s Store, usage Usage) error {
+	return s.Put(usageCollection, usageKey(usage.Date, usage.TeamName), usage)
+}
+
+// ListUsage returns the rollups from the day from up to and including the day to, by day and team.
+// Empty bounds are open.
+func ListUsage(s Store, from, to string) ([]Usage, error) {
+	keys, err := s.Keys(usageCollection)
+	if err != nil {
+		return nil, err
+	}
+	result := []Usage{}
+	for _, key := range keys {
+		var usage Usage
+		_, err := s.Get(usageCollection, key, &usage)
+		if err != nil {
+			return nil, err
+		}
+		if (from != "" && usage.Date < from) || (to != "" && usage.Date > to) {
+			continue
+		}
+		result = append(result, usage)
+	}
+	return result, nil
+}
+
+func GetBuildCursor(s Store, key string) (BuildCursor, error) {
+	cursor := BuildCursor{Key: key}
+	_, err := s.Get(cursorsCollection, key, &cursor)
+	return cursor, err
+}
+
+func SaveBuildCursor(s Store, cursor BuildCursor) error {
+	return s.Put(cursorsCollection, cursor.Key, cursor)
+}
@@ -0,0 +1,61 @@
+package store
+
+import "time"
+
+const workerKeysCollection = "worker_keys"
+
+// WorkerKey is the public key a binding registers a team worker with.
+type WorkerKey struct {
+	BindingID  string `json:"binding_id"`
+	InstanceID string `json:"instance_id"`
+	TeamName   string `json:"team_name"`
+	// WorkerName is the name the worker must register with, so it can be pruned when the key is revoked
+	WorkerName string    `json:"worker_name"`
+	PublicKey  string    `json:"public_key"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func GetWorkerKey(s Store, bindingID string) (WorkerKey, bool, error) {
+	var key WorkerKey
+	found, err := s.Get(workerKeysCollection, bindingID, &key)
+	return key, found, err
+}
+
+func SaveWorkerKey(s Store, key WorkerKey) error {
+	return s.Put(workerKeysCollection, key.BindingID, key)
+}
+
+func DeleteWorkerKey(s Store, bindingID string) error {
+	return s.Delete(workerKeysCollection, bindingID)
+}
+
+func ListWorkerKeys(s Store) ([]WorkerKey, error) {
+	keys, err := s.Keys(workerKeysCollection)
+	if err != nil {
+		return nil, err
+	}
+	workerKeys := make([]WorkerKey, 0, len(keys))
+	for _, key := range keys {
+		workerKey, _, err := GetWorkerKey(s, key)
+		if err != nil {
+			return nil, err
+		}
+		workerKeys = append(workerKeys, workerKey)
+	}
+	return workerKeys, nil
+}
+
+// TeamWorkerKeys returns the worker keys of a team.
+func TeamWorkerKeys(s Store, teamName string) ([]WorkerKey, error) {
+	all, err := ListWorkerKeys(s)
+	if err != nil {
+		return nil, err
+	}
+	keys := []WorkerKey{}
+	for _, key := range all {
+		if key.TeamName == teamName {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,98 @@
+package usage
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// Query selects rollups. Dates are days in UTC like 2017-06-30, zero values match everything.
+type Query struct {
+	From      string
+	To        string
+	OrgGUID   string
+	SpaceGUID string
+}
+
+// Row is the usage of a job on a day, keyed by the CF org and spaces of the team it ran in.
+type Row struct {
+	Date       string   `json:"date"`
+	OrgGUID    string   `json:"org_guid"`
+	OrgName    string   `json:"org_name"`
+	SpaceGUIDs []string `json:"space_guids"`
+	TeamName   string   `json:"team"`
+	Pipeline   string   `json:"pipeline"`
+	Job        string   `json:"job"`
+	Builds     int      `json:"builds"`
+	Seconds    int64    `json:"seconds"`
+}
+
+// Rows returns the usage the query selects by day, team, pipeline and job.
+func Rows(s store.Store, query Query) ([]Row, error) {
+	rollups, err := store.ListUsage(s, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+	rows := []Row{}
+	for _, usage := range rollups {
+		if query.OrgGUID != "" && usage.OrgGUID != query.OrgGUID {
+			continue
+		}
+		if query.SpaceGUID != "" && !contains(usage.SpaceGUIDs, query.SpaceGUID) {
+			continue
+		}
+		for _, job := range usage.Jobs {
+			rows = append(rows, Row{
+				Date:       usage.Date,
+				OrgGUID:    usage.OrgGUID,
+				OrgName:    usage.OrgName,
+				SpaceGUIDs: usage.SpaceGUIDs,
+				TeamName:   usage.TeamName,
+				Pipeline:   job.Pipeline,
+				Job:        job.Job,
+				Builds:     job.Builds,
+				Seconds:    job.Seconds,
+			})
+		}
+	}
+	return rows, nil
+}
+
+// WriteCSV writes the rows with a header. The space GUIDs of a row are separated by spaces.
+func WriteCSV(w io.Writer, rows []Row) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"date", "org_guid", "org_name", "space_guids", "team", "pipeline", "job", "builds", "seconds"})
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err = writer.Write([]string{
+			row.Date,
+			row.OrgGUID,
+			row.OrgName,
+			strings.Join(row.SpaceGUIDs, " "),
+			row.TeamName,
+			row.Pipeline,
+			row.Job,
+			strconv.Itoa(row.Builds),
+			strconv.FormatInt(row.Seconds, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,307 @@
+package usage
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// oneOffCursor is the cursor of the builds that do not belong to a job.
+const oneOffCursor = "one-off"
+
+// dateFormat is the format of the day of a rollup.
+const dateFormat = "2006-01-02"
+
+// Report is the outcome of a collector run.
+type Report struct {
+	Time time.Time `json:"time"`
+	// Builds is how many finished builds the run counted
+	Builds int    `json:"builds"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Collector counts the finished builds of every team the broker created, and how long they ran,
+// into daily rollups by pipeline and job. Every build is counted once, on the day it finished.
+type Collector struct {
+	store           store.Store
+	lock            sync.Locker
+	concourseClient concourse.Client
+	logger          lager.Logger
+
+	mu   sync.Mutex
+	last Report
+}
+
+// New returns a collector.
+func New(s store.Store, lock sync.Locker, concourseClient concourse.Client, logger lager.Logger) *Collector {
+	return &Collector{
+		store:           s,
+		lock:            lock,
+		concourseClient: concourseClient,
+		logger:          logger.Session("usage"),
+	}
+}
+
+// Run collects once.
+func (c *Collector) Run() (Report, error) {
+	report := Report{Time: time.Now().UTC()}
+	err := c.run(&report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	c.mu.Lock()
+	c.last = report
+	c.mu.Unlock()
+	return report, err
+}
+
+// LastReport returns the report of the most recent run.
+func (c *Collector) LastReport() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+func (c *Collector) run(report *Report) error {
+	teams, err := c.snapshot()
+	if err != nil {
+		return err
+	}
+	teamNames := []string{}
+	managed := map[string]bool{}
+	for _, team := range teams {
+		teamNames = append(teamNames, team.Name)
+		managed[team.Name] = true
+	}
+	sort.Strings(teamNames)
+	var lastErr error
+	for _, teamName := range teamNames {
+		builds, err := c.collectTeam(teamName)
+		report.Builds += builds
+		if err != nil {
+			c.logger.Error("collect-error", err, lager.Data{"team-name": teamName})
+			lastErr = err
+		}
+	}
+	builds, err := c.collectOneOff(managed)
+	report.Builds += builds
+	if err != nil {
+		c.logger.Error("collect-one-off-error", err)
+		lastErr = err
+	}
+	return lastErr
+}
+
+// snapshot returns the managed teams. The builds are paged without the lock, which takes long on
+// a first run, and teams that go away meanwhile keep the usage counted so far.
+func (c *Collector) snapshot() ([]store.Team, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return store.ListTeams(c.store)
+}
+
+func (c *Collector) collectTeam(teamName string) (int, error) {
+	counted := 0
+	pipelines, err := c.concourseClient.ListPipelines(teamName)
+	if err != nil {
+		return counted, err
+	}
+	for _, pipeline := range pipelines {
+		pipelineConfig, _, _, found, err := c.concourseClient.PipelineConfig(teamName, pipeline.Name)
+		if err != nil {
+			return counted, err
+		}
+		if !found {
+			// deleted while collecting
+			continue
+		}
+		for _, job := range pipelineConfig.Jobs {
+			read, err := c.cursor(teamName + "/" + pipeline.Name + "/" + job.Name)
+			if err != nil {
+				return counted, err
+			}
+			builds, err := c.concourseClient.JobBuilds(teamName, pipeline.Name, job.Name, read.After)
+			if err != nil {
+				return counted, err
+			}
+			cursor := read
+			finished := advance(&cursor, builds, countAll)
+			if len(finished) == 0 && cursor.After == read.After {
+				// every save rewrites the store, jobs without new builds are left alone
+				continue
+			}
+			added, err := c.count(read, cursor, map[string][]atc.Build{teamName: finished})
+			if err != nil {
+				return counted, err
+			}
+			counted += added
+		}
+	}
+	return counted, nil
+}
+
+// collectOneOff counts the builds of the managed teams that were started with fly execute.
+func (c *Collector) collectOneOff(managed map[string]bool) (int, error) {
+	read, err := c.cursor(oneOffCursor)
+	if err != nil {
+		return 0, err
+	}
+	builds, err := c.concourseClient.Builds(read.After)
+	if err != nil {
+		return 0, err
+	}
+	cursor := read
+	// the builds of jobs are counted by job and the ones of other teams not at all
+	finished := advance(&cursor, builds, func(build atc.Build) bool {
+		return build.JobName == "" && managed[build.TeamName]
+	})
+	byTeam := map[string][]atc.Build{}
+	for _, build := range finished {
+		byTeam[build.TeamName] = append(byTeam[build.TeamName], build)
+	}
+	return c.count(read, cursor, byTeam)
+}
+
+func (c *Collector) cursor(key string) (store.BuildCursor, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return store.GetBuildCursor(c.store, key)
+}
+
+// count adds the builds to the rollups of their teams and moves the cursor on, under the lock.
+// When the cursor is no longer the one the builds were paged from, another run counted them
+// meanwhile and nothing is added.
+func (c *Collector) count(read, cursor store.BuildCursor, byTeam map[string][]atc.Build) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	current, err := store.GetBuildCursor(c.store, cursor.Key)
+	if err != nil {
+		return 0, err
+	}
+	if !reflect.DeepEqual(normalized(current), normalized(read)) {
+		return 0, nil
+	}
+	counted := 0
+	for teamName, teamBuilds := range byTeam {
+		instances, err := store.TeamInstances(c.store, teamName)
+		if err != nil {
+			return counted, err
+		}
+		err = c.add(teamName, instances, teamBuilds)
+		if err != nil {
+			return counted, err
+		}
+		counted += len(teamBuilds)
+	}
+	return counted, store.SaveBuildCursor(c.store, cursor)
+}
+
+// normalized returns the cursor with an empty Counted list, which is left out when it is stored.
+func normalized(cursor store.BuildCursor) store.BuildCursor {
+	if len(cursor.Counted) == 0 {
+		cursor.Counted = nil
+	}
+	return cursor
+}
+
+// advance returns the finished builds the cursor has not counted yet and moves the cursor past
+// them. The cursor stops before the oldest build that is still running, so it is counted once it
+// finishes. Builds that are not counted do not hold the cursor back.
+func advance(cursor *store.BuildCursor, builds []atc.Build, counts func(atc.Build) bool) []atc.Build {
+	counted := map[int]bool{}
+	for _, id := range cursor.Counted {
+		counted[id] = true
+	}
+	finished := []atc.Build{}
+	highest := cursor.After
+	running := 0
+	for _, build := range builds {
+		if build.ID <= cursor.After {
+			continue
+		}
+		if build.ID > highest {
+			highest = build.ID
+		}
+		if !counts(build) {
+			continue
+		}
+		if build.EndTime == 0 {
+			if running == 0 || build.ID < running {
+				running = build.ID
+			}
+			continue
+		}
+		if counted[build.ID] {
+			continue
+		}
+		counted[build.ID] = true
+		finished = append(finished, build)
+	}
+	cursor.After = highest
+	if running > 0 {
+		cursor.After = running - 1
+	}
+	cursor.Counted = []int{}
+	for id := range counted {
+		if id > cursor.After {
+			cursor.Counted = append(cursor.Counted, id)
+		}
+	}
+	sort.Ints(cursor.Counted)
+	return finished
+}
+
+func countAll(atc.Build) bool { return true }
+
+// add adds the builds of a team to its daily rollups.
+func (c *Collector) add(teamName string, instances []store.Instance, builds []atc.Build) error {
+	days := map[string][]atc.Build{}
+	for _, build := range builds {
+		date := time.Unix(build.EndTime, 0).UTC().Format(dateFormat)
+		days[date] = append(days[date], build)
+	}
+	for date, dayBuilds := range days {
+		usage, found, err := store.GetUsage(c.store, date, teamName)
+		if err != nil {
+			return err
+		}
+		if !found {
+			usage = store.Usage{Date: date, TeamName: teamName, SpaceGUIDs: []string{}, Jobs: []store.JobUsage{}}
+		}
+		// a team no instance refers to any more keeps the org and spaces it was counted for
+		if len(instances) > 0 {
+			usage.OrgGUID = instances[0].OrgGUID
+			usage.OrgName = instances[0].OrgName
+			usage.SpaceGUIDs = store.SpaceGUIDs(instances)
+		}
+		for _, build := range dayBuilds {
+			usage.Jobs = addBuild(usage.Jobs, build)
+		}
+		err = store.SaveUsage(c.store, usage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addBuild(jobs []store.JobUsage, build atc.Build) []store.JobUsage {
+	seconds := int64(0)
+	if build.StartTime > 0 && build.EndTime > build.StartTime {
+		seconds = build.EndTime - build.StartTime
+	}
+	for i := range jobs {
+		if jobs[i].Pipeline == build.PipelineName && jobs[i].Job == build.JobName {
+			jobs[i].Builds++
+			jobs[i].Seconds += seconds
+			return jobs
+		}
+	}
+	return append(jobs, store.JobUsage{Pipeline: build.PipelineName, Job: build.JobName, Builds: 1, Seconds: seconds})
+}
@@ -0,0 +1,136 @@
+package usage
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Collector", func() {
+	var (
+		brokerStore     store.Store
+		concourseClient *fakes.ConcourseClient
+		day             time.Time
+	)
+
+	build := func(id int, job string, start, end time.Duration) atc.Build {
+		b := atc.Build{ID: id, TeamName: "venture", PipelineName: "deploy", JobName: job, StartTime: day.Add(start).Unix()}
+		if end > 0 {
+			b.EndTime = day.Add(end).Unix()
+		}
+		if job == "" {
+			b.PipelineName = ""
+		}
+		return b
+	}
+
+	run := func() Report {
+		collector := New(brokerStore, &sync.Mutex{}, concourseClient, lagertest.NewTestLogger("usage"))
+		report, err := collector.Run()
+		Expect(err).NotTo(HaveOccurred())
+		return report
+	}
+
+	BeforeEach(func() {
+		brokerStore = store.NewMemoryStore()
+		concourseClient = fakes.NewConcourseClient()
+		day = time.Date(2017, 6, 30, 0, 0, 0, 0, time.UTC)
+		concourseClient.AddPipeline("venture", atc.Pipeline{Name: "deploy"}, atc.Config{Jobs: atc.JobConfigs{{Name: "unit"}, {Name: "push"}}})
+		Expect(store.SaveTeam(brokerStore, store.Team{Name: "venture"})).To(Succeed())
+		Expect(store.SaveInstance(brokerStore, store.Instance{ID: "instance-1", TeamName: "venture", OrgGUID: "org-1", OrgName: "acme", SpaceGUID: "space-a"})).To(Succeed())
+	})
+
+	It("rolls finished builds up by day, pipeline and job", func() {
+		concourseClient.AllBuilds = []atc.Build{
+			build(5, "", time.Hour, time.Hour+30*time.Second),
+			build(4, "unit", 25*time.Hour, 25*time.Hour+time.Minute),
+			build(3, "push", 2*time.Hour, 2*time.Hour+10*time.Second),
+			build(2, "unit", time.Hour, time.Hour+2*time.Minute),
+			build(1, "unit", 0, time.Minute),
+			{ID: 6, TeamName: "other", StartTime: day.Unix(), EndTime: day.Add(time.Minute).Unix()},
+		}
+		Expect(run().Builds).To(Equal(5))
+
+		usage, found, err := store.GetUsage(brokerStore, "2017-06-30", "venture")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(usage.OrgGUID).To(Equal("org-1"))
+		Expect(usage.SpaceGUIDs).To(Equal([]string{"space-a"}))
+		Expect(usage.Jobs).To(ConsistOf(
+			store.JobUsage{Pipeline: "deploy", Job: "unit", Builds: 2, Seconds: 180},
+			store.JobUsage{Pipeline: "deploy", Job: "push", Builds: 1, Seconds: 10},
+			store.JobUsage{Builds: 1, Seconds: 30},
+		))
+		usage, _, _ = store.GetUsage(brokerStore, "2017-07-01", "venture")
+		Expect(usage.Jobs).To(Equal([]store.JobUsage{{Pipeline: "deploy", Job: "unit", Builds: 1, Seconds: 60}}))
+
+		Expect(run().Builds).To(Equal(0))
+	})
+
+	It("counts running builds once they finish", func() {
+		concourseClient.AllBuilds = []atc.Build{
+			build(3, "unit", 2*time.Hour, 2*time.Hour+time.Minute),
+			build(2, "unit", time.Hour, 0),
+			build(1, "unit", 0, time.Minute),
+		}
+		Expect(run().Builds).To(Equal(2))
+		cursor, err := store.GetBuildCursor(brokerStore, "venture/deploy/unit")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cursor.After).To(Equal(1))
+		Expect(cursor.Counted).To(Equal([]int{3}))
+
+		concourseClient.AllBuilds[1] = build(2, "unit", time.Hour, time.Hour+time.Minute)
+		Expect(run().Builds).To(Equal(1))
+		Expect(run().Builds).To(Equal(0))
+		usage, _, _ := store.GetUsage(brokerStore, "2017-06-30", "venture")
+		Expect(usage.Jobs).To(Equal([]store.JobUsage{{Pipeline: "deploy", Job: "unit", Builds: 3, Seconds: 180}}))
+	})
+
+	It("leaves builds alone that another run counted meanwhile", func() {
+		collector := New(brokerStore, &sync.Mutex{}, concourseClient, lagertest.NewTestLogger("usage"))
+		read := store.BuildCursor{Key: "venture/deploy/unit"}
+		Expect(store.SaveBuildCursor(brokerStore, store.BuildCursor{Key: "venture/deploy/unit", After: 1})).To(Succeed())
+		counted, err := collector.count(read, store.BuildCursor{Key: "venture/deploy/unit", After: 1},
+			map[string][]atc.Build{"venture": {build(1, "unit", 0, time.Minute)}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(counted).To(Equal(0))
+		_, found, _ := store.GetUsage(brokerStore, "2017-06-30", "venture")
+		Expect(found).To(BeFalse())
+	})
+
+	Describe("export", func() {
+		BeforeEach(func() {
+			Expect(store.SaveUsage(brokerStore, store.Usage{Date: "2017-06-29", TeamName: "venture", OrgGUID: "org-1", OrgName: "acme",
+				SpaceGUIDs: []string{"space-a", "space-b"}, Jobs: []store.JobUsage{{Pipeline: "deploy", Job: "unit", Builds: 2, Seconds: 90}}})).To(Succeed())
+			Expect(store.SaveUsage(brokerStore, store.Usage{Date: "2017-06-30", TeamName: "other", OrgGUID: "org-2",
+				Jobs: []store.JobUsage{{Pipeline: "build", Job: "test", Builds: 1, Seconds: 10}}})).To(Succeed())
+		})
+
+		It("selects rows by day, org and space", func() {
+			rows, err := Rows(brokerStore, Query{From: "2017-06-30"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rows).To(HaveLen(1))
+			Expect(rows[0].TeamName).To(Equal("other"))
+
+			rows, _ = Rows(brokerStore, Query{SpaceGUID: "space-b"})
+			Expect(rows).To(HaveLen(1))
+			Expect(rows[0].OrgGUID).To(Equal("org-1"))
+			Expect(rows[0].Builds).To(Equal(2))
+		})
+
+		It("writes CSV", func() {
+			rows, _ := Rows(brokerStore, Query{OrgGUID: "org-1"})
+			buf := &bytes.Buffer{}
+			Expect(WriteCSV(buf, rows)).To(Succeed())
+			Expect(buf.String()).To(Equal("date,org_guid,org_name,space_guids,team,pipeline,job,builds,seconds\n" +
+				"2017-06-29,org-1,acme,space-a space-b,venture,deploy,unit,2,90\n"))
+		})
+	})
+})
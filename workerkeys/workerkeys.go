@@ -0,0 +1,372 @@
+package workerkeys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+// keyBits is the size of the generated worker keys.
+const keyBits = 2048
+
+// Credentials are what a binding hands out to register a team worker with the TSA.
+type Credentials struct {
+	TSAHost string `json:"tsa_host"`
+	TSAPort string `json:"tsa_port"`
+	// TSAPublicKey is the host key of the TSA, for the known hosts of the worker
+	TSAPublicKey     string `json:"tsa_public_key,omitempty"`
+	Team             string `json:"team"`
+	WorkerName       string `json:"worker_name"`
+	WorkerPrivateKey string `json:"worker_private_key"`
+	WorkerPublicKey  string `json:"worker_public_key"`
+}
+
+// Report is the outcome of a prune run.
+type Report struct {
+	Time time.Time `json:"time"`
+	// Revoked are the bindings whose instance is gone
+	Revoked []string `json:"revoked"`
+	Pruned  []string `json:"pruned"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Registry generates the keys team workers register with and keeps an authorized keys file per
+// team in WORKER_KEYS_DIR, for the TSA to read with --team-authorized-keys. Workers registered
+// with a key that is gone are pruned.
+type Registry struct {
+	store           store.Store
+	auditLog        audit.Log
+	concourseClient concourse.Client
+	logger          lager.Logger
+	dir             string
+	tsaHost         string
+	tsaPort         string
+	tsaPublicKey    string
+	now             func() time.Time
+
+	mu   sync.Mutex
+	last Report
+}
+
+// New returns a registry.
+func New(s store.Store, auditLog audit.Log, concourseClient concourse.Client, logger lager.Logger, env config.Env) *Registry {
+	dir := env.WorkerKeysDir
+	if dir == "" {
+		dir = filepath.Join(env.DataDir, "worker-keys")
+	}
+	return &Registry{
+		store:           s,
+		auditLog:        auditLog,
+		concourseClient: concourseClient,
+		logger:          logger.Session("worker-keys"),
+		dir:             dir,
+		tsaHost:         env.TSAHost,
+		tsaPort:         env.TSAPort,
+		tsaPublicKey:    env.TSAPublicKey,
+		now:             time.Now,
+	}
+}
+
+// WorkerName returns the name a worker registered through a binding must have.
+func WorkerName(teamName, bindingID string) string {
+	return teamName + "-worker-" + bindingID
+}
+
+// Register generates a key pair for a binding of an instance and authorizes its public key for
+// the team of the instance.
+func (r *Registry) Register(instance store.Instance, bindingID string) (Credentials, error) {
+	if r.tsaHost == "" {
+		return Credentials{}, errors.New("TSA_HOST is not set, team workers cannot be registered")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, found, err := store.GetWorkerKey(r.store, bindingID)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if found {
+		return Credentials{}, fmt.Errorf("Binding %s already has a worker key", bindingID)
+	}
+	privateKey, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return Credentials{}, err
+	}
+	key := store.WorkerKey{
+		BindingID:  bindingID,
+		InstanceID: instance.ID,
+		TeamName:   instance.TeamName,
+		WorkerName: WorkerName(instance.TeamName, bindingID),
+		PublicKey:  marshalPublicKey(&privateKey.PublicKey),
+		CreatedAt:  r.now().UTC(),
+	}
+	err = store.SaveWorkerKey(r.store, key)
+	if err != nil {
+		return Credentials{}, err
+	}
+	err = r.writeTeam(key.TeamName, "")
+	if err != nil {
+		// a key the TSA does not know must not be handed out
+		deleteErr := store.DeleteWorkerKey(r.store, bindingID)
+		if deleteErr != nil {
+			r.logger.Error("register.delete-key-error", deleteErr, lager.Data{"binding-id": bindingID})
+		}
+		return Credentials{}, err
+	}
+	r.logger.Info("registered", lager.Data{"team-name": key.TeamName, "worker-name": key.WorkerName})
+	return Credentials{
+		TSAHost:      r.tsaHost,
+		TSAPort:      r.tsaPort,
+		TSAPublicKey: r.tsaPublicKey,
+		Team:         key.TeamName,
+		WorkerName:   key.WorkerName,
+		WorkerPrivateKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		})),
+		WorkerPublicKey: key.PublicKey,
+	}, nil
+}
+
+// Revoke removes the key of a binding from the authorized keys of its team and prunes its worker.
+// It tells whether the binding had a key.
+func (r *Registry) Revoke(bindingID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, found, err := store.GetWorkerKey(r.store, bindingID)
+	if err != nil || !found {
+		return found, err
+	}
+	err = r.revoke(key)
+	if err != nil {
+		return true, err
+	}
+	// a worker that still runs cannot be pruned yet, the next prune run gets it once it stalled
+	_, err = r.pruneTeam(key.TeamName)
+	if err != nil {
+		r.logger.Error("revoke.prune-error", err, lager.Data{"team-name": key.TeamName})
+	}
+	return true, nil
+}
+
+// Run revokes the keys of instances that are gone and prunes the workers of every team whose key
+// was revoked.
+func (r *Registry) Run() (Report, error) {
+	report := Report{Time: r.now().UTC(), Revoked: []string{}, Pruned: []string{}}
+	err := r.run(&report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	r.mu.Lock()
+	r.last = report
+	r.mu.Unlock()
+	return report, err
+}
+
+// LastReport returns the report of the most recent run.
+func (r *Registry) LastReport() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// AuthorizedKeys returns the authorized keys of a team in the format of an authorized_keys file.
+func (r *Registry) AuthorizedKeys(teamName string) (string, error) {
+	return r.authorizedKeys(teamName, "")
+}
+
+// authorizedKeys returns the authorized keys of a team without the key of a binding.
+func (r *Registry) authorizedKeys(teamName, exceptBindingID string) (string, error) {
+	keys, err := store.TeamWorkerKeys(r.store, teamName)
+	if err != nil {
+		return "", err
+	}
+	sort.Sort(byWorkerName(keys))
+	lines := ""
+	for _, key := range keys {
+		if key.BindingID == exceptBindingID {
+			continue
+		}
+		lines += key.PublicKey + " " + key.WorkerName + "\n"
+	}
+	return lines, nil
+}
+
+func (r *Registry) run(report *Report) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys, err := store.ListWorkerKeys(r.store)
+	if err != nil {
+		return err
+	}
+	// a purged instance is gone without being unbound first
+	for _, key := range keys {
+		_, found, err := store.GetInstance(r.store, key.InstanceID)
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+		err = r.revoke(key)
+		if err != nil {
+			return err
+		}
+		report.Revoked = append(report.Revoked, key.BindingID)
+	}
+	teams, err := store.ListTeams(r.store)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, team := range teams {
+		pruned, err := r.pruneTeam(team.Name)
+		report.Pruned = append(report.Pruned, pruned...)
+		if err != nil {
+			r.logger.Error("prune-error", err, lager.Data{"team-name": team.Name})
+			lastErr = err
+		}
+	}
+	sort.Strings(report.Pruned)
+	return lastErr
+}
+
+// revoke takes the key out of the authorized keys file of its team before it deletes the key, so a
+// failed write leaves the key to be revoked again rather than authorized by the TSA and unknown to
+// the broker.
+func (r *Registry) revoke(key store.WorkerKey) error {
+	err := r.writeTeam(key.TeamName, key.BindingID)
+	if err != nil {
+		return err
+	}
+	err = store.DeleteWorkerKey(r.store, key.BindingID)
+	if err != nil {
+		return err
+	}
+	r.logger.Info("revoked", lager.Data{"team-name": key.TeamName, "worker-name": key.WorkerName})
+	return nil
+}
+
+// pruneTeam prunes the stalled workers of a team that were registered through a binding whose key
+// is gone. Workers the team registered otherwise are left alone.
+func (r *Registry) pruneTeam(teamName string) ([]string, error) {
+	pruned := []string{}
+	keys, err := store.TeamWorkerKeys(r.store, teamName)
+	if err != nil {
+		return pruned, err
+	}
+	authorized := map[string]bool{}
+	for _, key := range keys {
+		authorized[key.WorkerName] = true
+	}
+	workers, err := r.concourseClient.ListTeamWorkers(teamName)
+	if err != nil {
+		return pruned, err
+	}
+	for _, worker := range workers {
+		if authorized[worker.Name] || !strings.HasPrefix(worker.Name, WorkerName(teamName, "")) {
+			continue
+		}
+		if worker.State != "stalled" {
+			continue
+		}
+		err = r.concourseClient.PruneWorker(worker.Name)
+		r.record(teamName, worker.Name, err)
+		if err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, worker.Name)
+	}
+	return pruned, nil
+}
+
+// writeTeam writes the authorized keys file of a team without the key of a binding, or removes it
+// when the team has no other keys.
+func (r *Registry) writeTeam(teamName, exceptBindingID string) error {
+	lines, err := r.authorizedKeys(teamName, exceptBindingID)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(r.dir, teamName)
+	if lines == "" {
+		err = os.Remove(file)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	err = os.MkdirAll(r.dir, 0700)
+	if err != nil {
+		return err
+	}
+	// the TSA must never read a half written file
+	tmp := file + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(lines), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func (r *Registry) record(teamName, workerName string, err error) {
+	entry := audit.Entry{
+		Time:      r.now().UTC(),
+		Operation: "prune-worker",
+		TeamName:  teamName,
+		Caller:    "broker",
+		Outcome:   audit.Succeeded,
+		Detail:    workerName,
+	}
+	if err != nil {
+		entry.Outcome = audit.Failed
+		entry.Error = err.Error()
+	}
+	auditErr := r.auditLog.Record(entry)
+	if auditErr != nil {
+		r.logger.Error("audit-error", auditErr)
+	}
+}
+
+// marshalPublicKey returns a public key in the format of an authorized_keys line, e.g. "ssh-rsa AAAA...".
+func marshalPublicKey(key *rsa.PublicKey) string {
+	buf := &bytes.Buffer{}
+	for _, part := range [][]byte{[]byte("ssh-rsa"), mpint(big.NewInt(int64(key.E))), mpint(key.N)} {
+		binary.Write(buf, binary.BigEndian, uint32(len(part)))
+		buf.Write(part)
+	}
+	return "ssh-rsa " + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// mpint returns a positive number as an SSH mpint, which needs a leading zero when the high bit is set.
+func mpint(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		return append([]byte{0}, b...)
+	}
+	return b
+}
+
+type byWorkerName []store.WorkerKey
+
+func (k byWorkerName) Len() int           { return len(k) }
+func (k byWorkerName) Less(i, j int) bool { return k[i].WorkerName < k[j].WorkerName }
+func (k byWorkerName) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
@@ -0,0 +1,13 @@
+package workerkeys
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestWorkerKeys(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Worker Keys Suite")
+}
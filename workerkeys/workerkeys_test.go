@@ -0,0 +1,146 @@
+package workerkeys
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/audit"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+	"github.com/vchrisr/concourse-broker/store"
+)
+
+var _ = Describe("Registry", func() {
+	var (
+		brokerStore     store.Store
+		auditLog        audit.Log
+		concourseClient *fakes.ConcourseClient
+		registry        *Registry
+		dir             string
+		instance        store.Instance
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "worker-keys")
+		Expect(err).NotTo(HaveOccurred())
+		brokerStore = store.NewMemoryStore()
+		auditLog = audit.NewStoreLog(brokerStore)
+		concourseClient = fakes.NewConcourseClient()
+		registry = New(brokerStore, auditLog, concourseClient, lagertest.NewTestLogger("worker-keys"), config.Env{
+			TSAHost:       "tsa.example.com",
+			TSAPort:       "2222",
+			WorkerKeysDir: dir,
+		})
+		instance = store.Instance{ID: "instance-1", TeamName: "venture"}
+		Expect(store.SaveInstance(brokerStore, instance)).To(Succeed())
+		Expect(store.SaveTeam(brokerStore, store.Team{Name: "venture", CreatedBy: "instance-1"})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	authorizedKeys := func() string {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, "venture"))
+		if os.IsNotExist(err) {
+			return ""
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return string(buf)
+	}
+
+	It("hands out a key pair and authorizes its public key for the team", func() {
+		credentials, err := registry.Register(instance, "binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials.TSAHost).To(Equal("tsa.example.com"))
+		Expect(credentials.TSAPort).To(Equal("2222"))
+		Expect(credentials.Team).To(Equal("venture"))
+		Expect(credentials.WorkerName).To(Equal("venture-worker-binding-1"))
+		Expect(authorizedKeys()).To(Equal(credentials.WorkerPublicKey + " venture-worker-binding-1\n"))
+
+		block, _ := pem.Decode([]byte(credentials.WorkerPrivateKey))
+		Expect(block).NotTo(BeNil())
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(marshalPublicKey(&privateKey.PublicKey)).To(Equal(credentials.WorkerPublicKey))
+		Expect(credentials.WorkerPublicKey).To(HavePrefix("ssh-rsa AAAAB3NzaC1yc2E"))
+	})
+
+	It("refuses to register without a TSA", func() {
+		registry.tsaHost = ""
+		_, err := registry.Register(instance, "binding-1")
+		Expect(err).To(MatchError(ContainSubstring("TSA_HOST")))
+	})
+
+	It("revokes the key and prunes the worker once it stalled", func() {
+		_, err := registry.Register(instance, "binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		concourseClient.Workers = []atc.Worker{
+			{Name: "venture-worker-binding-1", Team: "venture", State: "stalled"},
+			{Name: "venture-own", Team: "venture", State: "stalled"},
+		}
+
+		revoked, err := registry.Revoke("binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(revoked).To(BeTrue())
+		Expect(authorizedKeys()).To(BeEmpty())
+		Expect(concourseClient.Pruned).To(Equal([]string{"venture-worker-binding-1"}))
+
+		revoked, err = registry.Revoke("binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(revoked).To(BeFalse())
+	})
+
+	It("keeps the key when the authorized keys cannot be written", func() {
+		_, err := registry.Register(instance, "binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = registry.Register(instance, "binding-2")
+		Expect(err).NotTo(HaveOccurred())
+		blocked := filepath.Join(dir, "blocked")
+		Expect(ioutil.WriteFile(blocked, []byte{}, 0600)).To(Succeed())
+		registry.dir = filepath.Join(blocked, "keys")
+
+		_, err = registry.Revoke("binding-1")
+		Expect(err).To(HaveOccurred())
+		_, found, err := store.GetWorkerKey(brokerStore, "binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+	})
+
+	It("prunes revoked workers when they stall and revokes the keys of instances that are gone", func() {
+		_, err := registry.Register(instance, "binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = registry.Register(instance, "binding-2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.DeleteWorkerKey(brokerStore, "binding-2")).To(Succeed())
+		concourseClient.Workers = []atc.Worker{
+			{Name: "venture-worker-binding-1", Team: "venture", State: "stalled"},
+			{Name: "venture-worker-binding-2", Team: "venture", State: "running"},
+		}
+
+		report, err := registry.Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Revoked).To(BeEmpty())
+		Expect(report.Pruned).To(BeEmpty())
+
+		concourseClient.Workers[1].State = "stalled"
+		Expect(store.DeleteInstance(brokerStore, "instance-1")).To(Succeed())
+		report, err = registry.Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Revoked).To(Equal([]string{"binding-1"}))
+		Expect(report.Pruned).To(Equal([]string{"venture-worker-binding-1", "venture-worker-binding-2"}))
+		Expect(authorizedKeys()).To(BeEmpty())
+
+		entries, _ := auditLog.Query(audit.Query{TeamName: "venture"})
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Operation).To(Equal("prune-worker"))
+	})
+})
@@ -0,0 +1,222 @@
+package workers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/concourse/atc"
+	"github.com/vchrisr/concourse-broker/admission"
+	"github.com/vchrisr/concourse-broker/concourse"
+	"github.com/vchrisr/concourse-broker/config"
+)
+
+// running is the state of a worker that takes builds.
+const running = "running"
+
+// Status is what the last check found on the workers.
+type Status struct {
+	Time time.Time `json:"time"`
+	// Degraded is set while Concourse has fewer usable workers than WORKERS_MIN
+	Degraded   bool `json:"degraded"`
+	Workers    int  `json:"workers"`
+	MinWorkers int  `json:"min_workers"`
+	// ActiveContainers are the containers on the usable workers, the fewer the more capacity is free
+	ActiveContainers int    `json:"active_containers"`
+	Reason           string `json:"reason,omitempty"`
+	Error            string `json:"error,omitempty"`
+	// Target is the tag of the WORKERS_TARGETS new teams are placed on, the one with the most free
+	// capacity
+	Target  string   `json:"target,omitempty"`
+	Targets []Target `json:"targets,omitempty"`
+}
+
+// Target is what the last check found on the usable workers with the tag of a target.
+type Target struct {
+	Tag              string `json:"tag"`
+	Degraded         bool   `json:"degraded"`
+	Workers          int    `json:"workers"`
+	ActiveContainers int    `json:"active_containers"`
+}
+
+// Checker counts the running workers a new team can use: the ones that belong to no team and
+// have the WORKERS_TAGS and one of the WORKERS_PLATFORMS. With WORKERS_TARGETS the workers are
+// counted by target, and new teams are placed on the target with the most free capacity.
+type Checker struct {
+	minWorkers      int
+	tags            []string
+	platforms       []string
+	targets         []string
+	concourseClient concourse.Client
+	logger          lager.Logger
+	now             func() time.Time
+
+	mu   sync.Mutex
+	last Status
+}
+
+// New returns a checker.
+func New(env config.Env, concourseClient concourse.Client, logger lager.Logger) *Checker {
+	return &Checker{
+		minWorkers:      env.WorkersMin,
+		tags:            env.WorkersTags,
+		platforms:       env.WorkersPlatforms,
+		targets:         env.WorkersTargets,
+		concourseClient: concourseClient,
+		logger:          logger.Session("workers"),
+		now:             time.Now,
+	}
+}
+
+// Enabled tells whether WORKERS_MIN or WORKERS_TARGETS is set.
+func (c *Checker) Enabled() bool {
+	return c != nil && (c.minWorkers > 0 || len(c.targets) > 0)
+}
+
+// IsTarget tells whether a worker tag is the tag of one of the WORKERS_TARGETS.
+func (c *Checker) IsTarget(tag string) bool {
+	return c != nil && tag != "" && contains(c.targets, tag)
+}
+
+// Check returns the tag of the target to place a new team on, empty without WORKERS_TARGETS, or a
+// RejectedError when Concourse has too few usable workers to provision a team.
+func (c *Checker) Check() (string, error) {
+	status, err := c.Run()
+	if err != nil {
+		return "", fmt.Errorf("Could not check the Concourse workers: %v", err)
+	}
+	if status.Degraded {
+		return "", &admission.RejectedError{Rule: "WORKERS_MIN", Reason: status.Reason}
+	}
+	return status.Target, nil
+}
+
+// Run checks the workers once.
+func (c *Checker) Run() (Status, error) {
+	status := Status{Time: c.now().UTC(), MinWorkers: c.minWorkers}
+	err := c.run(&status)
+	if err != nil {
+		status.Degraded = true
+		status.Error = err.Error()
+	}
+	c.mu.Lock()
+	wasDegraded := c.last.Degraded
+	c.last = status
+	c.mu.Unlock()
+	if status.Degraded && !wasDegraded {
+		c.logger.Info("degraded", lager.Data{"reason": status.Reason, "error": status.Error})
+	} else if !status.Degraded && wasDegraded {
+		c.logger.Info("recovered", lager.Data{"workers": status.Workers})
+	}
+	return status, err
+}
+
+// LastStatus returns the status of the most recent check.
+func (c *Checker) LastStatus() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+func (c *Checker) run(status *Status) error {
+	if !c.Enabled() {
+		return nil
+	}
+	workers, err := c.concourseClient.ListWorkers()
+	if err != nil {
+		return err
+	}
+	for _, worker := range workers {
+		if !c.usable(worker) {
+			continue
+		}
+		status.Workers++
+		status.ActiveContainers += worker.ActiveContainers
+	}
+	if len(c.targets) > 0 {
+		c.pick(status, workers)
+		return nil
+	}
+	if status.Workers < c.minWorkers {
+		status.Degraded = true
+		status.Reason = fmt.Sprintf("Concourse has %d of the %d running workers %s needed to run builds",
+			status.Workers, c.minWorkers, c.describe())
+	}
+	return nil
+}
+
+// pick counts the usable workers of every target and picks the target with the fewest active
+// containers per worker among the ones with enough workers. A target needs at least one worker
+// even without WORKERS_MIN. Concourse is degraded when no target has enough.
+func (c *Checker) pick(status *Status, workers []atc.Worker) {
+	minWorkers := c.minWorkers
+	if minWorkers < 1 {
+		minWorkers = 1
+	}
+	picked := -1
+	for _, tag := range c.targets {
+		target := Target{Tag: tag}
+		for _, worker := range workers {
+			if c.usable(worker) && contains(worker.Tags, tag) {
+				target.Workers++
+				target.ActiveContainers += worker.ActiveContainers
+			}
+		}
+		target.Degraded = target.Workers < minWorkers
+		if !target.Degraded && (picked < 0 || busier(status.Targets[picked], target)) {
+			picked = len(status.Targets)
+		}
+		status.Targets = append(status.Targets, target)
+	}
+	if picked < 0 {
+		status.Degraded = true
+		status.Reason = fmt.Sprintf("none of the targets %s has the %d running workers %s needed to run builds",
+			strings.Join(c.targets, ", "), minWorkers, c.describe())
+		return
+	}
+	status.Target = status.Targets[picked].Tag
+}
+
+// busier tells whether a target has more active containers per worker than another.
+func busier(target, other Target) bool {
+	return target.ActiveContainers*other.Workers > other.ActiveContainers*target.Workers
+}
+
+func (c *Checker) usable(worker atc.Worker) bool {
+	// team workers only run the builds of their team
+	if worker.State != running || worker.Team != "" {
+		return false
+	}
+	for _, tag := range c.tags {
+		if !contains(worker.Tags, tag) {
+			return false
+		}
+	}
+	return len(c.platforms) == 0 || contains(c.platforms, worker.Platform)
+}
+
+// describe returns what a usable worker needs in words, e.g. "tagged ssd on linux".
+func (c *Checker) describe() string {
+	parts := []string{}
+	if len(c.tags) > 0 {
+		parts = append(parts, "tagged "+strings.Join(c.tags, ", "))
+	}
+	if len(c.platforms) > 0 {
+		parts = append(parts, "on "+strings.Join(c.platforms, " or "))
+	}
+	if len(parts) == 0 {
+		return "shared by all teams"
+	}
+	return strings.Join(parts, " ")
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package workers
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/concourse/atc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vchrisr/concourse-broker/admission"
+	"github.com/vchrisr/concourse-broker/config"
+	"github.com/vchrisr/concourse-broker/fakes"
+)
+
+var _ = Describe("Checker", func() {
+	var (
+		concourseClient *fakes.ConcourseClient
+		env             config.Env
+	)
+
+	newChecker := func() *Checker {
+		return New(env, concourseClient, lagertest.NewTestLogger("workers"))
+	}
+
+	BeforeEach(func() {
+		concourseClient = fakes.NewConcourseClient()
+		concourseClient.Workers = []atc.Worker{
+			{Name: "linux-1", State: "running", Platform: "linux", Tags: []string{"ssd"}, ActiveContainers: 10},
+			{Name: "linux-2", State: "running", Platform: "linux", ActiveContainers: 4},
+			{Name: "stalled", State: "stalled", Platform: "linux", Tags: []string{"ssd"}},
+			{Name: "team", State: "running", Platform: "linux", Tags: []string{"ssd"}, Team: "main"},
+			{Name: "windows", State: "running", Platform: "windows"},
+		}
+		env = config.Env{WorkersMin: 2}
+	})
+
+	It("is disabled without a minimum", func() {
+		env.WorkersMin = 0
+		Expect(newChecker().Enabled()).To(BeFalse())
+		var checker *Checker
+		Expect(checker.Enabled()).To(BeFalse())
+	})
+
+	It("counts the running workers every team can use", func() {
+		checker := newChecker()
+		target, err := checker.Check()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(BeEmpty())
+		status := checker.LastStatus()
+		Expect(status.Degraded).To(BeFalse())
+		Expect(status.Workers).To(Equal(3))
+		Expect(status.ActiveContainers).To(Equal(14))
+	})
+
+	It("refuses when too few workers have the tags and platforms", func() {
+		env.WorkersTags = []string{"ssd"}
+		env.WorkersPlatforms = []string{"linux"}
+		checker := newChecker()
+		_, err := checker.Check()
+		Expect(err).To(BeAssignableToTypeOf(&admission.RejectedError{}))
+		Expect(err).To(MatchError("Rejected by WORKERS_MIN: Concourse has 1 of the 2 running workers tagged ssd on linux needed to run builds"))
+		Expect(checker.LastStatus().Degraded).To(BeTrue())
+
+		concourseClient.Workers = append(concourseClient.Workers, atc.Worker{State: "running", Platform: "linux", Tags: []string{"ssd", "gpu"}})
+		_, err = checker.Check()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(checker.LastStatus().Degraded).To(BeFalse())
+	})
+
+	It("counts Concourse as degraded when the workers cannot be listed", func() {
+		concourseClient.Err = errors.New("concourse is down")
+		checker := newChecker()
+		_, err := checker.Check()
+		Expect(err).To(MatchError("Could not check the Concourse workers: concourse is down"))
+		Expect(checker.LastStatus().Degraded).To(BeTrue())
+	})
+	Describe("with several targets", func() {
+		BeforeEach(func() {
+			env = config.Env{WorkersTargets: []string{"pool-a", "pool-b", "pool-c"}}
+			concourseClient.Workers = []atc.Worker{
+				{Name: "a-1", State: "running", Tags: []string{"pool-a"}, ActiveContainers: 30},
+				{Name: "b-1", State: "running", Tags: []string{"pool-b"}, ActiveContainers: 20},
+				{Name: "b-2", State: "running", Tags: []string{"pool-b"}, ActiveContainers: 20},
+				{Name: "c-1", State: "stalled", Tags: []string{"pool-c"}},
+			}
+		})
+
+		It("picks the target with the most free capacity", func() {
+			Expect(newChecker().Enabled()).To(BeTrue())
+			checker := newChecker()
+			target, err := checker.Check()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target).To(Equal("pool-b"))
+			Expect(checker.LastStatus().Targets).To(Equal([]Target{
+				{Tag: "pool-a", Workers: 1, ActiveContainers: 30},
+				{Tag: "pool-b", Workers: 2, ActiveContainers: 40},
+				{Tag: "pool-c", Degraded: true},
+			}))
+			Expect(checker.IsTarget("pool-c")).To(BeTrue())
+			Expect(checker.IsTarget("ssd")).To(BeFalse())
+		})
+
+		It("leaves out the targets with too few workers", func() {
+			env.WorkersMin = 2
+			target, err := newChecker().Check()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target).To(Equal("pool-b"))
+
+			env.WorkersMin = 3
+			_, err = newChecker().Check()
+			Expect(err).To(MatchError("Rejected by WORKERS_MIN: none of the targets pool-a, pool-b, pool-c has the 3 running workers shared by all teams needed to run builds"))
+		})
+	})
+})